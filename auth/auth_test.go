@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	http_server "github.com/masx200/http-proxy-go-server/http"
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+// 带凭据的普通 HTTP 请求经前端身份验证后转发给同样要求凭据的内部 HTTP 代理，再到达源站
+func TestHandleAuthenticatedPlainHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+
+	// 内部 HTTP 代理与 Auth 中一样使用相同的用户名和密码
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	go http_server.Http("127.0.0.1", port, nil, nil, "user", "pass", false, options.ParseIPPriority("random"), nil)
+	upstreamAddress := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", upstreamAddress)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			client, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go Handle(client, "user", "pass", upstreamAddress, nil, nil, false, options.ParseIPPriority("random"), nil)
+		}
+	}()
+
+	for _, tc := range []struct {
+		user *url.Userinfo
+		want int
+	}{
+		{url.UserPassword("user", "pass"), http.StatusOK},
+		{nil, http.StatusProxyAuthRequired},
+		{url.UserPassword("user", "wrong"), http.StatusProxyAuthRequired},
+	} {
+		client := &http.Client{Transport: &http.Transport{
+			Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: l.Addr().String(), User: tc.user}),
+		}}
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("user %v: expected status %d, got %d %q", tc.user, tc.want, resp.StatusCode, body)
+		} else if tc.want == http.StatusOK && string(body) != "hello" {
+			t.Errorf("Expected body %q, got %q", "hello", body)
+		}
+	}
+}
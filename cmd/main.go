@@ -21,6 +21,10 @@ import (
 	"github.com/masx200/http-proxy-go-server/config"
 	"github.com/masx200/http-proxy-go-server/dnscache"
 	"github.com/masx200/http-proxy-go-server/doh"
+	"github.com/masx200/http-proxy-go-server/hosts"
+	http_server "github.com/masx200/http-proxy-go-server/http"
+	"github.com/masx200/http-proxy-go-server/masque"
+	"github.com/masx200/http-proxy-go-server/mitm"
 	"github.com/masx200/http-proxy-go-server/options"
 	"github.com/masx200/http-proxy-go-server/simple"
 	"github.com/masx200/http-proxy-go-server/tls"
@@ -41,6 +45,7 @@ type DohConfig = config.DohConfig
 type DotConfig = config.DotConfig
 type DoqConfig = config.DoqConfig
 type DNSCacheConfig = config.DNSCacheConfig
+type DNSRule = config.DNSRule
 
 // 全局DNS缓存实例
 var (
@@ -57,6 +62,15 @@ type CacheConfig struct {
 	SaveInterval time.Duration `json:"save_interval"`
 	AOFInterval  time.Duration `json:"aof_interval"`
 	AOFEnabled   bool          `json:"aof_enabled"`
+	MaxEntries   int           `json:"max_entries"`
+	MaxBytes     int64         `json:"max_bytes"`
+	AppendFsync  string        `json:"appendfsync"`
+	Backend      string        `json:"backend"`
+	DBPath       string        `json:"db_path"`
+	RedisURL     string        `json:"redis_url"`
+	RedisPrefix  string        `json:"redis_prefix"`
+	PinsPath     string        `json:"pins_path"`
+	HitsPath     string        `json:"hits_path"`
 }
 
 // DefaultCacheConfig 返回默认缓存配置
@@ -69,6 +83,13 @@ func DefaultCacheConfig() *CacheConfig {
 		SaveInterval: 30 * time.Second,
 		AOFInterval:  1 * time.Second,
 		AOFEnabled:   true,
+		AppendFsync:  string(dnscache.AppendFsyncEverySec),
+		Backend:      string(dnscache.BackendFile),
+		DBPath:       "./dns_cache.db",
+		RedisURL:     "redis://127.0.0.1:6379/0",
+		RedisPrefix:  dnscache.DefaultRedisPrefix,
+		PinsPath:     "./dns_cache_pins.json",
+		HitsPath:     "./dns_cache_hits.json",
 	}
 }
 
@@ -88,6 +109,15 @@ func InitDNSCache(config *CacheConfig) error {
 			SaveInterval: config.SaveInterval,
 			AOFInterval:  config.AOFInterval,
 			Enabled:      config.Enabled,
+			MaxEntries:   config.MaxEntries,
+			MaxBytes:     config.MaxBytes,
+			AppendFsync:  dnscache.AppendFsync(config.AppendFsync),
+			Backend:      dnscache.Backend(config.Backend),
+			DBPath:       config.DBPath,
+			RedisURL:     config.RedisURL,
+			RedisPrefix:  config.RedisPrefix,
+			PinsPath:     config.PinsPath,
+			HitsPath:     config.HitsPath,
 		}
 
 		globalDNSCache, err = dnscache.NewWithConfig(dnscacheConfig)
@@ -123,6 +153,17 @@ func (m *multiString) Set(value string) error {
 	return nil
 }
 
+// appendDNSStampOption 解析 sdns:// 格式的 DNS stamp 并追加到DNS配置中，格式错误时退出
+func appendDNSStampOption(proxyoptions *options.ProxyOptionsDNSSLICE, stamp string) {
+	opt, err := options.ParseDNSStamp(stamp, nil)
+	if err != nil {
+		log.Printf("DNS stamp 解析失败: %v\n", err)
+		os.Exit(1)
+	}
+	log.Println("DNS stamp:", opt.GetProtocol(), opt.ServerURL())
+	*proxyoptions = append(*proxyoptions, opt)
+}
+
 // loadConfig 从配置文件加载并验证配置
 func loadConfig(configFile string) (*config.Config, error) {
 	return config.LoadAndValidateConfig(configFile)
@@ -431,6 +472,73 @@ func IsBypassedWithCIDR(upstreams map[string]config.UpStream, rules []config.Rou
 	return false
 }
 
+// SelectOutboundWithCIDR 选择连接 host 时使用的出站配置：
+// host 是某个上游代理的地址时使用该上游的配置，否则使用第一个匹配且配置了 outbound 的路由规则
+func SelectOutboundWithCIDR(upstreams map[string]config.UpStream, rules []config.RoutingRule, filters map[string]config.Filter, host string) (options.OutboundConfig, bool) {
+	for _, upstream := range upstreams {
+		if upstream.Outbound == (config.OutboundConfig{}) {
+			continue
+		}
+		for _, proxy := range []string{upstream.HTTP_PROXY, upstream.HTTPS_PROXY, upstream.SOCKS5_PROXY, upstream.WS_PROXY} {
+			if proxy == "" {
+				continue
+			}
+			if !strings.Contains(proxy, "://") {
+				proxy = "ws://" + proxy
+			}
+			if u, err := url.Parse(proxy); err == nil && u.Hostname() == host {
+				return options.OutboundConfig(upstream.Outbound), true
+			}
+		}
+	}
+
+	ip := net.ParseIP(host)
+	for _, rule := range rules {
+		if rule.Outbound == (config.OutboundConfig{}) {
+			continue
+		}
+		if filter, exists := filters[rule.Filter]; exists && filterMatchesHost(filter, host, ip) {
+			return options.OutboundConfig(rule.Outbound), true
+		}
+	}
+	return options.OutboundConfig{}, false
+}
+
+// SelectInterceptWithCIDR 返回 host 是否需要拦截：与选择上游代理一样按顺序取第一条匹配 host 的路由规则，
+// 由这条规则的 intercept 决定，后面的规则即使设置了 intercept 也不再考虑
+func SelectInterceptWithCIDR(rules []config.RoutingRule, filters map[string]config.Filter, host string) bool {
+	ip := net.ParseIP(host)
+	for _, rule := range rules {
+		if filter, exists := filters[rule.Filter]; exists && filterMatchesHost(filter, host, ip) {
+			return rule.Intercept
+		}
+	}
+	return false
+}
+
+// filterMatchesHost 检查 filter 中是否有模式匹配 host，ip 是 host 解析出的 IP（域名时为 nil）
+func filterMatchesHost(filter config.Filter, host string, ip net.IP) bool {
+	for _, pattern := range filter.Patterns {
+		var matched bool
+		if pattern == "*" {
+			matched = true
+		} else if ip != nil {
+			if strings.Contains(pattern, "/") {
+				_, ipNet, err := net.ParseCIDR(pattern)
+				matched = err == nil && ipNet.Contains(ip)
+			} else {
+				matched = pattern == host || strings.HasPrefix(host, pattern)
+			}
+		} else if !strings.Contains(pattern, "/") {
+			matched = matchWildcard(pattern, host) || strings.Contains(host, pattern)
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
 // ProxySelector 使用SelectProxyURLWithCIDR和IsBypassedWithCIDR实现代理选择逻辑，支持WebSocket代理
 func ProxySelector(r *http.Request, UpStreams map[string]config.UpStream, Rules []config.RoutingRule, Filters map[string]config.Filter) (*url.URL, error) {
 	scheme := r.URL.Scheme
@@ -445,6 +553,11 @@ func ProxySelector(r *http.Request, UpStreams map[string]config.UpStream, Rules
 		host = strings.Split(host, ":")[0]
 	}
 
+	// fake-IP 模式下将 fake-IP 还原为域名，使基于域名的规则生效
+	if domain, ok := GetDNSCache().LookupFakeIP(net.ParseIP(host)); ok {
+		host = domain
+	}
+
 	// 检查是否应该被绕过
 	if IsBypassedWithCIDR(UpStreams, Rules, Filters, host) {
 		return nil, nil
@@ -479,13 +592,17 @@ func main() {
 
 	// 自定义字符串切片类型，实现 flag.Value 接口
 	var (
-		dohurls  multiString
-		dohips   multiString
-		dohalpns multiString
-		doturls  multiString
-		dotips   multiString
-		doqurls  multiString
-		doqips   multiString
+		dohurls            multiString
+		dohips             multiString
+		dohalpns           multiString
+		doturls            multiString
+		dotips             multiString
+		doqurls            multiString
+		doqips             multiString
+		dnsrules           multiString
+		dnssecTrustAnchors multiString
+		hostsFiles         multiString
+		hostEntries        multiString
 	)
 	// 注册可重复参数
 	flag.Var(&dohurls, "dohurl", "DOH URL (可重复),支持http协议和https协议")
@@ -495,6 +612,10 @@ func main() {
 	flag.Var(&dotips, "dotip", "DoT IP (可重复),支持ipv4地址和ipv6地址")
 	flag.Var(&doqurls, "doqurl", "DoQ URL (可重复),格式为 quic://dns.example.com:853")
 	flag.Var(&doqips, "doqip", "DoQ IP (可重复),支持ipv4地址和ipv6地址")
+	flag.Var(&dnsrules, "dns-rule", "分流DNS规则 (可重复),格式为 域名模式[,域名模式]=DNS服务器[,DNS服务器],例如 *.corp.example=udp://10.0.0.53")
+	flag.Var(&hostsFiles, "hosts-file", "hosts 文件路径 (可重复),默认使用系统 hosts 文件,文件修改后自动重新加载")
+	flag.Var(&hostEntries, "host", "内联 hosts 条目 (可重复),格式为 域名=IP[,IP],域名支持通配符,例如 *.dev.local=127.0.0.1")
+	flag.Var(&dnssecTrustAnchors, "dnssec-trust-anchor", "DNSSEC 根区信任锚 (可重复),DS 记录格式,例如 \". IN DS 20326 8 2 E06D...\",默认使用内置的根区 KSK")
 
 	var (
 		hostname    = flag.String("hostname", "0.0.0.0", "an String value for hostname")
@@ -517,10 +638,71 @@ func main() {
 		cacheAOFEnabled  = flag.Bool("cache-aof-enabled", true, "enable DNS cache AOF (append-only file) persistence")
 		cacheAOFFile     = flag.String("cache-aof-file", "./dns_cache.aof", "DNS cache AOF file path")
 		cacheAOFInterval = flag.String("cache-aof-interval", "1s", "DNS cache AOF save interval (duration string, e.g., 1s, 5s)")
+		// DNS缓存容量限制
+		cacheMaxEntries = flag.Int("cache-max-entries", 0, "maximum number of DNS cache entries, least recently used entries are evicted (0 = unlimited)")
+		cacheMaxBytes   = flag.Int64("cache-max-bytes", 0, "approximate maximum DNS cache memory in bytes, least recently used entries are evicted (0 = unlimited)")
+		// DNS缓存持久化相关参数
+		cacheAppendFsync = flag.String("cache-appendfsync", "everysec", "DNS cache AOF fsync policy: always, everysec or no")
+		cacheRepairAOF   = flag.String("cache-repair-aof", "", "truncate the given DNS cache AOF file at its last valid record and exit")
+		cacheBackend     = flag.String("cache-backend", "file", "DNS cache storage backend: file (JSON snapshot + AOF), bbolt or redis")
+		cacheDBFile      = flag.String("cache-db-file", "./dns_cache.db", "DNS cache bbolt database file path")
+		cacheRedisURL    = flag.String("cache-redis-url", "redis://127.0.0.1:6379/0", "DNS cache redis backend URL, shared by multiple proxy instances")
+		cacheRedisPrefix = flag.String("cache-redis-prefix", dnscache.DefaultRedisPrefix, "DNS cache redis key prefix (also used for the invalidation channel)")
+		cachePinsFile    = flag.String("cache-pins-file", "./dns_cache_pins.json", "file storing DNS cache pinned (static override) records")
+		cacheAdminAddr   = flag.String("cache-admin-addr", "", "DNS cache admin HTTP API listen address, e.g. 127.0.0.1:6061 (empty to disable)")
+		// DNS缓存预热相关参数
+		cacheHitsFile          = flag.String("cache-hits-file", "./dns_cache_hits.json", "file storing per-domain DNS request counts used by -cache-warmup-top")
+		cacheWarmupFile        = flag.String("cache-warmup-file", "", "file with one domain per line to resolve into the DNS cache at startup")
+		cacheWarmupTop         = flag.Int("cache-warmup-top", 0, "resolve the N most requested domains from the persisted hit statistics at startup (0 = disabled)")
+		cacheWarmupConcurrency = flag.Int("cache-warmup-concurrency", dnscache.DefaultWarmupConcurrency, "maximum number of concurrent DNS lookups during warm-up")
+		// fake-IP 模式相关参数
+		fakeIPEnabled   = flag.Bool("fake-ip", false, "enable fake-IP DNS mode (requires DNS cache)")
+		fakeIPRange     = flag.String("fake-ip-range", "198.18.0.0/15", "fake-IP address range (IPv4 CIDR)")
+		fakeIPDNSListen = flag.String("fake-ip-dns-listen", "127.0.0.1:5353", "listen address of the local fake-IP DNS server")
+		fakeIPTTL       = flag.String("fake-ip-ttl", "24h", "how long a domain to fake-IP mapping is kept")
+		// DNSSEC 验证相关参数
+		dnssecEnabled = flag.Bool("dnssec", false, "validate DNSSEC signatures of resolved answers and reject bogus responses")
+		// EDNS Client Subnet 相关参数
+		ecsMode     = flag.String("ecs", "", "EDNS Client Subnet for DNS queries: strip, client (derive from the connecting client's IP) or a fixed IP/CIDR")
+		ecsPrefixV4 = flag.Int("ecs-prefix-v4", 24, "IPv4 prefix length used by -ecs client")
+		ecsPrefixV6 = flag.Int("ecs-prefix-v6", 56, "IPv6 prefix length used by -ecs client")
+		// HTTPS/SVCB 记录相关参数
+		httpsRecords = flag.Bool("https-records", false, "query HTTPS/SVCB records to use IP hints, alternative ports and HTTP/3 when dialing")
 		// 上游代理IP解析相关参数
 		upstreamResolveIPs = flag.Bool("upstream-resolve-ips", false, "resolve upstream proxy domains to IP addresses before connection to bypass DNS pollution")
 		// IPv6/IPv4 优先策略相关参数
 		ipPriorityStr = flag.String("ip-priority", "random", "IP address priority strategy: ipv4 (IPv4优先), ipv6 (IPv6优先), random (IPv4和IPv6随机)")
+		// Happy Eyeballs 相关参数
+		happyEyeballsDelay = flag.String("happy-eyeballs-delay", "250ms", "delay between staggered connection attempts to resolved addresses (RFC 8305), 0 dials them one by one")
+		// 出站连接相关参数
+		bindAddress   = flag.String("bind-address", "", "source IP address for outgoing connections, one IPv4 and one IPv6 address may be given separated by a comma")
+		bindInterface = flag.String("bind-interface", "", "network interface to bind outgoing connections to (SO_BINDTODEVICE, Linux only)")
+		fwmark        = flag.Int("fwmark", 0, "firewall mark for outgoing connections (SO_MARK, Linux only)")
+		tcpKeepAlive  = flag.String("tcp-keepalive", "", "TCP keepalive period for outgoing connections, e.g. 30s (empty uses the system default, -1s disables)")
+		// 熔断相关参数
+		circuitBreakerFailures = flag.Int("circuit-breaker-failures", options.DefaultCircuitBreakerFailures, "consecutive connection failures before a destination, upstream proxy or DNS server is failed fast (0 disables the circuit breaker)")
+		circuitBreakerTimeout  = flag.String("circuit-breaker-timeout", "30s", "how long an open circuit fails fast before a single probe connection is allowed")
+		// 转发标识头相关参数
+		viaPseudonym  = flag.String("via", options.DefaultViaPseudonym, "proxy name added to the Via header of forwarded requests and responses, empty or \"off\" disables Via")
+		xForwardedFor = flag.Bool("x-forwarded-for", false, "add X-Forwarded-For and X-Real-IP headers alongside Forwarded")
+		anonymous     = flag.Bool("anonymous", false, "strip all identifying headers (Forwarded, Via, X-Forwarded-For, X-Real-IP) from forwarded requests")
+		// 内部 HTTP 代理共享 Transport 相关参数
+		maxIdleConns          = flag.Int("max-idle-conns", http_server.DefaultTransportOptions.MaxIdleConns, "maximum idle connections kept across all origins and upstreams by the internal HTTP proxy (0 means no limit)")
+		maxIdleConnsPerHost   = flag.Int("max-idle-conns-per-host", http_server.DefaultTransportOptions.MaxIdleConnsPerHost, "maximum idle connections kept per origin or upstream by the internal HTTP proxy")
+		idleConnTimeout       = flag.String("idle-conn-timeout", "90s", "how long an idle pooled connection is kept by the internal HTTP proxy")
+		responseHeaderTimeout = flag.String("response-header-timeout", "0s", "how long to wait for response headers after sending a request, 0 means no timeout")
+		disableHTTP2          = flag.Bool("disable-http2", false, "do not negotiate HTTP/2 with HTTPS origins in the internal HTTP proxy")
+		http2PingInterval     = flag.String("http2-ping-interval", "0s", "send a PING on HTTP/2 connections idle for this long to detect dead connections, 0 disables health checks")
+		// 明文端口接受 HTTP/2 prior knowledge（h2c）
+		h2c = flag.Bool("h2c", false, "accept HTTP/2 prior-knowledge (h2c) connections on the plain proxy port, TLS ports always offer h2 via ALPN")
+		// HTTP/3（MASQUE）监听端口
+		masquePort = flag.Int("masque-port", 0, "UDP port for the HTTP/3 (MASQUE) proxy listener with CONNECT and connect-udp, requires server_cert and server_key, 0 disables")
+		// UDP 会话空闲超时
+		udpIdleTimeout = flag.String("udp-idle-timeout", "2m", "close a connect-udp session after no datagrams in either direction for this long")
+		// TLS 拦截（MITM）使用的本地 CA
+		mitmCACert        = flag.String("mitm-ca-cert", "", "PEM CA certificate used to sign leaf certificates for CONNECT tunnels intercepted by rules with intercept: true")
+		mitmCAKey         = flag.String("mitm-ca-key", "", "PEM private key of the MITM CA certificate")
+		mitmCertCacheSize = flag.Int("mitm-cert-cache-size", mitm.DefaultCertCacheSize, "number of issued leaf certificates kept in the in-memory LRU cache")
 		// pprof性能分析相关参数
 		enablePprof   = flag.Bool("enable-pprof", false, "enable pprof profiling server for performance analysis")
 		pprofPort     = flag.Int("pprof-port", 6060, "pprof server port (default: 6060)")
@@ -528,6 +710,17 @@ func main() {
 	)
 	flag.Parse()
 
+	// 修复AOF文件后退出
+	if *cacheRepairAOF != "" {
+		removed, err := dnscache.RepairAOF(*cacheRepairAOF)
+		if err != nil {
+			log.Printf("修复AOF文件失败: %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("AOF文件修复完成: %s，截掉 %d 字节无效数据", *cacheRepairAOF, removed)
+		os.Exit(0)
+	}
+
 	// 启动pprof性能分析服务器（如果启用）
 	if *enablePprof {
 		pprofAddr := fmt.Sprintf("%s:%d", *pprofBindAddr, *pprofPort)
@@ -549,6 +742,28 @@ func main() {
 
 	log.Println("upstream-resolve-ips:", *upstreamResolveIPs)
 	log.Println("ip-priority:", *ipPriorityStr)
+	log.Println("happy-eyeballs-delay:", *happyEyeballsDelay)
+	log.Println("bind-address:", *bindAddress)
+	log.Println("bind-interface:", *bindInterface)
+	log.Println("fwmark:", *fwmark)
+	log.Println("tcp-keepalive:", *tcpKeepAlive)
+	log.Println("circuit-breaker-failures:", *circuitBreakerFailures)
+	log.Println("circuit-breaker-timeout:", *circuitBreakerTimeout)
+	log.Println("via:", *viaPseudonym)
+	log.Println("x-forwarded-for:", *xForwardedFor)
+	log.Println("anonymous:", *anonymous)
+	log.Println("max-idle-conns:", *maxIdleConns)
+	log.Println("max-idle-conns-per-host:", *maxIdleConnsPerHost)
+	log.Println("idle-conn-timeout:", *idleConnTimeout)
+	log.Println("response-header-timeout:", *responseHeaderTimeout)
+	log.Println("disable-http2:", *disableHTTP2)
+	log.Println("http2-ping-interval:", *http2PingInterval)
+	log.Println("h2c:", *h2c)
+	log.Println("masque-port:", *masquePort)
+	log.Println("udp-idle-timeout:", *udpIdleTimeout)
+	log.Println("mitm-ca-cert:", *mitmCACert)
+	log.Println("mitm-ca-key:", *mitmCAKey)
+	log.Println("mitm-cert-cache-size:", *mitmCertCacheSize)
 	log.Println("代理服务器启动中...")
 
 	// 如果指定了配置文件，则从配置文件读取参数
@@ -641,6 +856,18 @@ func main() {
 	log.Println("cache-aof-enabled:", *cacheAOFEnabled)
 	log.Println("cache-aof-file:", *cacheAOFFile)
 	log.Println("cache-aof-interval:", *cacheAOFInterval)
+	log.Println("cache-max-entries:", *cacheMaxEntries)
+	log.Println("cache-max-bytes:", *cacheMaxBytes)
+	log.Println("cache-appendfsync:", *cacheAppendFsync)
+	log.Println("cache-backend:", *cacheBackend)
+	log.Println("cache-db-file:", *cacheDBFile)
+	log.Println("cache-redis-prefix:", *cacheRedisPrefix)
+	log.Println("cache-pins-file:", *cachePinsFile)
+	log.Println("cache-admin-addr:", *cacheAdminAddr)
+	log.Println("cache-hits-file:", *cacheHitsFile)
+	log.Println("cache-warmup-file:", *cacheWarmupFile)
+	log.Println("cache-warmup-top:", *cacheWarmupTop)
+	log.Println("cache-warmup-concurrency:", *cacheWarmupConcurrency)
 
 	// 如果指定了配置文件，则从配置文件读取DNS缓存配置
 	if config != nil {
@@ -665,6 +892,77 @@ func main() {
 		if config.DNSCache.AOFInterval != "" {
 			*cacheAOFInterval = config.DNSCache.AOFInterval
 		}
+		if config.DNSCache.MaxEntries > 0 {
+			*cacheMaxEntries = config.DNSCache.MaxEntries
+		}
+		if config.DNSCache.MaxBytes > 0 {
+			*cacheMaxBytes = config.DNSCache.MaxBytes
+		}
+		if config.DNSCache.AppendFsync != "" {
+			*cacheAppendFsync = config.DNSCache.AppendFsync
+		}
+		if config.DNSCache.Backend != "" {
+			*cacheBackend = config.DNSCache.Backend
+		}
+		if config.DNSCache.DBFile != "" {
+			*cacheDBFile = config.DNSCache.DBFile
+		}
+		if config.DNSCache.RedisURL != "" {
+			*cacheRedisURL = config.DNSCache.RedisURL
+		}
+		if config.DNSCache.RedisPrefix != "" {
+			*cacheRedisPrefix = config.DNSCache.RedisPrefix
+		}
+		if config.DNSCache.PinsFile != "" {
+			*cachePinsFile = config.DNSCache.PinsFile
+		}
+		if config.DNSCache.AdminAddr != "" {
+			*cacheAdminAddr = config.DNSCache.AdminAddr
+		}
+		if config.DNSCache.HitsFile != "" {
+			*cacheHitsFile = config.DNSCache.HitsFile
+		}
+		if config.DNSCache.WarmupFile != "" {
+			*cacheWarmupFile = config.DNSCache.WarmupFile
+		}
+		if config.DNSCache.WarmupTop > 0 {
+			*cacheWarmupTop = config.DNSCache.WarmupTop
+		}
+		if config.DNSCache.WarmupConcurrency > 0 {
+			*cacheWarmupConcurrency = config.DNSCache.WarmupConcurrency
+		}
+	}
+	// 从配置文件读取 fake-IP 配置
+	if config != nil && config.FakeIP.Enabled {
+		*fakeIPEnabled = true
+		if config.FakeIP.Range != "" {
+			*fakeIPRange = config.FakeIP.Range
+		}
+		if config.FakeIP.Listen != "" {
+			*fakeIPDNSListen = config.FakeIP.Listen
+		}
+		if config.FakeIP.TTL != "" {
+			*fakeIPTTL = config.FakeIP.TTL
+		}
+	}
+	// 从配置文件读取 DNSSEC 配置
+	if config != nil && config.DNSSEC.Enabled {
+		*dnssecEnabled = true
+		dnssecTrustAnchors = append(dnssecTrustAnchors, config.DNSSEC.TrustAnchors...)
+	}
+	// 从配置文件读取 EDNS Client Subnet 配置
+	if config != nil && config.ECS.Mode != "" {
+		*ecsMode = config.ECS.Mode
+		if config.ECS.PrefixV4 > 0 {
+			*ecsPrefixV4 = config.ECS.PrefixV4
+		}
+		if config.ECS.PrefixV6 > 0 {
+			*ecsPrefixV6 = config.ECS.PrefixV6
+		}
+	}
+	// 从配置文件读取 HTTPS/SVCB 记录配置
+	if config != nil && config.HTTPSRecords {
+		*httpsRecords = true
 	}
 	// 加载上游代理IP解析配置
 	if config != nil && config.UpstreamResolveIPs {
@@ -675,6 +973,167 @@ func main() {
 	ipPriority := options.ParseIPPriority(*ipPriorityStr)
 	log.Printf("IP priority strategy: %s", ipPriority.String())
 
+	// 配置 Happy Eyeballs：IPv4 优先策略下没有历史记录时先尝试 IPv4
+	if config != nil && config.HappyEyeballsDelay != "" {
+		*happyEyeballsDelay = config.HappyEyeballsDelay
+	}
+	if delay, err := time.ParseDuration(*happyEyeballsDelay); err != nil {
+		log.Printf("解析happy-eyeballs-delay失败，使用默认值: %v", err)
+	} else {
+		options.SetHappyEyeballsDelay(delay)
+	}
+	options.SetHappyEyeballsPreferIPv4(ipPriority == options.IPPv4Priority)
+
+	// 配置全局出站连接：源地址、网卡、防火墙标记和 TCP keepalive
+	outbound := options.OutboundConfig{
+		BindAddress:   *bindAddress,
+		BindInterface: *bindInterface,
+		FwMark:        *fwmark,
+		KeepAlive:     *tcpKeepAlive,
+	}
+	if config != nil {
+		outbound = outbound.Merge(options.OutboundConfig(config.Outbound))
+	}
+	if err := outbound.Validate(); err != nil {
+		log.Fatalf("出站配置无效: %v\n", err)
+	}
+	options.SetDefaultOutbound(outbound)
+
+	// 配置熔断：目标、上游代理或DNS服务器连续失败后直接返回 502/504，断开时间过后放行一个探测连接
+	if config != nil {
+		if config.CircuitBreaker.Failures != 0 {
+			*circuitBreakerFailures = config.CircuitBreaker.Failures
+		}
+		if config.CircuitBreaker.OpenTimeout != "" {
+			*circuitBreakerTimeout = config.CircuitBreaker.OpenTimeout
+		}
+	}
+	breakerTimeout, err := time.ParseDuration(*circuitBreakerTimeout)
+	if err != nil {
+		log.Printf("解析circuit-breaker-timeout失败，使用默认值: %v", err)
+		breakerTimeout = options.DefaultCircuitBreakerOpenTimeout
+	}
+	options.SetCircuitBreaker(*circuitBreakerFailures, breakerTimeout)
+
+	// 配置转发标识头：逐跳头总是删除，Via/X-Forwarded-For 按设置添加，匿名模式删除所有标识头
+	if config != nil {
+		if config.ForwardHeaders.Via != "" {
+			*viaPseudonym = config.ForwardHeaders.Via
+		}
+		if config.ForwardHeaders.XForwardedFor {
+			*xForwardedFor = true
+		}
+		if config.ForwardHeaders.Anonymous {
+			*anonymous = true
+		}
+	}
+	if *viaPseudonym == "off" {
+		*viaPseudonym = ""
+	}
+	options.SetForwardHeaders(options.ForwardHeadersConfig{
+		Via:           *viaPseudonym,
+		XForwardedFor: *xForwardedFor,
+		Anonymous:     *anonymous,
+	})
+
+	// 配置内部 HTTP 代理的共享 Transport：按路由复用到目标和上游的连接
+	transportOptions := http_server.DefaultTransportOptions
+	if config != nil {
+		if config.Transport.MaxIdleConns != 0 {
+			*maxIdleConns = config.Transport.MaxIdleConns
+		}
+		if config.Transport.MaxIdleConnsPerHost != 0 {
+			*maxIdleConnsPerHost = config.Transport.MaxIdleConnsPerHost
+		}
+		if config.Transport.MaxConnsPerHost != 0 {
+			transportOptions.MaxConnsPerHost = config.Transport.MaxConnsPerHost
+		}
+		if config.Transport.IdleConnTimeout != "" {
+			*idleConnTimeout = config.Transport.IdleConnTimeout
+		}
+		if config.Transport.ResponseHeaderTimeout != "" {
+			*responseHeaderTimeout = config.Transport.ResponseHeaderTimeout
+		}
+		if config.Transport.DisableHTTP2 {
+			*disableHTTP2 = true
+		}
+		if config.Transport.HTTP2PingInterval != "" {
+			*http2PingInterval = config.Transport.HTTP2PingInterval
+		}
+	}
+	transportOptions.MaxIdleConns = *maxIdleConns
+	transportOptions.MaxIdleConnsPerHost = *maxIdleConnsPerHost
+	transportOptions.DisableHTTP2 = *disableHTTP2
+	if v, err := time.ParseDuration(*idleConnTimeout); err != nil {
+		log.Printf("解析idle-conn-timeout失败，使用默认值: %v", err)
+	} else {
+		transportOptions.IdleConnTimeout = v
+	}
+	if v, err := time.ParseDuration(*responseHeaderTimeout); err != nil {
+		log.Printf("解析response-header-timeout失败，使用默认值: %v", err)
+	} else {
+		transportOptions.ResponseHeaderTimeout = v
+	}
+	if v, err := time.ParseDuration(*http2PingInterval); err != nil {
+		log.Printf("解析http2-ping-interval失败，使用默认值: %v", err)
+	} else {
+		transportOptions.HTTP2PingInterval = v
+	}
+	http_server.SetTransportOptions(transportOptions)
+	// hosts 重新加载或通过管理接口修改缓存后，丢弃按旧解析结果建立的空闲连接
+	hosts.SetReloadHook(http_server.InvalidateTransports)
+	dnscache.SetAdminChangeHook(http_server.InvalidateTransports)
+
+	if config != nil && config.H2C {
+		*h2c = true
+	}
+	simple.SetH2CPriorKnowledge(*h2c)
+	if (*h2c || len(*server_cert) > 0) && !simple.ExtendedConnectEnabled() {
+		log.Println("HTTP/2 扩展 CONNECT（RFC 8441）未启用，需要以 GODEBUG=http2xconnect=1 启动进程")
+	}
+
+	if config != nil && config.MasquePort > 0 {
+		*masquePort = config.MasquePort
+	}
+	if config != nil && config.UDPIdleTimeout != "" {
+		*udpIdleTimeout = config.UDPIdleTimeout
+	}
+	if v, err := time.ParseDuration(*udpIdleTimeout); err != nil {
+		log.Printf("解析udp-idle-timeout失败，使用默认值: %v", err)
+	} else {
+		simple.SetUDPSessionIdleTimeout(v)
+	}
+
+	if config != nil {
+		if config.MITM.CACert != "" {
+			*mitmCACert = config.MITM.CACert
+		}
+		if config.MITM.CAKey != "" {
+			*mitmCAKey = config.MITM.CAKey
+		}
+		if config.MITM.CertCacheSize > 0 {
+			*mitmCertCacheSize = config.MITM.CertCacheSize
+		}
+	}
+	if len(*mitmCACert) > 0 && len(*mitmCAKey) > 0 {
+		ca, err := mitm.LoadCA(*mitmCACert, *mitmCAKey, *mitmCertCacheSize)
+		if err != nil {
+			log.Fatalf("加载 TLS 拦截 CA 失败: %v\n", err)
+		}
+		mitm.SetCA(ca)
+	}
+	// 路由规则中的 TLS 拦截
+	if config != nil && len(config.Rules) > 0 {
+		for _, rule := range config.Rules {
+			if rule.Intercept && (len(*mitmCACert) == 0 || len(*mitmCAKey) == 0) {
+				log.Printf("规则 %s 设置了 intercept，但没有配置 mitm-ca-cert 和 mitm-ca-key，不会拦截", rule.Filter)
+			}
+		}
+		mitm.SetInterceptSelector(func(host string) bool {
+			return SelectInterceptWithCIDR(config.Rules, config.Filters, host)
+		})
+	}
+
 	// 解析DNS缓存配置并初始化
 	if *cacheEnabled {
 		// 解析TTL
@@ -707,6 +1166,15 @@ func main() {
 			SaveInterval: cacheSaveIntervalDuration,
 			AOFInterval:  cacheAOFIntervalDuration,
 			AOFEnabled:   *cacheAOFEnabled,
+			MaxEntries:   *cacheMaxEntries,
+			MaxBytes:     *cacheMaxBytes,
+			AppendFsync:  *cacheAppendFsync,
+			Backend:      *cacheBackend,
+			DBPath:       *cacheDBFile,
+			RedisURL:     *cacheRedisURL,
+			RedisPrefix:  *cacheRedisPrefix,
+			PinsPath:     *cachePinsFile,
+			HitsPath:     *cacheHitsFile,
 		}
 
 		// 初始化DNS缓存
@@ -715,6 +1183,19 @@ func main() {
 			log.Printf("初始化DNS缓存失败，将禁用缓存: %v", err)
 		} else {
 			log.Printf("DNS缓存已启用，文件: %s, AOF: %v, TTL: %v", *cacheFile, *cacheAOFEnabled, cacheTTLDuration)
+
+			// 启动DNS缓存管理接口（如果启用），与 pprof 一样只应监听在回环地址上
+			if *cacheAdminAddr != "" {
+				go func() {
+					log.Printf("启动DNS缓存管理接口，监听地址: http://%s/cache/entries", *cacheAdminAddr)
+					mux := http.NewServeMux()
+					mux.Handle("/cache/", GetDNSCache().AdminHandler())
+					mux.Handle("/breakers", options.CircuitBreakerHandler())
+					if err := http.ListenAndServe(*cacheAdminAddr, mux); err != nil {
+						log.Printf("DNS缓存管理接口启动失败: %v", err)
+					}
+				}()
+			}
 		}
 
 		// 启动 H3 客户端缓存清理器，防止 goroutine 泄漏
@@ -729,6 +1210,72 @@ func main() {
 		log.Println("DNS缓存已禁用")
 	}
 
+	// 加载 hosts 表：命令行条目与配置文件条目合并，同名时命令行优先
+	hostsInline := make(map[string][]string)
+	if config != nil {
+		hostsFiles = append(hostsFiles, config.HostsFiles...)
+		for name, ips := range config.Hosts {
+			hostsInline[name] = ips
+		}
+	}
+	for _, entry := range hostEntries {
+		name, ips, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Printf("无效的hosts条目 %q，格式应为 域名=IP[,IP]\n", entry)
+			os.Exit(1)
+		}
+		hostsInline[strings.TrimSpace(name)] = []string{ips}
+	}
+	if len(hostsFiles) > 0 || len(hostsInline) > 0 {
+		if err := hosts.Configure(hostsFiles, hostsInline); err != nil {
+			log.Printf("加载hosts配置失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 启用 DNSSEC 验证
+	if *dnssecEnabled {
+		if err := doh.EnableDNSSEC(dnssecTrustAnchors); err != nil {
+			log.Printf("启用DNSSEC验证失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 设置 EDNS Client Subnet 策略
+	if *ecsMode != "" {
+		ecsPolicy, err := doh.ParseECSPolicy(*ecsMode, *ecsPrefixV4, *ecsPrefixV6)
+		if err != nil {
+			log.Printf("解析ECS配置失败: %v\n", err)
+			os.Exit(1)
+		}
+		doh.SetECSPolicy(ecsPolicy)
+		log.Printf("EDNS Client Subnet 策略: %s", *ecsMode)
+	}
+
+	// 启用 HTTPS/SVCB 记录
+	if *httpsRecords {
+		doh.EnableHTTPSRecords(true)
+		log.Println("已启用 HTTPS/SVCB 记录")
+	}
+
+	// 启用 fake-IP 模式并启动本地 fake-IP DNS 服务
+	if *fakeIPEnabled {
+		fakeIPTTLDuration, err := time.ParseDuration(*fakeIPTTL)
+		if err != nil {
+			log.Printf("解析fake-ip-ttl失败，使用默认值: %v", err)
+			fakeIPTTLDuration = dnscache.DefaultFakeIPTTL
+		}
+		if err := GetDNSCache().EnableFakeIP(*fakeIPRange, fakeIPTTLDuration); err != nil {
+			log.Printf("启用fake-ip模式失败: %v\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := GetDNSCache().ServeFakeIPDNS(*fakeIPDNSListen); err != nil {
+				log.Printf("fake-ip DNS 服务启动失败: %v", err)
+			}
+		}()
+	}
+
 	// 添加信号处理
 	go func() {
 		c := make(chan os.Signal, 1)
@@ -741,6 +1288,9 @@ func main() {
 		// 关闭 H3 客户端缓存，防止 goroutine 泄漏
 		doh.CloseH3ClientCache()
 		log.Println("H3客户端缓存已关闭")
+		// 关闭 DoT/DoQ 长连接
+		doh.CloseDoTClientCache()
+		doh.CloseDoQClientCache()
 		os.Exit(0)
 	}()
 
@@ -907,6 +1457,11 @@ func main() {
 	}
 
 	for i, dohurl := range dohurls {
+		// sdns:// 格式的 DNS stamp 按其协议类型转换为 DNSCrypt/DoH/DoT/DoQ 配置
+		if options.IsDNSStamp(dohurl) {
+			appendDNSStampOption(&proxyoptions, dohurl)
+			continue
+		}
 
 		var dohip string
 		if len(dohips) > i {
@@ -935,6 +1490,10 @@ func main() {
 
 	// 添加 DoT 配置
 	for i, doturl := range doturls {
+		if options.IsDNSStamp(doturl) {
+			appendDNSStampOption(&proxyoptions, doturl)
+			continue
+		}
 		var dotip string
 		if len(dotips) > i {
 			dotip = dotips[i]
@@ -951,6 +1510,10 @@ func main() {
 
 	// 添加 DoQ 配置
 	for i, doqurl := range doqurls {
+		if options.IsDNSStamp(doqurl) {
+			appendDNSStampOption(&proxyoptions, doqurl)
+			continue
+		}
 		var doqip string
 		if len(doqips) > i {
 			doqip = doqips[i]
@@ -964,6 +1527,34 @@ func main() {
 			Protocol: "doq",
 		})
 	}
+
+	// 添加分流DNS规则
+	var dnsRuleConfigs []DNSRule
+	if config != nil {
+		dnsRuleConfigs = append(dnsRuleConfigs, config.DNSRules...)
+	}
+	for _, rule := range dnsrules {
+		domains, servers, ok := strings.Cut(rule, "=")
+		if !ok {
+			log.Printf("分流DNS规则格式错误: %s\n", rule)
+			os.Exit(1)
+		}
+		dnsRuleConfigs = append(dnsRuleConfigs, DNSRule{
+			Domains: strings.Split(domains, ","),
+			Servers: strings.Split(servers, ","),
+		})
+	}
+	for _, rule := range dnsRuleConfigs {
+		for _, server := range rule.Servers {
+			opt, err := options.ParseDNSServer(strings.TrimSpace(server), rule.Domains)
+			if err != nil {
+				log.Printf("分流DNS规则解析失败: %v\n", err)
+				os.Exit(1)
+			}
+			log.Println("分流DNS规则:", rule.Domains, "->", opt.ServerURL())
+			proxyoptions = append(proxyoptions, opt)
+		}
+	}
 	var Proxy = func(r *http.Request) (*url.URL, error) {
 
 		log.Println("ProxySelector", r.URL.Host)
@@ -1038,9 +1629,27 @@ func main() {
 					HTTPS_PROXY:  modifedUpstreamurl,
 					SOCKS5_PROXY: modifedUpstreamurl,
 					WS_PROXY:     modifedUpstreamurl,
+					Outbound:     upstream.Outbound,
 				}
 				config.UpStreams[name] = modifedUpstream
+				// 上游的熔断器按名称区分
+				options.RegisterUpstreamName(name, modifedUpstreamurl)
+			}
+
+			// 上游代理和路由规则中的出站配置
+			for name, upstream := range config.UpStreams {
+				if err := options.OutboundConfig(upstream.Outbound).Validate(); err != nil {
+					log.Fatalf("上游 %s 的出站配置无效: %v\n", name, err)
+				}
+			}
+			for _, rule := range config.Rules {
+				if err := options.OutboundConfig(rule.Outbound).Validate(); err != nil {
+					log.Fatalf("规则 %s 的出站配置无效: %v\n", rule.Filter, err)
+				}
 			}
+			options.SetOutboundSelector(func(host string) (options.OutboundConfig, bool) {
+				return SelectOutboundWithCIDR(config.UpStreams, config.Rules, config.Filters, host)
+			})
 
 			tranportConfigurations = append(tranportConfigurations, func(t *http.Transport) *http.Transport {
 				// t.Proxy = func(r *http.Request) (*url.URL, error) {
@@ -1081,7 +1690,7 @@ func main() {
 						return nil, err
 					}
 					if utils.IsLoopbackIP(host) {
-						var dialer = &net.Dialer{}
+						dialer := options.NewDialer(network, addr)
 						return dialer.DialContext(ctx, network, addr)
 					}
 
@@ -1111,12 +1720,12 @@ func main() {
 							return socks5DialContext(ctx, network, addr, modifiedUpstream, Proxy, proxyoptions, GetDNSCache(), *upstreamResolveIPs, ipPriority)
 						} else {
 							log.Println("未选择代理")
-							var dialer = &net.Dialer{}
+							dialer := options.NewDialer(network, addr)
 							return dialer.DialContext(ctx, network, addr)
 
 						}
 					}
-					var dialer = &net.Dialer{}
+					dialer := options.NewDialer(network, addr)
 					return dialer.DialContext(ctx, network, addr)
 				}
 
@@ -1125,12 +1734,44 @@ func main() {
 			})
 		}
 	}
+	// 启动后在后台预热DNS缓存，使重启后的首批请求不必等待 DoH 查询
+	if *cacheEnabled && (*cacheWarmupFile != "" || *cacheWarmupTop > 0) {
+		if GetDNSCache() == nil {
+			log.Println("DNS缓存初始化失败，跳过DNS缓存预热")
+		} else if len(proxyoptions) == 0 {
+			log.Println("没有配置 DoH/DoT/DoQ 服务器，跳过DNS缓存预热")
+		} else {
+			var warmupDomains []string
+			if *cacheWarmupFile != "" {
+				domains, err := dnscache.ReadWarmupFile(*cacheWarmupFile)
+				if err != nil {
+					log.Printf("读取DNS缓存预热文件失败: %v", err)
+				}
+				warmupDomains = append(warmupDomains, domains...)
+			}
+			warmupDomains = append(warmupDomains, GetDNSCache().TopDomains(*cacheWarmupTop)...)
+			resolver := dnscache.CreateHostsAndDohResolverCached(proxyoptions, GetDNSCache(), Proxy, tranportConfigurations...)
+			go func() {
+				start := time.Now()
+				resolved, failed := dnscache.Warmup(context.Background(), resolver, warmupDomains, *cacheWarmupConcurrency)
+				log.Printf("DNS缓存预热完成，成功 %d 个，失败 %d 个，耗时 %v", resolved, failed, time.Since(start))
+			}()
+		}
+	}
 	by, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	log.Println(string(by))
+	// HTTP/3 监听端口与 TCP 监听端口同时运行，共用身份验证、路由规则和上游代理
+	if *masquePort > 0 {
+		if len(*server_cert) > 0 && len(*server_key) > 0 {
+			go masque.Masque(*server_cert, *server_key, *hostname, *masquePort, *username, *password, Proxy, proxyoptions, GetDNSCache(), *upstreamResolveIPs, ipPriority, tranportConfigurations...)
+		} else {
+			log.Println("masque-port 需要 server_cert 和 server_key，HTTP/3 监听端口未启动")
+		}
+	}
 	if len(*username) > 0 && len(*password) > 0 && len(*server_cert) > 0 && len(*server_key) > 0 {
 		tls_auth.Tls_auth(*server_cert, *server_key, *hostname, *port, *username, *password, Proxy, proxyoptions, GetDNSCache(), *upstreamResolveIPs, ipPriority, tranportConfigurations...)
 		return
@@ -338,3 +338,31 @@ func TestSelectProxyURLWithCIDR_WebSocketProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectInterceptWithCIDR(t *testing.T) {
+	rules := []config.RoutingRule{
+		{Filter: "pinned", Upstream: "proxy1"}, // 先匹配的规则没有 intercept，后面的规则不再拦截
+		{Filter: "api", Intercept: true},
+		{Filter: "lan", Intercept: true},
+		{Filter: "any", Upstream: "proxy1"}, // 没有 intercept 的规则不拦截
+	}
+	filters := map[string]config.Filter{
+		"pinned": {Patterns: []string{"pinned.api.example.com", "10.9.0.0/16"}},
+		"api":    {Patterns: []string{"*.api.example.com"}},
+		"lan":    {Patterns: []string{"10.0.0.0/8"}},
+		"any":    {Patterns: []string{"*"}},
+	}
+	tests := map[string]bool{
+		"v1.api.example.com":     true,
+		"pinned.api.example.com": false,
+		"10.1.2.3":               true,
+		"10.9.1.1":               false,
+		"www.example.com":        false,
+		"192.168.1.1":            false,
+	}
+	for host, expected := range tests {
+		if got := SelectInterceptWithCIDR(rules, filters, host); got != expected {
+			t.Errorf("%s: 期望 %v, 实际得到 %v", host, expected, got)
+		}
+	}
+}
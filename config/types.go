@@ -1,6 +1,9 @@
 package config
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // DohConfig DOH配置结构体
 type DohConfig struct {
@@ -22,17 +25,75 @@ type DoqConfig struct {
 	URL string `json:"url"`
 }
 
+// DNSRule 分流DNS规则，匹配 Domains 的域名使用 Servers 中的DNS服务器解析
+type DNSRule struct {
+	Domains []string `json:"domains"`
+	Servers []string `json:"servers"`
+}
+
+// FakeIPConfig fake-IP 模式配置
+type FakeIPConfig struct {
+	Enabled bool   `json:"enabled"`
+	Range   string `json:"range"`  // fake-IP 地址段，默认 198.18.0.0/15
+	Listen  string `json:"listen"` // 本地 fake-IP DNS 服务监听地址
+	TTL     string `json:"ttl"`    // 域名与 fake-IP 映射的保留时间
+}
+
+// DNSSECConfig DNSSEC 验证配置
+type DNSSECConfig struct {
+	Enabled      bool     `json:"enabled"`
+	TrustAnchors []string `json:"trust_anchors"` // 根区信任锚（DS 记录），为空时使用内置的根区 KSK
+}
+
+// ECSConfig EDNS Client Subnet 配置
+type ECSConfig struct {
+	Mode     string `json:"mode"`      // strip、client 或固定的 IP/CIDR，为空表示不修改
+	PrefixV4 int    `json:"prefix_v4"` // client 模式下 IPv4 子网前缀长度，默认 24
+	PrefixV6 int    `json:"prefix_v6"` // client 模式下 IPv6 子网前缀长度，默认 56
+}
+
+// HostsEntry 内联 hosts 条目的 IP 列表，JSON 中可以写成单个字符串或字符串数组
+type HostsEntry []string
+
+// UnmarshalJSON 同时接受 "1.2.3.4" 和 ["1.2.3.4", "::1"]
+func (h *HostsEntry) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*h = HostsEntry{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*h = list
+	return nil
+}
+
 // DNSCacheConfig DNS缓存配置
 type DNSCacheConfig struct {
-	Enabled       bool   `json:"enabled"`
-	EnabledSet    bool   `json:"-"` // Internal flag to track if value was explicitly set
-	File          string `json:"file"`
-	TTL           string `json:"ttl"`
-	SaveInterval  string `json:"save_interval"`
-	AOFEnabled    bool   `json:"aof_enabled"`
-	AOFEnabledSet bool   `json:"-"` // Internal flag to track if value was explicitly set
-	AOFFile       string `json:"aof_file"`
-	AOFInterval   string `json:"aof_interval"`
+	Enabled           bool   `json:"enabled"`
+	EnabledSet        bool   `json:"-"` // Internal flag to track if value was explicitly set
+	File              string `json:"file"`
+	TTL               string `json:"ttl"`
+	SaveInterval      string `json:"save_interval"`
+	AOFEnabled        bool   `json:"aof_enabled"`
+	AOFEnabledSet     bool   `json:"-"` // Internal flag to track if value was explicitly set
+	AOFFile           string `json:"aof_file"`
+	AOFInterval       string `json:"aof_interval"`
+	MaxEntries        int    `json:"max_entries"`        // 最大条目数，0 表示不限制
+	MaxBytes          int64  `json:"max_bytes"`          // 近似最大内存占用（字节），0 表示不限制
+	AppendFsync       string `json:"appendfsync"`        // AOF 刷盘策略：always、everysec 或 no
+	Backend           string `json:"backend"`            // 持久化后端：file（默认）、bbolt 或 redis
+	DBFile            string `json:"db_file"`            // bbolt 数据库文件路径
+	RedisURL          string `json:"redis_url"`          // redis 后端地址
+	RedisPrefix       string `json:"redis_prefix"`       // redis 键前缀
+	PinsFile          string `json:"pins_file"`          // 固定解析保存文件
+	AdminAddr         string `json:"admin_addr"`         // 缓存管理接口监听地址，为空不启用
+	HitsFile          string `json:"hits_file"`          // 访问统计保存文件
+	WarmupFile        string `json:"warmup_file"`        // 启动时预热的域名列表文件，每行一个域名
+	WarmupTop         int    `json:"warmup_top"`         // 启动时预热访问最多的前 N 个域名
+	WarmupConcurrency int    `json:"warmup_concurrency"` // 预热的并发解析数
 }
 
 // UpStream 上游代理配置
@@ -52,12 +113,58 @@ type UpStream struct {
 	SOCKS5_PROXY    string `json:"socks5_proxy"`    // SOCKS5代理地址
 	SOCKS5_USERNAME string `json:"socks5_username"` // SOCKS5代理用户名
 	SOCKS5_PASSWORD string `json:"socks5_password"` // SOCKS5代理密码
+
+	// 连接该上游代理时使用的出站配置，覆盖全局配置
+	Outbound OutboundConfig `json:"outbound"`
 }
 
 // RoutingRule 路由规则
 type RoutingRule struct {
 	Filter   string `json:"filter"`
 	Upstream string `json:"upstream"`
+	// 匹配该规则的目标使用的出站配置，覆盖全局配置
+	Outbound OutboundConfig `json:"outbound"`
+	// 拦截匹配该规则的目标的 CONNECT 隧道（TLS 拦截），需要配置 mitm 中的 CA
+	Intercept bool `json:"intercept"`
+}
+
+// CircuitBreakerConfig 熔断配置
+type CircuitBreakerConfig struct {
+	Failures    int    `json:"failures"`     // 连续失败多少次后熔断，-1 关闭，0 使用命令行参数
+	OpenTimeout string `json:"open_timeout"` // 熔断后多久允许一次探测，如 "30s"
+}
+
+// ForwardHeadersConfig 转发请求时附加的标识头配置
+type ForwardHeadersConfig struct {
+	Via           string `json:"via"`             // Via 头中代理的名称，"off" 不添加 Via，为空使用命令行参数
+	XForwardedFor bool   `json:"x_forwarded_for"` // 附加 X-Forwarded-For 和 X-Real-IP
+	Anonymous     bool   `json:"anonymous"`       // 删除所有标识头（Forwarded、Via、X-Forwarded-For 等）
+}
+
+// MITMConfig TLS 拦截使用的本地 CA，为空的字段使用命令行参数
+type MITMConfig struct {
+	CACert        string `json:"ca_cert"`         // CA 证书文件（PEM）
+	CAKey         string `json:"ca_key"`          // CA 私钥文件（PEM）
+	CertCacheSize int    `json:"cert_cache_size"` // 缓存的叶子证书数
+}
+
+// TransportConfig 内部 HTTP 代理共享 Transport 的连接池和超时配置，为零值的字段使用命令行参数
+type TransportConfig struct {
+	MaxIdleConns          int    `json:"max_idle_conns"`          // 所有目标的空闲连接总数上限
+	MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host"` // 每个目标保留的空闲连接数
+	MaxConnsPerHost       int    `json:"max_conns_per_host"`      // 每个目标的连接数上限
+	IdleConnTimeout       string `json:"idle_conn_timeout"`       // 空闲连接保留时间，如 "90s"
+	ResponseHeaderTimeout string `json:"response_header_timeout"` // 等待响应头的超时，如 "30s"
+	DisableHTTP2          bool   `json:"disable_http2"`           // 不与 HTTPS 目标协商 HTTP/2
+	HTTP2PingInterval     string `json:"http2_ping_interval"`     // HTTP/2 连接空闲多久后发送 PING
+}
+
+// OutboundConfig 出站连接配置（源地址、网卡、防火墙标记、TCP keepalive）
+type OutboundConfig struct {
+	BindAddress   string `json:"bind_address"`
+	BindInterface string `json:"bind_interface"`
+	FwMark        int    `json:"fwmark"`
+	KeepAlive     string `json:"keepalive"`
 }
 
 // Filter 过滤器配置
@@ -77,12 +184,59 @@ type Config struct {
 	Dot        []DotConfig `json:"dot"`
 	Doq        []DoqConfig `json:"doq"`
 
+	// 分流DNS规则
+	DNSRules []DNSRule `json:"dns_rules"`
+
 	// DNS缓存配置
 	DNSCache DNSCacheConfig `json:"dns_cache"`
 
+	// fake-IP 模式配置
+	FakeIP FakeIPConfig `json:"fake_ip"`
+
+	// DNSSEC 验证配置
+	DNSSEC DNSSECConfig `json:"dnssec"`
+
+	// EDNS Client Subnet 配置
+	ECS ECSConfig `json:"ecs"`
+
+	// 是否使用 HTTPS/SVCB 记录（地址提示、替代端口、h3）
+	HTTPSRecords bool `json:"https_records"`
+
+	// hosts 文件列表，为空时使用系统 hosts 文件
+	HostsFiles []string `json:"hosts_files"`
+	// 内联 hosts 条目，域名（支持 *.example.com 通配符）-> IP 或 IP 列表
+	Hosts map[string]HostsEntry `json:"hosts"`
+
 	// 上游代理IP解析配置
 	UpstreamResolveIPs bool `json:"upstream_resolve_ips"`
 
+	// Happy Eyeballs 连接尝试间隔（如 "250ms"），"0" 表示逐个顺序尝试
+	HappyEyeballsDelay string `json:"happy_eyeballs_delay"`
+
+	// 全局出站配置
+	Outbound OutboundConfig `json:"outbound"`
+
+	// 熔断配置
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+
+	// 转发请求时附加的标识头配置
+	ForwardHeaders ForwardHeadersConfig `json:"forward_headers"`
+
+	// 内部 HTTP 代理共享 Transport 配置
+	Transport TransportConfig `json:"transport"`
+
+	// 明文端口是否接受 HTTP/2 prior knowledge（h2c）连接
+	H2C bool `json:"h2c"`
+
+	// HTTP/3（MASQUE）代理监听的 UDP 端口，0 表示不启用
+	MasquePort int `json:"masque_port"`
+
+	// connect-udp 会话的空闲超时，例如 "2m"
+	UDPIdleTimeout string `json:"udp_idle_timeout"`
+
+	// TLS 拦截配置，拦截哪些目标由路由规则的 intercept 决定
+	MITM MITMConfig `json:"mitm"`
+
 	UpStreams map[string]UpStream `json:"upstreams"`
 	Rules     []RoutingRule       `json:"rules"`
 	Filters   map[string]Filter   `json:"filters"`
@@ -97,6 +251,15 @@ type CacheConfig struct {
 	SaveInterval time.Duration `json:"save_interval"`
 	AOFInterval  time.Duration `json:"aof_interval"`
 	AOFEnabled   bool          `json:"aof_enabled"`
+	MaxEntries   int           `json:"max_entries"`
+	MaxBytes     int64         `json:"max_bytes"`
+	AppendFsync  string        `json:"appendfsync"`
+	Backend      string        `json:"backend"`
+	DBPath       string        `json:"db_path"`
+	RedisURL     string        `json:"redis_url"`
+	RedisPrefix  string        `json:"redis_prefix"`
+	PinsPath     string        `json:"pins_path"`
+	HitsPath     string        `json:"hits_path"`
 }
 
 // DefaultCacheConfig 返回默认缓存配置 (兼容现有代码)
@@ -109,16 +272,32 @@ func DefaultCacheConfig() *CacheConfig {
 		SaveInterval: 30 * time.Second,
 		AOFInterval:  1 * time.Second,
 		AOFEnabled:   true,
+		AppendFsync:  "everysec",
+		Backend:      "file",
+		DBPath:       "./dns_cache.db",
+		RedisURL:     "redis://127.0.0.1:6379/0",
+		RedisPrefix:  "dnscache:",
+		PinsPath:     "./dns_cache_pins.json",
+		HitsPath:     "./dns_cache_hits.json",
 	}
 }
 
 // ToCacheConfig 转换为兼容的CacheConfig结构
 func (c *DNSCacheConfig) ToCacheConfig() (*CacheConfig, error) {
 	config := &CacheConfig{
-		Enabled:    c.Enabled,
-		FilePath:   c.File,
-		AOFPath:    c.AOFFile,
-		AOFEnabled: c.AOFEnabled,
+		Enabled:     c.Enabled,
+		FilePath:    c.File,
+		AOFPath:     c.AOFFile,
+		AOFEnabled:  c.AOFEnabled,
+		MaxEntries:  c.MaxEntries,
+		MaxBytes:    c.MaxBytes,
+		AppendFsync: c.AppendFsync,
+		Backend:     c.Backend,
+		DBPath:      c.DBFile,
+		RedisURL:    c.RedisURL,
+		RedisPrefix: c.RedisPrefix,
+		PinsPath:    c.PinsFile,
+		HitsPath:    c.HitsFile,
 	}
 
 	// Parse durations
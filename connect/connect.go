@@ -2,6 +2,7 @@ package connect
 
 import (
 	"bufio"
+	"context"
 
 	"crypto/tls"
 	"encoding/base64"
@@ -45,15 +46,15 @@ func ConnectViaHttpProxy(proxyURL *url.URL, targetAddr string, Proxy func(*http.
 	var err error
 	if scheme == "https" {
 		// 使用TLS连接
-		conn, err = tls.Dial("tcp", proxyAddr, &tls.Config{
+		conn, err = tls.DialWithDialer(options.NewDialer("tcp", proxyAddr), "tcp", proxyAddr, &tls.Config{
 			ServerName: proxyURL.Hostname(),
 		})
 	} else {
 		// 使用普通TCP连接
-		conn, err = net.Dial("tcp", proxyAddr)
+		conn, err = options.DialContext(context.Background(), "tcp", proxyAddr)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to proxy: %v", err)
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
 	}
 
 	// 从proxyURL中获取目标地址（这里假设proxyURL的Path或Opaque包含目标地址）
@@ -112,7 +113,7 @@ func ConnectViaHttpProxy(proxyURL *url.URL, targetAddr string, Proxy func(*http.
 	_, err = conn.Write([]byte(connectReq))
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to send CONNECT request: %v", err)
+		return nil, fmt.Errorf("failed to send CONNECT request: %w", err)
 	}
 
 	// 读取代理服务器的响应
@@ -120,7 +121,7 @@ func ConnectViaHttpProxy(proxyURL *url.URL, targetAddr string, Proxy func(*http.
 	respLine, err := reader.ReadString('\n')
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to read proxy response: %v", err)
+		return nil, fmt.Errorf("failed to read proxy response: %w", err)
 	}
 
 	// 解析状态行
@@ -148,7 +148,7 @@ func (c *HttpProxyClient) DialContext(ctx context.Context, network, addr string)
 		return nil, err
 	}
 	if utils.IsLoopbackIP(host) {
-		var dialer = &net.Dialer{}
+		dialer := options.NewDialer(network, addr)
 		return dialer.DialContext(ctx, network, addr)
 	}
 	if c.conn == nil || c.closed {
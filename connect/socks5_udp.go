@@ -0,0 +1,299 @@
+package connect
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+// SOCKS5 协议常量（RFC 1928、RFC 1929）
+const (
+	socks5Version          = 0x05
+	socks5MethodNoAuth     = 0x00
+	socks5MethodUserPass   = 0x02
+	socks5MethodNoAccept   = 0xff
+	socks5CmdUDPAssociate  = 0x03
+	socks5AddrIPv4         = 0x01
+	socks5AddrDomain       = 0x03
+	socks5AddrIPv6         = 0x04
+	socks5UserPassVersion  = 0x01
+	socks5HandshakeTimeout = 30 * time.Second
+	// socks5MaxUDPHeader UDP 请求头的最大长度：RSV FRAG ATYP、255 字节的域名和端口
+	socks5MaxUDPHeader = 3 + 1 + 1 + 255 + 2
+)
+
+// SOCKS5UDPConn 通过上游 SOCKS5 代理的 UDP ASSOCIATE（RFC 1928 第 7 节）与一个目标收发数据报。
+// Write 把数据报加上 SOCKS5 UDP 请求头发给代理的 UDP 中继，Read 去掉中继返回的头；
+// 控制用的 TCP 连接断开时关联失效，连接随之关闭
+type SOCKS5UDPConn struct {
+	udp     *net.UDPConn
+	control net.Conn
+	relay   *net.UDPAddr
+	// header 发往目标的 UDP 请求头：RSV(2) FRAG(1) ATYP DST.ADDR DST.PORT
+	header    []byte
+	readBuf   []byte
+	closeOnce sync.Once
+}
+
+// DialUDPViaSOCKS5 通过 proxyURL 指定的 SOCKS5 代理建立到 target（host:port）的 UDP 关联，
+// proxyURL 中的用户名和密码用于用户名/密码认证（RFC 1929）
+func DialUDPViaSOCKS5(ctx context.Context, proxyURL *url.URL, target string) (*SOCKS5UDPConn, error) {
+	header, err := socks5UDPHeader(target)
+	if err != nil {
+		return nil, err
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080") // SOCKS5默认端口
+	}
+	control, err := options.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS5 proxy %s: %w", proxyAddr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		control.SetDeadline(deadline)
+	} else {
+		control.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	}
+	if err := socks5Authenticate(control, proxyURL.User); err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	// 本地 UDP 套接字与控制连接一样按出站配置（源地址、网卡、防火墙标记）创建
+	pconn, err := options.ListenPacket(ctx, "udp", control.RemoteAddr().String())
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	udp := pconn.(*net.UDPConn)
+	// 关联请求中的 DST.ADDR 使用控制连接的本地地址，代理可以按它限制关联的来源
+	source := &net.UDPAddr{IP: control.LocalAddr().(*net.TCPAddr).IP, Port: udp.LocalAddr().(*net.UDPAddr).Port}
+	relay, err := socks5UDPAssociate(control, source)
+	if err != nil {
+		udp.Close()
+		control.Close()
+		return nil, err
+	}
+	// 代理返回未指定地址时，中继与控制连接在同一个主机上
+	if relay.IP.IsUnspecified() {
+		relay.IP = control.RemoteAddr().(*net.TCPAddr).IP
+	}
+	control.SetDeadline(time.Time{})
+	log.Printf("SOCKS5 UDP ASSOCIATE established via %s, relay %s, target %s", proxyAddr, relay, target)
+
+	c := &SOCKS5UDPConn{udp: udp, control: control, relay: relay, header: header}
+	// 控制连接上不再有数据，读到 EOF 或出错表示代理结束了关联
+	go func() {
+		io.Copy(io.Discard, control)
+		c.Close()
+	}()
+	return c, nil
+}
+
+// socks5Authenticate 协商认证方法，代理选择用户名/密码认证时发送 user 中的凭据
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	methods := []byte{socks5MethodNoAuth}
+	if user != nil {
+		methods = append(methods, socks5MethodUserPass)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 method selection: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5MethodNoAuth:
+		return nil
+	case socks5MethodUserPass:
+		if user == nil {
+			return fmt.Errorf("SOCKS5 proxy requires username/password authentication")
+		}
+		username := user.Username()
+		password, _ := user.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("SOCKS5 username or password too long")
+		}
+		req := []byte{socks5UserPassVersion, byte(len(username))}
+		req = append(req, username...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("failed to read SOCKS5 authentication reply: %w", err)
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("SOCKS5 authentication failed")
+		}
+		return nil
+	case socks5MethodNoAccept:
+		return fmt.Errorf("SOCKS5 proxy accepted none of the offered authentication methods")
+	default:
+		return fmt.Errorf("unsupported SOCKS5 authentication method %d", reply[1])
+	}
+}
+
+// socks5UDPAssociate 发送 UDP ASSOCIATE 请求，返回代理的 UDP 中继地址
+func socks5UDPAssociate(conn net.Conn, local *net.UDPAddr) (*net.UDPAddr, error) {
+	addr, err := socks5Addr(local.IP.String(), local.Port)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, socks5CmdUDPAssociate, 0x00}, addr...)); err != nil {
+		return nil, err
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, fmt.Errorf("failed to read SOCKS5 UDP ASSOCIATE reply: %w", err)
+	}
+	if head[1] != 0x00 {
+		return nil, fmt.Errorf("SOCKS5 UDP ASSOCIATE rejected with reply code %d", head[1])
+	}
+	var ip net.IP
+	switch head[3] {
+	case socks5AddrIPv4:
+		ip = make(net.IP, net.IPv4len)
+	case socks5AddrIPv6:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, fmt.Errorf("unsupported SOCKS5 relay address type %d", head[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, ip); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(port))}, nil
+}
+
+// socks5Addr 编码 ATYP、地址和端口，IP 以外的主机按域名编码交给代理解析
+func socks5Addr(host string, port int) ([]byte, error) {
+	var b []byte
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("invalid SOCKS5 domain name %q", host)
+		}
+		b = append([]byte{socks5AddrDomain, byte(len(host))}, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{socks5AddrIPv4}, ip4...)
+	} else {
+		b = append([]byte{socks5AddrIPv6}, ip...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// socks5UDPHeader 返回发往 target 的 UDP 请求头
+func socks5UDPHeader(target string) ([]byte, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil || portNum <= 0 || portNum > 65535 {
+		return nil, fmt.Errorf("invalid port in UDP target %s", target)
+	}
+	addr, err := socks5Addr(host, portNum)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{0x00, 0x00, 0x00}, addr...), nil
+}
+
+// parseSOCKS5UDPHeader 返回中继发来的数据报中 UDP 请求头的长度，分片的数据报（FRAG 不为 0）不支持
+func parseSOCKS5UDPHeader(b []byte) (int, error) {
+	if len(b) < 4 {
+		return 0, fmt.Errorf("short SOCKS5 UDP datagram")
+	}
+	if b[2] != 0x00 {
+		return 0, fmt.Errorf("fragmented SOCKS5 UDP datagram")
+	}
+	n := 4
+	switch b[3] {
+	case socks5AddrIPv4:
+		n += net.IPv4len
+	case socks5AddrIPv6:
+		n += net.IPv6len
+	case socks5AddrDomain:
+		if len(b) < 5 {
+			return 0, fmt.Errorf("short SOCKS5 UDP datagram")
+		}
+		n += 1 + int(b[4])
+	default:
+		return 0, fmt.Errorf("unsupported SOCKS5 address type %d", b[3])
+	}
+	n += 2
+	if len(b) < n {
+		return 0, fmt.Errorf("short SOCKS5 UDP datagram")
+	}
+	return n, nil
+}
+
+// Read 读取下一个来自中继的数据报载荷，丢弃来源不是中继、请求头无效或分片的数据报
+func (c *SOCKS5UDPConn) Read(b []byte) (int, error) {
+	if cap(c.readBuf) < len(b)+socks5MaxUDPHeader {
+		c.readBuf = make([]byte, len(b)+socks5MaxUDPHeader)
+	}
+	buf := c.readBuf[:len(b)+socks5MaxUDPHeader]
+	for {
+		n, from, err := c.udp.ReadFromUDP(buf)
+		if err != nil {
+			return 0, err
+		}
+		if !from.IP.Equal(c.relay.IP) || from.Port != c.relay.Port {
+			continue
+		}
+		headerLen, err := parseSOCKS5UDPHeader(buf[:n])
+		if err != nil {
+			log.Println("SOCKS5 UDP: dropping datagram:", err)
+			continue
+		}
+		return copy(b, buf[headerLen:n]), nil
+	}
+}
+
+// Write 把 b 作为一个数据报经中继发给目标
+func (c *SOCKS5UDPConn) Write(b []byte) (int, error) {
+	packet := make([]byte, 0, len(c.header)+len(b))
+	packet = append(append(packet, c.header...), b...)
+	if _, err := c.udp.WriteToUDP(packet, c.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 关闭 UDP 套接字和控制连接，代理随之释放关联
+func (c *SOCKS5UDPConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.udp.Close()
+		c.control.Close()
+	})
+	return err
+}
+
+func (c *SOCKS5UDPConn) LocalAddr() net.Addr { return c.udp.LocalAddr() }
+
+// RemoteAddr 返回代理的 UDP 中继地址
+func (c *SOCKS5UDPConn) RemoteAddr() net.Addr { return c.relay }
+
+func (c *SOCKS5UDPConn) SetDeadline(t time.Time) error      { return c.udp.SetDeadline(t) }
+func (c *SOCKS5UDPConn) SetReadDeadline(t time.Time) error  { return c.udp.SetReadDeadline(t) }
+func (c *SOCKS5UDPConn) SetWriteDeadline(t time.Time) error { return c.udp.SetWriteDeadline(t) }
@@ -0,0 +1,115 @@
+package connect
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// serveTestSOCKS5UDP 运行一个只支持用户名/密码认证和 UDP ASSOCIATE 的 SOCKS5 服务器，
+// 中继把收到的数据报按原目标地址原样返回
+func serveTestSOCKS5UDP(t *testing.T, l net.Listener) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, 512)
+	// 方法协商
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		t.Error(err)
+		return
+	}
+	io.ReadFull(conn, buf[:buf[1]])
+	conn.Write([]byte{socks5Version, socks5MethodUserPass})
+	// 用户名/密码认证
+	io.ReadFull(conn, buf[:2])
+	n := int(buf[1])
+	io.ReadFull(conn, buf[:n])
+	username := string(buf[:n])
+	io.ReadFull(conn, buf[:1])
+	n = int(buf[0])
+	io.ReadFull(conn, buf[:n])
+	password := string(buf[:n])
+	if username != "user" || password != "pass" {
+		conn.Write([]byte{socks5UserPassVersion, 0x01})
+		return
+	}
+	conn.Write([]byte{socks5UserPassVersion, 0x00})
+	// UDP ASSOCIATE 请求，客户端地址为 IPv4
+	io.ReadFull(conn, buf[:10])
+	if buf[1] != socks5CmdUDPAssociate {
+		t.Errorf("unexpected command %d", buf[1])
+		return
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer relay.Close()
+	reply, _ := socks5Addr("0.0.0.0", relay.LocalAddr().(*net.UDPAddr).Port)
+	conn.Write(append([]byte{socks5Version, 0x00, 0x00}, reply...))
+	go func() {
+		packet := make([]byte, 2048)
+		for {
+			n, from, err := relay.ReadFromUDP(packet)
+			if err != nil {
+				return
+			}
+			relay.WriteToUDP(packet[:n], from)
+		}
+	}()
+	io.Copy(io.Discard, conn)
+}
+
+func TestDialUDPViaSOCKS5(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveTestSOCKS5UDP(t, l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	proxyURL := &url.URL{Scheme: "socks5", Host: l.Addr().String(), User: url.UserPassword("user", "pass")}
+	conn, err := DialUDPViaSOCKS5(ctx, proxyURL, "dns.example:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte("query")) {
+		t.Errorf("got %q, want %q", buf[:n], "query")
+	}
+}
+
+func TestParseSOCKS5UDPHeader(t *testing.T) {
+	for _, target := range []string{"192.0.2.1:53", "[2001:db8::1]:443", "example.com:8080"} {
+		header, err := socks5UDPHeader(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := parseSOCKS5UDPHeader(append(header, "payload"...))
+		if err != nil || n != len(header) {
+			t.Errorf("%s: got %d, %v, want %d", target, n, err, len(header))
+		}
+	}
+	if _, err := parseSOCKS5UDPHeader([]byte{0, 0, 1, socks5AddrIPv4, 192, 0, 2, 1, 0, 53}); err == nil {
+		t.Error("expected error for fragmented datagram")
+	}
+}
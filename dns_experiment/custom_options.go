@@ -11,6 +11,7 @@ import (
 
 	"github.com/ameshkov/dnscrypt/v2"
 	"github.com/masx200/dnsproxy/upstream"
+	"github.com/masx200/http-proxy-go-server/options"
 )
 
 // CustomUpstreamOptions 自定义上游选项，支持指定服务器IP
@@ -64,16 +65,14 @@ func (c *CustomUpstreamOptions) DialTCP(ctx context.Context, addr string) (net.C
 
 		// 使用指定的服务器IP
 		customAddr := net.JoinHostPort(c.serverIP, port)
-		dialer := &net.Dialer{
-			Timeout: c.GetTimeout(),
-		}
+		dialer := options.NewDialer("tcp", customAddr)
+		dialer.Timeout = c.GetTimeout()
 		return dialer.DialContext(ctx, "tcp", customAddr)
 	}
 
 	// 如果没有指定服务器IP，使用默认拨号
-	dialer := &net.Dialer{
-		Timeout: c.GetTimeout(),
-	}
+	dialer := options.NewDialer("tcp", addr)
+	dialer.Timeout = c.GetTimeout()
 	return dialer.DialContext(ctx, "tcp", addr)
 }
 
@@ -91,9 +90,8 @@ func (c *CustomUpstreamOptions) DialUDP(ctx context.Context, addr string) (*net.
 
 		// 使用指定的服务器IP
 		customAddr := net.JoinHostPort(c.serverIP, port)
-		dialer := &net.Dialer{
-			Timeout: c.GetTimeout(),
-		}
+		dialer := options.NewDialer("udp", customAddr)
+		dialer.Timeout = c.GetTimeout()
 
 		conn, err := dialer.DialContext(ctx, "udp", customAddr)
 		if err != nil {
@@ -111,9 +109,8 @@ func (c *CustomUpstreamOptions) DialUDP(ctx context.Context, addr string) (*net.
 	}
 
 	// 如果没有指定服务器IP，使用默认拨号
-	dialer := &net.Dialer{
-		Timeout: c.GetTimeout(),
-	}
+	dialer := options.NewDialer("udp", addr)
+	dialer.Timeout = c.GetTimeout()
 
 	conn, err := dialer.DialContext(ctx, "udp", addr)
 	if err != nil {
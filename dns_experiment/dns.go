@@ -26,6 +26,7 @@ import (
 	"time"
 
 	doq "github.com/masx200/doq-go/doq"
+	"github.com/masx200/http-proxy-go-server/options"
 	"github.com/masx200/http-proxy-go-server/utils"
 	print_experiment "github.com/masx200/http3-reverse-proxy-server-experiment/print"
 	"github.com/miekg/dns"
@@ -327,7 +328,7 @@ func getOrCreateDohClient(dohServerURL, dohip string, Proxy func(*http.Request)
 				newAddr = addr
 				log.Println("DialTLSContext detected proxy, using original addr:", addr)
 			}
-			dialer := &net.Dialer{}
+			dialer := options.NewDialerContext(options.WithOutboundHost(ctx, address), network, newAddr)
 			log.Println("DialTLSContext", "dialing", network, "to", newAddr)
 			conn, err := dialer.DialContext(ctx, network, newAddr)
 			if err != nil {
@@ -351,7 +352,7 @@ func getOrCreateDohClient(dohServerURL, dohip string, Proxy func(*http.Request)
 				return nil, err
 			}
 			if utils.IsLoopbackIP(host) {
-				var dialer = &net.Dialer{}
+				var dialer = options.NewDialer(network, addr)
 				return dialer.DialContext(ctx, network, addr)
 			}
 			log.Println("DialContext", "dialing", network, "to", addr)
@@ -372,13 +373,12 @@ func getOrCreateDohClient(dohServerURL, dohip string, Proxy func(*http.Request)
 				}
 			}
 			newAddr := net.JoinHostPort(serverIP, port)
-			dialer := &net.Dialer{}
 			if transport.Proxy != nil && port != originalPort {
 				log.Println("DialContext detected proxy, using original addr:", addr)
-				return dialer.DialContext(ctx, network, addr)
+				return options.DialContext(ctx, network, addr)
 			}
 			log.Println("dialContext", "dialing", network, "to", newAddr)
-			return dialer.DialContext(ctx, network, newAddr)
+			return options.DialContext(ctx, network, newAddr)
 		}
 		transport.DialContext = DialContext
 		// When dohip is specified, the custom DialContext/DialTLSContext
@@ -394,6 +394,7 @@ func getOrCreateDohClient(dohServerURL, dohip string, Proxy func(*http.Request)
 		// 无指定 dohip：创建独立的默认 transport，不修改全局 http.DefaultClient
 		// DoH should connect directly to servers, not through the upstream proxy.
 		transport := &http.Transport{
+			DialContext:         options.DialContext,
 			ForceAttemptHTTP2:   true,
 			MaxIdleConns:        10,
 			MaxIdleConnsPerHost: 5,
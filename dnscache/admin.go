@@ -0,0 +1,235 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultAdminListLimit 列出缓存记录时默认返回的最大条数
+const defaultAdminListLimit = 1000
+
+// adminChangeHook 管理接口删除、清空或修改固定解析之后调用，用于丢弃按旧解析结果建立的连接
+var adminChangeHook atomic.Pointer[func()]
+
+// SetAdminChangeHook 设置管理接口改变解析结果（删除、清空、固定和取消固定解析）之后调用的函数
+func SetAdminChangeHook(fn func()) {
+	adminChangeHook.Store(&fn)
+}
+
+func notifyAdminChange() {
+	if fn := adminChangeHook.Load(); fn != nil && *fn != nil {
+		(*fn)()
+	}
+}
+
+// EntryInfo 缓存记录的查看信息
+type EntryInfo struct {
+	Key    string      `json:"key"`
+	Type   string      `json:"type"`
+	Domain string      `json:"domain"`
+	Value  interface{} `json:"value"`
+	TTL    int64       `json:"ttl"` // 剩余秒数，-1 表示不过期
+	Pinned bool        `json:"pinned,omitempty"`
+}
+
+// matchDomain 判断域名是否匹配模式，模式支持 * 和 ? 通配符，
+// 与 hosts 表一致，*.example.com 同时匹配 example.com 本身
+func matchDomain(pattern, domain string) bool {
+	pattern = normalizeDomain(pattern)
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return pattern == domain
+	}
+	if strings.HasPrefix(pattern, "*.") && domain == pattern[2:] {
+		return true
+	}
+	matched, _ := path.Match(pattern, domain)
+	return matched
+}
+
+// Entries 列出域名匹配 pattern 的缓存记录（不含固定解析），dnsType 为空时不限类型，按键排序
+func (dc *DNSCache) Entries(pattern, dnsType string, limit int) []EntryInfo {
+	if dc.cache == nil {
+		return nil
+	}
+	dnsType = strings.ToUpper(dnsType)
+	now := time.Now().UnixNano()
+	var entries []EntryInfo
+	for key, item := range dc.cache.Items() {
+		t, domain, _ := keyDomain(key)
+		if (dnsType != "" && t != dnsType) || !matchDomain(pattern, domain) {
+			continue
+		}
+		ttl := int64(-1)
+		if item.Expiration > 0 {
+			ttl = (item.Expiration - now) / int64(time.Second)
+		}
+		entries = append(entries, EntryInfo{Key: key, Type: t, Domain: domain, Value: item.Object, TTL: ttl})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// Lookup 返回某个域名的固定解析和所有缓存记录（包括各类型、ECS 子网）
+func (dc *DNSCache) Lookup(name string) []EntryInfo {
+	if dc.cache == nil {
+		return nil
+	}
+	domain := normalizeDomain(name)
+	var entries []EntryInfo
+	if ips, ok := dc.pins.lookup(domain); ok {
+		entries = append(entries, EntryInfo{Key: "PIN:" + domain, Type: "PIN", Domain: domain, Value: ips, TTL: -1, Pinned: true})
+	}
+	return append(entries, dc.Entries(domain, "", 0)...)
+}
+
+// DeleteMatching 删除域名匹配 pattern 的所有缓存记录，返回删除的条数
+// 删除会写入持久化后端，重启后不会恢复
+func (dc *DNSCache) DeleteMatching(pattern string) int {
+	if dc.cache == nil {
+		return 0
+	}
+	deleted := 0
+	for key := range dc.cache.Items() {
+		// fake-IP 映射与固定解析一样不能删除，否则已分配给客户端的地址无法还原
+		if pinnedKey(key) {
+			continue
+		}
+		if _, domain, _ := keyDomain(key); matchDomain(pattern, domain) {
+			dc.deleteKey(key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// AdminHandler 返回缓存管理 HTTP 接口，应只监听在回环地址上
+//
+//	GET    /cache/stats                          统计信息
+//	GET    /cache/entries?pattern=&type=&limit=  列出记录及剩余 TTL
+//	GET    /cache/lookup?name=                   查看某个域名的所有记录和固定解析
+//	DELETE /cache/entries?name=|pattern=         按域名或通配符删除
+//	POST   /cache/flush                          清空所有记录（固定解析保留）
+//	GET    /cache/pins                           列出固定解析
+//	PUT    /cache/pins?name=&ip=                 固定解析，ip 可用逗号分隔多个
+//	DELETE /cache/pins?name=                     取消固定解析
+//
+// 删除、清空和修改固定解析之后调用 SetAdminChangeHook 设置的函数。
+// 删除和清空不影响 fake-IP 映射。redis 后端下列出、删除和清空只遍历本实例内存中的一级缓存，
+// 被删除的记录同时从 redis 删除，只在 redis 中的记录不受影响
+func (dc *DNSCache) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, dc.Stats())
+	})
+	mux.HandleFunc("GET /cache/entries", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := defaultAdminListLimit
+		if s := q.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", s))
+				return
+			}
+			limit = n
+		}
+		writeAdminJSON(w, http.StatusOK, dc.Entries(q.Get("pattern"), q.Get("type"), limit))
+	})
+	mux.HandleFunc("GET /cache/lookup", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("missing name"))
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, dc.Lookup(name))
+	})
+	mux.HandleFunc("DELETE /cache/entries", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		pattern := q.Get("name")
+		if pattern == "" {
+			pattern = q.Get("pattern")
+		}
+		if pattern == "" || pattern == "*" {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("missing name or pattern, use POST /cache/flush to delete everything"))
+			return
+		}
+		deleted := dc.DeleteMatching(pattern)
+		fmt.Printf("缓存管理: 删除 %s，共 %d 条\n", pattern, deleted)
+		notifyAdminChange()
+		writeAdminJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+	})
+	mux.HandleFunc("POST /cache/flush", func(w http.ResponseWriter, r *http.Request) {
+		deleted := dc.DeleteMatching("*")
+		fmt.Printf("缓存管理: 清空缓存，共 %d 条\n", deleted)
+		notifyAdminChange()
+		writeAdminJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+	})
+	mux.HandleFunc("GET /cache/pins", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, dc.Pins())
+	})
+	mux.HandleFunc("PUT /cache/pins", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		name := q.Get("name")
+		if name == "" {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("missing name"))
+			return
+		}
+		var ips []net.IP
+		for _, s := range strings.Split(q.Get("ip"), ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid ip %q", s))
+				return
+			}
+			ips = append(ips, ip)
+		}
+		if err := dc.Pin(name, ips); err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		fmt.Printf("缓存管理: 固定解析 %s -> %v\n", name, ips)
+		notifyAdminChange()
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"name": normalizeDomain(name), "ips": ips})
+	})
+	mux.HandleFunc("DELETE /cache/pins", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		found, err := dc.Unpin(name)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeAdminError(w, http.StatusNotFound, fmt.Errorf("no pin for %q", name))
+			return
+		}
+		fmt.Printf("缓存管理: 取消固定解析 %s\n", name)
+		notifyAdminChange()
+		writeAdminJSON(w, http.StatusOK, map[string]string{"name": normalizeDomain(name)})
+	})
+	return mux
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
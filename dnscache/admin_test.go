@@ -0,0 +1,92 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.PinsPath = filepath.Join(dir, "pins.json")
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+
+	cache.SetIP("A", "www.example.com", net.ParseIP("192.0.2.1"), time.Minute)
+	cache.SetIP("AAAA", "www.example.com", net.ParseIP("2001:db8::1"), time.Minute)
+	cache.SetIP("A", "example.com", net.ParseIP("192.0.2.2"), time.Minute)
+	cache.SetIP("A", "example.org", net.ParseIP("192.0.2.3"), time.Minute)
+
+	var changes int
+	SetAdminChangeHook(func() { changes++ })
+	defer SetAdminChangeHook(nil)
+
+	server := httptest.NewServer(cache.AdminHandler())
+	defer server.Close()
+	do := func(method, path string, v interface{}) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+
+	var entries []EntryInfo
+	if do("GET", "/cache/entries?pattern=*.example.com", &entries); len(entries) != 3 {
+		t.Fatalf("Expected 3 entries for *.example.com, got %v", entries)
+	}
+	if entries[0].TTL <= 0 || entries[0].TTL > 60 {
+		t.Errorf("Expected remaining TTL within a minute, got %d", entries[0].TTL)
+	}
+
+	var result map[string]int
+	if do("DELETE", "/cache/entries?pattern=*.example.com", &result); result["deleted"] != 3 {
+		t.Errorf("Expected 3 deleted, got %v", result)
+	}
+	if _, ok := cache.GetIP("A", "example.org"); !ok {
+		t.Error("Expected example.org to survive wildcard delete")
+	}
+
+	// 固定解析优先于缓存，不受 flush 影响，并按类型过滤地址族
+	if code := do("PUT", "/cache/pins?name=example.org&ip=198.51.100.1,2001:db8::2", nil); code != http.StatusOK {
+		t.Fatalf("Expected pin to succeed, got %d", code)
+	}
+	do("POST", "/cache/flush", nil)
+	if ip, ok := cache.GetIP("A", "example.org"); !ok || ip.String() != "198.51.100.1" {
+		t.Errorf("Expected pinned A record, got %v", ip)
+	}
+	if ip, ok := cache.GetIP("AAAA", "Example.org."); !ok || ip.String() != "2001:db8::2" {
+		t.Errorf("Expected pinned AAAA record, got %v", ip)
+	}
+
+	// 固定解析持久化到文件
+	if pins := newPinTable(config.PinsPath); pins.len() != 1 {
+		t.Errorf("Expected pin to be persisted, got %d", pins.len())
+	}
+	if code := do("DELETE", "/cache/pins?name=example.org", nil); code != http.StatusOK {
+		t.Errorf("Expected unpin to succeed, got %d", code)
+	}
+	if _, ok := cache.GetIP("A", "example.org"); ok {
+		t.Error("Expected no record after unpin and flush")
+	}
+	// 删除、固定、清空、取消固定各通知一次
+	if changes != 4 {
+		t.Errorf("Expected 4 change notifications, got %d", changes)
+	}
+}
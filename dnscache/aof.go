@@ -0,0 +1,247 @@
+package dnscache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AOF 文件格式：
+//
+//	文件头: "DNSAOF01" (8 字节) + 代号 generation (uint64 大端)
+//	记录:   长度 (uint32 大端) + CRC32-C 校验 (uint32 大端) + JSON 编码的 AOFEntry
+//
+// 崩溃时最多留下一条不完整的记录，读取时在最后一条有效记录处停止。
+// 不带文件头的文件按旧版 JSON Lines 格式读取。
+const (
+	aofMagic        = "DNSAOF01"
+	aofHeaderSize   = len(aofMagic) + 8
+	aofRecordHeader = 8
+	// aofMaxRecordSize 单条记录的长度上限，超过视为损坏
+	aofMaxRecordSize = 16 * 1024 * 1024
+)
+
+var aofCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// AppendFsync AOF 刷盘策略，与 Redis 的 appendfsync 含义相同
+type AppendFsync string
+
+const (
+	// AppendFsyncAlways 每条记录写入后立即 fsync，最安全也最慢
+	AppendFsyncAlways AppendFsync = "always"
+	// AppendFsyncEverySec 每秒 fsync 一次，崩溃时最多丢失约 1 秒的记录
+	AppendFsyncEverySec AppendFsync = "everysec"
+	// AppendFsyncNo 不主动 fsync，由操作系统决定何时落盘
+	AppendFsyncNo AppendFsync = "no"
+)
+
+// ParseAppendFsync 解析刷盘策略，空字符串返回 everysec
+func ParseAppendFsync(s string) (AppendFsync, error) {
+	switch AppendFsync(strings.ToLower(strings.TrimSpace(s))) {
+	case "", AppendFsyncEverySec:
+		return AppendFsyncEverySec, nil
+	case AppendFsyncAlways:
+		return AppendFsyncAlways, nil
+	case AppendFsyncNo:
+		return AppendFsyncNo, nil
+	default:
+		return "", fmt.Errorf("invalid appendfsync policy %q (want always, everysec or no)", s)
+	}
+}
+
+// errAOFCorrupt 读取到损坏或不完整的记录
+var errAOFCorrupt = errors.New("aof record corrupt")
+
+// aofHeader 生成文件头
+func aofHeader(generation uint64) []byte {
+	header := make([]byte, aofHeaderSize)
+	copy(header, aofMagic)
+	binary.BigEndian.PutUint64(header[len(aofMagic):], generation)
+	return header
+}
+
+// encodeAOFRecord 将条目编码为带长度和校验的记录
+func encodeAOFRecord(entry AOFEntry) ([]byte, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, aofRecordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, aofCRCTable))
+	copy(record[aofRecordHeader:], payload)
+	return record, nil
+}
+
+// aofReadResult 读取 AOF 的结果
+type aofReadResult struct {
+	Legacy     bool   // 旧版 JSON Lines 格式
+	Generation uint64 // 文件头中的代号，旧版格式为 0
+	Records    int    // 有效记录数
+	ValidSize  int64  // 最后一条有效记录结束处的偏移
+	TotalSize  int64  // 文件总大小
+}
+
+// Corrupt 文件尾部是否存在无效数据
+func (r aofReadResult) Corrupt() bool {
+	return r.ValidSize < r.TotalSize
+}
+
+// readAOF 读取 AOF 文件并对每条有效记录调用 fn，遇到第一条无效记录时停止
+func readAOF(path string, fn func(AOFEntry)) (aofReadResult, error) {
+	var result aofReadResult
+	file, err := os.Open(path)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil {
+		result.TotalSize = info.Size()
+	}
+
+	reader := bufio.NewReader(file)
+	head, err := reader.Peek(aofHeaderSize)
+	if err != nil || string(head[:len(aofMagic)]) != aofMagic {
+		result.Legacy = true
+		return result, readLegacyAOF(reader, &result, fn)
+	}
+	result.Generation = binary.BigEndian.Uint64(head[len(aofMagic):])
+	reader.Discard(aofHeaderSize)
+	result.ValidSize = int64(aofHeaderSize)
+
+	recordHeader := make([]byte, aofRecordHeader)
+	for {
+		if _, err := io.ReadFull(reader, recordHeader); err != nil {
+			if err == io.EOF {
+				return result, nil
+			}
+			return result, errAOFCorrupt
+		}
+		length := binary.BigEndian.Uint32(recordHeader[0:4])
+		if length == 0 || length > aofMaxRecordSize {
+			return result, errAOFCorrupt
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return result, errAOFCorrupt
+		}
+		if crc32.Checksum(payload, aofCRCTable) != binary.BigEndian.Uint32(recordHeader[4:8]) {
+			return result, errAOFCorrupt
+		}
+		var entry AOFEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			return result, errAOFCorrupt
+		}
+		fn(entry)
+		result.Records++
+		result.ValidSize += int64(aofRecordHeader) + int64(length)
+	}
+}
+
+// readLegacyAOF 读取旧版 JSON Lines 格式，遇到无法解析或没有换行结尾的行时停止
+func readLegacyAOF(reader *bufio.Reader, result *aofReadResult, fn func(AOFEntry)) error {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) == 0 {
+				result.ValidSize += int64(len(line))
+				return nil
+			}
+			return errAOFCorrupt
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry AOFEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return errAOFCorrupt
+			}
+			fn(entry)
+			result.Records++
+		}
+		result.ValidSize += int64(len(line))
+	}
+}
+
+// RepairAOF 检查 AOF 文件并截断到最后一条有效记录，返回被截掉的字节数
+func RepairAOF(path string) (int64, error) {
+	result, err := readAOF(path, func(AOFEntry) {})
+	if err != nil && err != errAOFCorrupt {
+		return 0, err
+	}
+	if !result.Corrupt() {
+		return 0, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if err := file.Truncate(result.ValidSize); err != nil {
+		return 0, fmt.Errorf("截断AOF文件失败: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return result.TotalSize - result.ValidSize, nil
+}
+
+// syncDir fsync 目录，保证重命名操作落盘（Windows 不支持，忽略错误）
+func syncDir(path string) {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+// writeFileAtomic 写入临时文件并 fsync 后重命名到目标路径
+// 重命名失败时（常见于 Docker bind mount）退化为直接覆盖写入
+func writeFileAtomic(path string, data []byte) error {
+	tempFile := path + ".tmp"
+	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("同步临时文件失败: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("关闭临时文件失败: %w", err)
+	}
+
+	if err := os.Rename(tempFile, path); err != nil {
+		fmt.Printf("重命名失败，尝试备选方案: %v\n", err)
+		target, openErr := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if openErr != nil {
+			return fmt.Errorf("直接写入目标文件失败: %w", openErr)
+		}
+		if _, writeErr := target.Write(data); writeErr != nil {
+			target.Close()
+			return fmt.Errorf("直接写入目标文件失败: %w", writeErr)
+		}
+		target.Sync()
+		target.Close()
+		if removeErr := os.Remove(tempFile); removeErr != nil {
+			fmt.Printf("警告: 删除临时文件失败: %v\n", removeErr)
+		}
+		return nil
+	}
+	syncDir(path)
+	return nil
+}
@@ -0,0 +1,131 @@
+package dnscache
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newAOFTestConfig(dir string) *Config {
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.AppendFsync = AppendFsyncAlways
+	return config
+}
+
+func TestAOFTruncatedTailRecovery(t *testing.T) {
+	config := newAOFTestConfig(t.TempDir())
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache.SetIP("A", "a.example.com", net.ParseIP("192.0.2.1"), time.Hour)
+	cache.Set("TXT", "b.example.com", "hello", time.Hour)
+	time.Sleep(100 * time.Millisecond)
+
+	// 模拟崩溃：不调用 Close，在AOF末尾留下一条写了一半的记录
+	good, err := os.ReadFile(config.AOFPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, _ := encodeAOFRecord(AOFEntry{Operation: "SET", Key: "TXT:c.example.com", Value: "lost"})
+	f, err := os.OpenFile(config.AOFPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(partial[:len(partial)-3])
+	f.Close()
+
+	removed, err := RepairAOF(config.AOFPath)
+	if err != nil {
+		t.Fatalf("RepairAOF failed: %v", err)
+	}
+	if removed != int64(len(partial)-3) {
+		t.Errorf("Expected %d bytes removed, got %d", len(partial)-3, removed)
+	}
+	if data, _ := os.ReadFile(config.AOFPath); len(data) != len(good) {
+		t.Errorf("Expected AOF to be truncated to %d bytes, got %d", len(good), len(data))
+	}
+
+	reloaded, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to reload cache: %v", err)
+	}
+	defer reloaded.Close()
+	if _, ok := reloaded.GetIP("A", "a.example.com"); !ok {
+		t.Error("Expected a.example.com to be recovered from AOF")
+	}
+	if value, ok := reloaded.Get("TXT", "b.example.com"); !ok || value != "hello" {
+		t.Errorf("Expected TXT record to be recovered, got %v", value)
+	}
+}
+
+func TestAOFGenerationSkipsStaleLog(t *testing.T) {
+	config := newAOFTestConfig(t.TempDir())
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache.Set("TXT", "deleted.example.com", "old", time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	stale, _ := os.ReadFile(config.AOFPath)
+
+	cache.Delete("TXT", "deleted.example.com")
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if cache.Stats()["generation"].(uint64) != 1 {
+		t.Errorf("Expected generation 1 after save, got %v", cache.Stats()["generation"])
+	}
+	cache.Close()
+
+	// 模拟快照已写入但AOF尚未切换时崩溃：旧代号的AOF不应被重放
+	if err := os.WriteFile(config.AOFPath, stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to reload cache: %v", err)
+	}
+	defer reloaded.Close()
+	if _, ok := reloaded.Get("TXT", "deleted.example.com"); ok {
+		t.Error("Expected stale AOF generation to be skipped")
+	}
+}
+
+func TestAOFLegacyFormat(t *testing.T) {
+	config := newAOFTestConfig(t.TempDir())
+	legacy := `{"timestamp":"2026-01-01T00:00:00Z","operation":"SET","key":"A:legacy.example.com","value":["192.0.2.9"],"ttl":3600}
+{"timestamp":"2026-01-01T00:00:00Z","operation":"SET","key":"A:trunc`
+	if err := os.WriteFile(config.AOFPath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+	if ip, ok := cache.GetIP("A", "legacy.example.com"); !ok || ip.String() != "192.0.2.9" {
+		t.Errorf("Expected legacy AOF entry to be replayed, got %v", ip)
+	}
+
+	// 启动后AOF已升级为带校验的格式
+	result, err := readAOF(config.AOFPath, func(AOFEntry) {})
+	if err != nil || result.Legacy || result.Records != 1 {
+		t.Errorf("Expected upgraded AOF with 1 record, got %+v (err=%v)", result, err)
+	}
+}
+
+func TestParseAppendFsync(t *testing.T) {
+	for input, want := range map[string]AppendFsync{"": AppendFsyncEverySec, "Always": AppendFsyncAlways, "no": AppendFsyncNo} {
+		if got, err := ParseAppendFsync(input); err != nil || got != want {
+			t.Errorf("ParseAppendFsync(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseAppendFsync("sometimes"); err == nil {
+		t.Error("Expected error for invalid policy")
+	}
+}
@@ -0,0 +1,199 @@
+package dnscache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket 存放缓存记录的 bucket
+var boltBucket = []byte("dnscache")
+
+// boltStore bbolt 持久化后端
+// 每次写入在事务中提交（队列中积压的写入合并为一个事务），不需要定期全量快照。
+// 每次提交都 fsync：bbolt 在 NoSync 下崩溃可能损坏数据库文件，因此不使用 appendfsync 策略；
+// 值的格式为 8 字节过期时间（UnixNano，大端，0 表示不过期）+ JSON 编码的值
+type boltStore struct {
+	path   string
+	db     *bolt.DB
+	closed atomic.Bool
+	pruned atomic.Int64 // 清理的过期记录数
+}
+
+// NewBoltStore 打开或创建 bbolt 数据库作为持久化后端
+func NewBoltStore(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建缓存目录失败: %w", err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开bbolt数据库失败: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化bbolt数据库失败: %w", err)
+	}
+	return &boltStore{path: path, db: db}, nil
+}
+
+// encodeBoltValue 编码记录值
+func encodeBoltValue(value interface{}, ttl time.Duration) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8+len(data))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(buf, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(buf[8:], data)
+	return buf, nil
+}
+
+// boltExpiration 读取记录的过期时间，0 表示不过期
+func boltExpiration(data []byte) int64 {
+	if len(data) < 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+// Load 读取所有未过期的记录到 c 中
+func (bs *boltStore) Load(c *cache.Cache) error {
+	now := time.Now().UnixNano()
+	loadedCount := 0
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			if len(v) < 8 {
+				return nil
+			}
+			ttl := DefaultTTL
+			if expiration := boltExpiration(v); expiration > 0 {
+				if expiration <= now {
+					return nil // 已过期，等待 Checkpoint 清理
+				}
+				ttl = time.Duration(expiration - now)
+			}
+			value, err := decodeStoredValue(v[8:])
+			if err != nil {
+				fmt.Printf("警告: 跳过无法解析的缓存记录 %s: %v\n", k, err)
+				return nil
+			}
+			c.Set(string(k), value, ttl)
+			loadedCount++
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("读取bbolt数据库失败: %w", err)
+	}
+	fmt.Printf("bbolt数据库加载完成，共加载 %d 条记录\n", loadedCount)
+	return nil
+}
+
+// Append 在事务中持久化一次写入
+func (bs *boltStore) Append(operation, key string, value interface{}, ttl time.Duration) error {
+	return bs.appendBatch([]storeOp{{operation: operation, key: key, value: value, ttl: ttl}})
+}
+
+// appendBatch 在一个事务中按顺序持久化多次写入
+func (bs *boltStore) appendBatch(ops []storeOp) error {
+	if bs.closed.Load() {
+		return nil
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, op := range ops {
+			switch op.operation {
+			case "SET":
+				data, err := encodeBoltValue(op.value, op.ttl)
+				if err != nil {
+					// 无法编码的值只跳过这一条，不影响同一事务中的其它写入
+					fmt.Printf("警告: 跳过无法编码的缓存记录 %s: %v\n", op.key, err)
+					continue
+				}
+				if err := bucket.Put([]byte(op.key), data); err != nil {
+					return err
+				}
+			case "DELETE", "EVICT":
+				if err := bucket.Delete([]byte(op.key)); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown store operation %s", op.operation)
+			}
+		}
+		return nil
+	})
+}
+
+// Checkpoint 清理已过期的记录，只读取每条记录的过期时间
+func (bs *boltStore) Checkpoint() error {
+	if bs.closed.Load() {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if expiration := boltExpiration(v); expiration > 0 && expiration <= now {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		bs.pruned.Add(int64(len(expired)))
+		return nil
+	})
+}
+
+// Maintain bbolt 不需要额外维护
+func (bs *boltStore) Maintain() error {
+	return nil
+}
+
+// Sync 每次提交都已 fsync，不需要额外同步
+func (bs *boltStore) Sync() error {
+	return nil
+}
+
+// Close 关闭数据库
+func (bs *boltStore) Close() error {
+	if !bs.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	return bs.db.Close()
+}
+
+// Stats 返回持久化相关的统计信息
+func (bs *boltStore) Stats() map[string]interface{} {
+	stats := map[string]interface{}{
+		"backend": string(BackendBolt),
+		"db_path": bs.path,
+		"pruned":  bs.pruned.Load(),
+	}
+	if bs.closed.Load() {
+		return stats
+	}
+	bs.db.View(func(tx *bolt.Tx) error {
+		stats["db_keys"] = tx.Bucket(boltBucket).Stats().KeyN
+		stats["db_size"] = tx.Size()
+		return nil
+	})
+	return stats
+}
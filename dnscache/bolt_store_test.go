@@ -0,0 +1,98 @@
+package dnscache
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStorePersistence(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.DBPath = filepath.Join(dir, "cache.db")
+	config.Backend = BackendBolt
+	config.AppendFsync = AppendFsyncAlways
+
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache.SetIPs("A", "example.com", []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}, time.Hour)
+	cache.Set("TXT", "example.com", "hello", time.Hour)
+	cache.Set("TXT", "deleted.example.com", "bye", time.Hour)
+	cache.Set("TXT", "expired.example.com", "old", 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	cache.Delete("TXT", "deleted.example.com")
+	time.Sleep(50 * time.Millisecond)
+
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if stats := cache.Stats(); stats["backend"] != "bbolt" || stats["pruned"].(int64) != 1 {
+		t.Errorf("Unexpected store stats: %v", stats)
+	}
+	cache.Close()
+
+	reloaded, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer reloaded.Close()
+	if ips, ok := reloaded.GetIPs("A", "example.com"); !ok || len(ips) != 2 {
+		t.Errorf("Expected 2 IPs after reload, got %v", ips)
+	}
+	if value, ok := reloaded.Get("TXT", "example.com"); !ok || value != "hello" {
+		t.Errorf("Expected TXT record after reload, got %v", value)
+	}
+	for _, domain := range []string{"deleted.example.com", "expired.example.com"} {
+		if _, ok := reloaded.Get("TXT", domain); ok {
+			t.Errorf("Expected %s to be absent after reload", domain)
+		}
+	}
+}
+
+// 同一记录连续的写入和删除按调用顺序持久化，关闭时等待队列中的写入完成
+func TestStoreAppendOrder(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.DBPath = filepath.Join(dir, "cache.db")
+	config.Backend = BackendBolt
+
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		domain := fmt.Sprintf("d%d.example", i%10)
+		cache.Set("TXT", domain, fmt.Sprint(i), time.Hour)
+		if i%3 == 0 {
+			cache.Delete("TXT", domain)
+		}
+	}
+	want := make(map[string]interface{})
+	for i := 0; i < 10; i++ {
+		domain := fmt.Sprintf("d%d.example", i)
+		if value, ok := cache.Get("TXT", domain); ok {
+			want[domain] = value
+		}
+	}
+	cache.Close()
+
+	reloaded, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer reloaded.Close()
+	for i := 0; i < 10; i++ {
+		domain := fmt.Sprintf("d%d.example", i)
+		value, ok := reloaded.Get("TXT", domain)
+		if expected, exists := want[domain]; ok != exists || value != expected {
+			t.Errorf("%s: expected %v (exists=%v) after reload, got %v (exists=%v)", domain, expected, exists, value, ok)
+		}
+	}
+}
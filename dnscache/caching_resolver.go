@@ -22,6 +22,14 @@ type NameResolver interface {
 	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
 }
 
+// DNSSECStatusResolver 可选接口，解析时同时返回 DNSSEC 验证状态
+type DNSSECStatusResolver interface {
+	LookupIPWithDNSSECStatus(ctx context.Context, network, host string) ([]net.IP, doh.DNSSECStatus, error)
+}
+
+// dnssecStatusType DNSSEC 验证状态在缓存中的类型，与 lookupip 使用相同的 key
+const dnssecStatusType = "dnssec"
+
 // CachingResolver 包装器，为DNS解析添加缓存功能
 type CachingResolver struct {
 	original NameResolver
@@ -40,7 +48,9 @@ func NewCachingResolver(original NameResolver, cache *DNSCache) *CachingResolver
 func (c *CachingResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
 	// 尝试从缓存获取
 	cacheType := "resolve"
-	if cached, found := c.cache.Get(cacheType, name); found {
+	cacheKey := ecsCacheKey(ctx, name)
+	ctx = c.recordHit(ctx, name)
+	if cached, found := c.cache.Get(cacheType, cacheKey); found {
 		log.Printf("DNS cache hit for resolve: %s", name)
 		if ip, ok := cached.(net.IP); ok {
 			return ctx, ip, nil
@@ -54,7 +64,7 @@ func (c *CachingResolver) Resolve(ctx context.Context, name string) (context.Con
 	}
 
 	// 存储到缓存，使用默认TTL
-	c.cache.Set(cacheType, name, ip, 0)
+	c.cache.Set(cacheType, cacheKey, ip, 0)
 	log.Printf("DNS cache set for resolve: %s -> %s", name, ip)
 
 	return resolvedCtx, ip, nil
@@ -64,7 +74,8 @@ func (c *CachingResolver) Resolve(ctx context.Context, name string) (context.Con
 func (c *CachingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
 	// 尝试从缓存获取
 	cacheType := "lookupip"
-	cacheKey := fmt.Sprintf("%s:%s", network, host)
+	cacheKey := ecsCacheKey(ctx, fmt.Sprintf("%s:%s", network, host))
+	c.recordHit(ctx, host)
 	if cached, found := c.cache.Get(cacheType, cacheKey); found {
 		log.Printf("DNS cache hit for lookupip: %s (%s)", host, network)
 		if ips, ok := cached.([]net.IP); ok {
@@ -73,18 +84,60 @@ func (c *CachingResolver) LookupIP(ctx context.Context, network, host string) ([
 	}
 
 	// 缓存未命中，使用原始解析器
-	ips, err := c.original.LookupIP(ctx, network, host)
+	var ips []net.IP
+	var status doh.DNSSECStatus
+	var err error
+	if sr, ok := c.original.(DNSSECStatusResolver); ok {
+		ips, status, err = sr.LookupIPWithDNSSECStatus(ctx, network, host)
+	} else {
+		ips, err = c.original.LookupIP(ctx, network, host)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// 存储到缓存，使用默认TTL
 	c.cache.Set(cacheType, cacheKey, ips, 0)
+	if status != "" {
+		c.cache.Set(dnssecStatusType, cacheKey, string(status), 0)
+	}
 	log.Printf("DNS cache set for lookupip: %s (%s) -> %v", host, network, ips)
 
 	return ips, nil
 }
 
+// hitRecordedContextKey 标记已经计入访问统计的域名。Resolve 返回的 ctx 会用于随后的拨号，
+// 拨号时对同一域名的 LookupIP 不再重复计数
+type hitRecordedContextKey struct{}
+
+// recordHit 为一次客户端解析记录访问统计，预热发起的解析和已经记录过的同一域名不计数
+func (c *CachingResolver) recordHit(ctx context.Context, name string) context.Context {
+	if isWarmup(ctx) {
+		return ctx
+	}
+	domain := normalizeDomain(name)
+	if recorded, ok := ctx.Value(hitRecordedContextKey{}).(string); ok && recorded == domain {
+		return ctx
+	}
+	c.cache.RecordHit(domain)
+	return context.WithValue(ctx, hitRecordedContextKey{}, domain)
+}
+
+// ecsCacheKey 启用 EDNS Client Subnet 时在缓存 key 后追加 ECS 子网，
+// 使不同地区的客户端各自缓存上游返回的就近地址
+func ecsCacheKey(ctx context.Context, key string) string {
+	if scope := doh.ECSScopeFromContext(ctx); scope != "" {
+		return key + "@" + scope
+	}
+	return key
+}
+
+// GetDNSSECStatus 返回缓存中 LookupIP 结果的 DNSSEC 验证状态（secure/insecure）
+// 未启用 DNSSEC 或结果来自 hosts 时返回 false
+func (dc *DNSCache) GetDNSSECStatus(ctx context.Context, network, host string) (string, bool) {
+	return dc.lookupString(dnssecStatusType, ecsCacheKey(ctx, fmt.Sprintf("%s:%s", network, host)))
+}
+
 // CreateHostsResolverCached 创建带缓存的Hosts解析器
 func CreateHostsResolverCached(dnsCache *DNSCache) NameResolver {
 	original := &HostsResolver{}
@@ -175,12 +228,13 @@ func (d *DOHResolver) LookupIP(ctx context.Context, network string, host string)
 
 	var allErrors []error
 	for _, opt := range d.proxyoptions {
-		// 跳过 h3 配置，因为这是 DOHResolver
-		if opt.Dohalpn == "h3" {
+		// 只处理普通 DoH 配置，因为这是 DOHResolver
+		if opt.GetProtocol() != "doh" {
 			continue
 		}
 
-		ips, errors := doh.ResolveDomainToIPsWithDoh(host, opt.Dohurl, opt.Dohip, d.Proxy, transportConfigurations...)
+		opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+		ips, errors := doh.ResolveDomainToIPsWithOption(host, opt, d.Proxy, transportConfigurations...)
 		if len(ips) > 0 {
 			return ips, nil
 		}
@@ -224,17 +278,12 @@ func (d *DOH3Resolver) LookupIP(ctx context.Context, network string, host string
 	var allErrors []error
 	for _, opt := range d.proxyoptions {
 		// 只处理 h3 配置
-		if opt.Dohalpn != "h3" {
+		if opt.GetProtocol() != "doh3" {
 			continue
 		}
 
-		var ips []net.IP
-		var errors []error
-		if opt.Dohip == "" {
-			ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl)
-		} else {
-			ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl, opt.Dohip)
-		}
+		opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+		ips, errors := doh.ResolveDomainToIPsWithOption(host, opt, nil)
 
 		if len(ips) > 0 {
 			return ips, nil
@@ -271,37 +320,40 @@ type HostsAndDohResolver struct {
 
 // LookupIP implements NameResolver.
 func (h *HostsAndDohResolver) LookupIP(ctx context.Context, network string, host string) ([]net.IP, error) {
+	ips, _, err := h.LookupIPWithDNSSECStatus(ctx, network, host)
+	return ips, err
+}
+
+// LookupIPWithDNSSECStatus implements DNSSECStatusResolver.
+func (h *HostsAndDohResolver) LookupIPWithDNSSECStatus(ctx context.Context, network string, host string) ([]net.IP, doh.DNSSECStatus, error) {
 	var transportConfigurations = h.transportConfigurations
 	// 首先尝试使用 hosts 解析
 	ips, err := hosts.ResolveDomainToIPsWithHosts(host)
 	if err == nil && len(ips) > 0 {
-		return ips, nil
+		return ips, "", nil
 	}
 
 	// 如果 hosts 解析失败，尝试使用 DoH 解析
-	if len(h.proxyoptions) > 0 {
+	// 按域名选择分流DNS服务器
+	selected := options.SelectDNSOptions(h.proxyoptions, host)
+	if len(selected) > 0 {
 		// 随机打乱 proxyoptions 顺序
-		Shuffle(h.proxyoptions)
+		Shuffle(selected)
 
 		var allErrors []error
-		for _, opt := range h.proxyoptions {
-			var ips []net.IP
-			var errors []error
-
-			if opt.Dohalpn == "h3" {
-				// 使用 DOH3
-				if opt.Dohip == "" {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl)
-				} else {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl, opt.Dohip)
-				}
-			} else {
-				// 使用 DOH
-				ips, errors = doh.ResolveDomainToIPsWithDoh(host, opt.Dohurl, opt.Dohip, h.Proxy, transportConfigurations...)
+		for _, opt := range selected {
+			// 跳过熔断中的DNS服务器
+			if err := options.CircuitBreakerAllow(options.DNSServerCircuitKey(opt)); err != nil {
+				allErrors = append(allErrors, err)
+				continue
 			}
+			// 按协议类型选择 DoH/DoH3/DoT/DoQ，启用 DNSSEC 时同时返回验证状态
+			opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+			ips, status, errors := doh.ResolveDomainToIPsWithOptionStatus(host, opt, h.Proxy, transportConfigurations...)
+			options.ReportDNSServerResult(opt, len(ips) > 0, errors)
 
 			if len(ips) > 0 {
-				return ips, nil
+				return ips, status, nil
 			}
 			if len(errors) > 0 {
 				allErrors = append(allErrors, errors...)
@@ -309,15 +361,15 @@ func (h *HostsAndDohResolver) LookupIP(ctx context.Context, network string, host
 		}
 
 		if len(allErrors) > 0 {
-			return nil, fmt.Errorf("DOH resolution failed for %s: %v", host, allErrors)
+			return nil, "", fmt.Errorf("DOH resolution failed for %s: %v", host, allErrors)
 		}
 	}
 
 	// 如果都失败了，返回原始的 hosts 错误
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return nil, fmt.Errorf("no IP addresses found for domain %s", host)
+	return nil, "", fmt.Errorf("no IP addresses found for domain %s", host)
 }
 
 // Resolve implements NameResolver.
@@ -347,6 +399,11 @@ func (e ErrorArray) Error() string {
 	return "ErrorArray:[" + strings.Join(errStrings, ", ") + "]"
 }
 
+// Unwrap 让 errors.Is/errors.As 可以检查其中的每个错误
+func (e ErrorArray) Unwrap() []error {
+	return e
+}
+
 // Shuffle 对切片进行随机排序
 func Shuffle[T any](slice []T) {
 	var rand1 = rand.New(rand.NewSource(time.Now().UnixNano())) // 使用当前时间作为随机种子
@@ -362,22 +419,32 @@ func IsIP(s string) bool {
 
 // Proxy_net_DialCached 带DNS缓存的网络连接拨号函数
 func Proxy_net_DialCached(network string, addr string, proxyoptions options.ProxyOptionsDNSSLICE, upstreamResolveIPs bool, dnsCache *DNSCache, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) (net.Conn, error) {
-	if dnsCache != nil {
-		return proxy_net_DialWithResolver(context.Background(), network, addr, proxyoptions, upstreamResolveIPs, dnsCache, CreateHostsAndDohResolverCached(proxyoptions, dnsCache, Proxy, tranportConfigurations...), Proxy, tranportConfigurations...)
-	}
-	return proxy_net_DialOriginal(network, addr, proxyoptions, Proxy, tranportConfigurations...)
+	// 目标连续连接失败时由熔断器直接拒绝，不再逐个尝试解析出的地址
+	return options.CircuitBreakerDial(network, dnsCache.RestoreFakeIPAddress(addr), func() (net.Conn, error) {
+		if dnsCache != nil {
+			return proxy_net_DialWithResolver(context.Background(), network, addr, proxyoptions, upstreamResolveIPs, dnsCache, CreateHostsAndDohResolverCached(proxyoptions, dnsCache, Proxy, tranportConfigurations...), Proxy, tranportConfigurations...)
+		}
+		return proxy_net_DialOriginal(network, addr, proxyoptions, Proxy, tranportConfigurations...)
+	})
 }
 
 // Proxy_net_DialContextCached 带DNS缓存的上下文网络连接拨号函数
 func Proxy_net_DialContextCached(ctx context.Context, network string, addr string, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *DNSCache, upstreamResolveIPs bool, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) (net.Conn, error) {
-	if dnsCache != nil {
-		return proxy_net_DialWithResolver(ctx, network, addr, proxyoptions, upstreamResolveIPs, dnsCache, CreateHostsAndDohResolverCached(proxyoptions, dnsCache, Proxy, tranportConfigurations...), Proxy, tranportConfigurations...)
-	}
-	return proxy_net_DialContextOriginal(ctx, network, addr, proxyoptions, Proxy, tranportConfigurations...)
+	// 目标连续连接失败时由熔断器直接拒绝，不再逐个尝试解析出的地址
+	return options.CircuitBreakerDial(network, dnsCache.RestoreFakeIPAddress(addr), func() (net.Conn, error) {
+		if dnsCache != nil {
+			return proxy_net_DialWithResolver(ctx, network, addr, proxyoptions, upstreamResolveIPs, dnsCache, CreateHostsAndDohResolverCached(proxyoptions, dnsCache, Proxy, tranportConfigurations...), Proxy, tranportConfigurations...)
+		}
+		return proxy_net_DialContextOriginal(ctx, network, addr, proxyoptions, Proxy, tranportConfigurations...)
+	})
 }
 
 // proxy_net_DialWithResolver 使用指定解析器的网络拨号函数
 func proxy_net_DialWithResolver(ctx context.Context, network string, addr string, proxyoptions options.ProxyOptionsDNSSLICE, upstreamResolveIPs bool, dnsCache interface{}, resolver NameResolver, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) (net.Conn, error) {
+	// fake-IP 需要还原为域名后再解析真实地址
+	if typedCache, ok := dnsCache.(*DNSCache); ok {
+		addr = typedCache.RestoreFakeIPAddress(addr)
+	}
 	hostname, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -397,33 +464,36 @@ func proxy_net_DialWithResolver(ctx context.Context, network string, addr string
 		} else if len(resolvedIPs) > 0 {
 			// 使用解析出的IP地址进行连接尝试
 			Shuffle(resolvedIPs)
-			for _, serverIP := range resolvedIPs {
-				newAddr := net.JoinHostPort(serverIP.String(), port)
-				dialer := &net.Dialer{}
-				connection, err1 := dialer.DialContext(ctx, network, newAddr)
-
-				if err1 != nil {
-					log.Printf("Failed to connect to upstream IP %s: %v", serverIP, err1)
-					continue
-				} else {
-					log.Printf("Successfully connected to upstream address=%s via resolved IP=%s", addr, serverIP)
-					return connection, err1
-				}
+			connection, err1 := options.DialHappyEyeballs(ctx, network, hostname, port, resolvedIPs)
+			if err1 == nil {
+				log.Printf("Successfully connected to upstream address=%s via resolved IP=%s", addr, connection.RemoteAddr())
+				return connection, nil
 			}
-			log.Printf("All resolved upstream IPs failed for address=%s, falling back to domain connection", addr)
+			log.Printf("All resolved upstream IPs failed for address=%s: %v, falling back to domain connection", addr, err1)
 		}
 	}
 
 	// 如果没有启用上游IP解析，或者IP解析失败，则继续使用原有的解析逻辑
 	if !upstreamResolveIPs || len(proxyoptions) == 0 {
 		if IsIP(hostname) {
-			dialer := &net.Dialer{}
+			dialer := options.NewDialerContext(ctx, network, addr)
 			if ctx != nil {
 				return dialer.DialContext(ctx, network, addr)
 			}
 			return dialer.Dial(network, addr)
 		}
 
+		// HTTPS 记录提供了地址提示或替代端口时，直接按端点拨号，跳过 A/AAAA 查询
+		if port == "443" {
+			if typedCache, ok := dnsCache.(*DNSCache); ok {
+				if endpoints := LookupHTTPSEndpoints(ctx, hostname, port, proxyoptions, typedCache, Proxy, tranportConfigurations...); len(endpoints) > 0 {
+					if connection := dialHTTPSEndpoints(ctx, network, hostname, port, endpoints, resolver); connection != nil {
+						return connection, nil
+					}
+				}
+			}
+		}
+
 		var ips []net.IP
 		if resolver != nil {
 			ips, err = resolver.LookupIP(ctx, network, hostname)
@@ -442,83 +512,49 @@ func proxy_net_DialWithResolver(ctx context.Context, network string, addr string
 
 		if len(ips) > 0 {
 			Shuffle(ips)
-			var errorsaray = make([]error, 0)
-			for _, ip := range ips {
-				serverIP := ip.String()
-				newAddr := net.JoinHostPort(serverIP, port)
-				dialer := &net.Dialer{}
-				var connection net.Conn
-				if ctx != nil {
-					connection, err = dialer.DialContext(ctx, network, newAddr)
-				} else {
-					connection, err = dialer.Dial(network, newAddr)
-				}
-
-				if err != nil {
-					errorsaray = append(errorsaray, err)
-					continue
-				} else {
-					log.Printf("Successfully connected to %s via IP %s", addr, serverIP)
-					return connection, nil
-				}
+			connection, err := options.DialHappyEyeballs(ctx, network, hostname, port, ips)
+			if err != nil {
+				return nil, err
 			}
-			return nil, ErrorArray(errorsaray)
+			log.Printf("Successfully connected to %s via IP %s", addr, connection.RemoteAddr())
+			return connection, nil
 		}
 
 		// 如果提供了代理选项，尝试使用DOH解析
-		if len(proxyoptions) > 0 {
-			Shuffle(proxyoptions)
+		if selected := options.SelectDNSOptions(proxyoptions, hostname); len(selected) > 0 {
+			Shuffle(selected)
 			var allErrors []error
-			for _, opt := range proxyoptions {
+			for _, opt := range selected {
 				var ips []net.IP
 				var errors []error
 
-				if opt.Dohalpn == "h3" {
-					if opt.Dohip == "" {
-						ips, errors = doh.ResolveDomainToIPsWithDoh3(hostname, opt.Dohurl)
-					} else {
-						ips, errors = doh.ResolveDomainToIPsWithDoh3(hostname, opt.Dohurl, opt.Dohip)
-					}
-				} else {
-					if opt.Dohip == "" {
-						ips, errors = doh.ResolveDomainToIPsWithDoh(hostname, opt.Dohurl, "", Proxy, tranportConfigurations...)
-					} else {
-						ips, errors = doh.ResolveDomainToIPsWithDoh(hostname, opt.Dohurl, opt.Dohip, Proxy, tranportConfigurations...)
-					}
+				// 跳过熔断中的DNS服务器
+				if err := options.CircuitBreakerAllow(options.DNSServerCircuitKey(opt)); err != nil {
+					allErrors = append(allErrors, err)
+					continue
 				}
+				opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+				ips, errors = doh.ResolveDomainToIPsWithOption(hostname, opt, Proxy, tranportConfigurations...)
+				options.ReportDNSServerResult(opt, len(ips) > 0, errors)
 
 				if len(ips) == 0 && len(errors) > 0 {
 					allErrors = append(allErrors, errors...)
 					continue
 				} else {
-					lengthip := len(ips)
 					Shuffle(ips)
-					for i := 0; i < lengthip; i++ {
-						var serverIP = ips[i].String()
-						newAddr := net.JoinHostPort(serverIP, port)
-						dialer := &net.Dialer{}
-						var connection net.Conn
-						var err1 error
-						if ctx != nil {
-							connection, err1 = dialer.DialContext(ctx, network, newAddr)
-						} else {
-							connection, err1 = dialer.Dial(network, newAddr)
-						}
-
-						if err1 != nil {
-							allErrors = append(allErrors, err1)
-							continue
-						} else {
-							log.Printf("Successfully connected to %s via DOH %s using IP %s", addr, opt.Dohurl, serverIP)
-							return connection, nil
-						}
+					connection, err1 := options.DialHappyEyeballs(ctx, network, hostname, port, ips)
+					if err1 != nil {
+						allErrors = append(allErrors, err1)
+						continue
 					}
+					log.Printf("Successfully connected to %s via DOH %s using IP %s", addr, opt.Dohurl, connection.RemoteAddr())
+					return connection, nil
 				}
 			}
 			return nil, ErrorArray(allErrors)
 		}
 	} // 如果所有方法都失败了，使用原始地址
-	dialer := &net.Dialer{}
+	dialer := options.NewDialer(network, addr)
 	if ctx != nil {
 		connection, err := dialer.DialContext(ctx, network, addr)
 		if err != nil {
@@ -545,7 +581,7 @@ func proxy_net_DialOriginal(network string, addr string, proxyoptions options.Pr
 	}
 
 	if IsIP(hostname) {
-		dialer := &net.Dialer{}
+		dialer := options.NewDialer(network, addr)
 		return dialer.Dial(network, addr)
 	}
 
@@ -559,7 +595,7 @@ func proxy_net_DialOriginal(network string, addr string, proxyoptions options.Pr
 		for i := 0; i < lengthip; i++ {
 			var serverIP = ips[i].String()
 			newAddr := net.JoinHostPort(serverIP, port)
-			dialer := &net.Dialer{}
+			dialer := options.NewDialerContext(options.WithOutboundHost(context.Background(), hostname), network, newAddr)
 			connection, err1 := dialer.Dial(network, newAddr)
 
 			if err1 != nil {
@@ -579,32 +615,14 @@ func proxy_net_DialOriginal(network string, addr string, proxyoptions options.Pr
 	}
 
 	//调用ResolveDomainToIPsWithHosts函数解析域名
-	if len(proxyoptions) > 0 {
+	if selected := options.SelectDNSOptions(proxyoptions, hostname); len(selected) > 0 {
 		var errorsaray = make([]error, 0)
-		Shuffle(proxyoptions)
-		for _, dohurlopt := range proxyoptions {
-			var dohip = dohurlopt.Dohip
-			var dohalpn = dohurlopt.Dohalpn
+		Shuffle(selected)
+		for _, dohurlopt := range selected {
 			var ips []net.IP
 			var errors []error
-			hostname, port, err := net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
-			}
 
-			if dohalpn == "h3" {
-				if dohip == "" {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(hostname, dohurlopt.Dohurl)
-				} else {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(hostname, dohurlopt.Dohurl, dohip)
-				}
-			} else {
-				if dohip == "" {
-					ips, errors = doh.ResolveDomainToIPsWithDoh(hostname, dohurlopt.Dohurl, "", Proxy, tranportConfigurations...)
-				} else {
-					ips, errors = doh.ResolveDomainToIPsWithDoh(hostname, dohurlopt.Dohurl, dohip, Proxy, tranportConfigurations...)
-				}
-			}
+			ips, errors = doh.ResolveDomainToIPsWithOption(hostname, dohurlopt, Proxy, tranportConfigurations...)
 
 			if len(ips) == 0 && len(errors) > 0 {
 				errorsaray = append(errorsaray, errors...)
@@ -615,14 +633,14 @@ func proxy_net_DialOriginal(network string, addr string, proxyoptions options.Pr
 				for i := 0; i < lengthip; i++ {
 					var serverIP = ips[i].String()
 					newAddr := net.JoinHostPort(serverIP, port)
-					dialer := &net.Dialer{}
+					dialer := options.NewDialerContext(options.WithOutboundHost(context.Background(), hostname), network, newAddr)
 					connection, err1 := dialer.Dial(network, newAddr)
 
 					if err1 != nil {
 						errorsaray = append(errorsaray, err1)
 						continue
 					} else {
-						log.Printf("success connect to address=%s by network=%s by Dohurl=%s by dohip=%s by serverIP=%s", addr, network, dohurlopt.Dohurl, dohip, serverIP)
+						log.Printf("success connect to address=%s by network=%s by dns=%s by serverIP=%s", addr, network, dohurlopt.ServerURL(), serverIP)
 						return connection, nil
 					}
 				}
@@ -630,7 +648,7 @@ func proxy_net_DialOriginal(network string, addr string, proxyoptions options.Pr
 		}
 		return nil, ErrorArray(errorsaray)
 	} else {
-		dialer := &net.Dialer{}
+		dialer := options.NewDialer(network, addr)
 		connection, err1 := dialer.Dial(network, addr)
 		if err1 != nil {
 			log.Printf("failure connect to %s by %s%s", addr, network, err1.Error())
@@ -649,9 +667,11 @@ func proxy_net_DialContextOriginal(ctx context.Context, network string, address
 	}
 
 	if IsIP(hostname) {
-		dialer := &net.Dialer{}
+		dialer := options.NewDialerContext(ctx, network, address)
 		return dialer.DialContext(ctx, network, address)
 	}
+	// 出站配置按原始主机名选择
+	ctx = options.WithOutboundHost(ctx, hostname)
 
 	var ips []net.IP
 	ips, err = hosts.ResolveDomainToIPsWithHosts(hostname)
@@ -663,7 +683,7 @@ func proxy_net_DialContextOriginal(ctx context.Context, network string, address
 		for i := 0; i < lengthip; i++ {
 			var serverIP = ips[i].String()
 			newAddr := net.JoinHostPort(serverIP, port)
-			dialer := &net.Dialer{}
+			dialer := options.NewDialerContext(ctx, network, newAddr)
 			connection, err1 := dialer.DialContext(ctx, network, newAddr)
 
 			if err1 != nil {
@@ -681,32 +701,15 @@ func proxy_net_DialContextOriginal(ctx context.Context, network string, address
 		log.Println(err)
 	}
 
-	if len(proxyoptions) > 0 {
+	if selected := options.SelectDNSOptions(proxyoptions, hostname); len(selected) > 0 {
 		var errorsaray = make([]error, 0)
-		Shuffle(proxyoptions)
-		for _, dohurlopt := range proxyoptions {
-			var dohip = dohurlopt.Dohip
-			var dohalpn = dohurlopt.Dohalpn
+		Shuffle(selected)
+		for _, dohurlopt := range selected {
 			var ips []net.IP
 			var errors []error
-			hostname, port, err := net.SplitHostPort(address)
-			if err != nil {
-				return nil, err
-			}
 
-			if dohalpn == "h3" {
-				if dohip == "" {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(hostname, dohurlopt.Dohurl)
-				} else {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(hostname, dohurlopt.Dohurl, dohip)
-				}
-			} else {
-				if dohip == "" {
-					ips, errors = doh.ResolveDomainToIPsWithDoh(hostname, dohurlopt.Dohurl, "", Proxy, tranportConfigurations...)
-				} else {
-					ips, errors = doh.ResolveDomainToIPsWithDoh(hostname, dohurlopt.Dohurl, dohip, Proxy, tranportConfigurations...)
-				}
-			}
+			dohurlopt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+			ips, errors = doh.ResolveDomainToIPsWithOption(hostname, dohurlopt, Proxy, tranportConfigurations...)
 
 			if len(ips) == 0 && len(errors) > 0 {
 				errorsaray = append(errorsaray, errors...)
@@ -717,14 +720,14 @@ func proxy_net_DialContextOriginal(ctx context.Context, network string, address
 				for i := 0; i < lengthip; i++ {
 					var serverIP = ips[i].String()
 					newAddr := net.JoinHostPort(serverIP, port)
-					dialer := &net.Dialer{}
+					dialer := options.NewDialerContext(ctx, network, newAddr)
 					connection, err1 := dialer.DialContext(ctx, network, newAddr)
 
 					if err1 != nil {
 						errorsaray = append(errorsaray, err1)
 						continue
 					} else {
-						log.Printf("success connect to address=%s by network=%s by Dohurl=%s by dohip=%s by serverIP=%s", address, network, dohurlopt.Dohurl, dohip, serverIP)
+						log.Printf("success connect to address=%s by network=%s by dns=%s by serverIP=%s", address, network, dohurlopt.ServerURL(), serverIP)
 						return connection, nil
 					}
 				}
@@ -732,7 +735,7 @@ func proxy_net_DialContextOriginal(ctx context.Context, network string, address
 		}
 		return nil, ErrorArray(errorsaray)
 	} else {
-		dialer := &net.Dialer{}
+		dialer := options.NewDialer(network, address)
 		connection, err1 := dialer.DialContext(ctx, network, address)
 		if err1 != nil {
 			log.Printf("failure connect to %s by %s%s", address, network, err1.Error())
@@ -1,12 +1,12 @@
 package dnscache
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -28,18 +28,37 @@ const (
 // DNSCache DNS缓存管理器
 type DNSCache struct {
 	cache      *cache.Cache
-	filePath   string
-	aofPath    string
+	store      Store // 持久化后端
 	mu         sync.RWMutex
 	saveTicker *time.Ticker
 	aofTicker  *time.Ticker
 	done       chan bool
 	wg         sync.WaitGroup
-	aofFile    *os.File
-	aofEncoder *json.Encoder
+	fsync      AppendFsync // 刷盘策略
 	closed     bool
+	fakeIP     *fakeIPPool // fake-IP 分配器，为 nil 表示未启用
+	lru        *lruIndex   // 访问顺序和容量索引
+	pins       *pinTable   // 固定解析，优先于缓存记录
+	hits       *hitStats   // 按域名的访问统计，用于启动预热
+	// appends 按调用顺序交给持久化后端的写入，由 storeWriter 逐个执行，
+	// 同一记录先后的 SET、EVICT、DELETE 不会在后端中颠倒
+	appends       chan storeOp
+	appendsMu     sync.RWMutex
+	appendsClosed bool
+	writerDone    chan struct{}
 }
 
+// storeOp 一次待持久化的写入
+type storeOp struct {
+	operation string
+	key       string
+	value     interface{}
+	ttl       time.Duration
+}
+
+// appendQueueSize 待持久化写入队列的长度，队列满时写入方等待后端
+const appendQueueSize = 1024
+
 // Record DNS记录结构 (用于可能的统计和调试)
 type Record struct {
 	Type   string        `json:"type"`
@@ -57,7 +76,7 @@ type cacheItem struct {
 // AOFEntry AOF日志条目
 type AOFEntry struct {
 	Timestamp time.Time   `json:"timestamp"`
-	Operation string      `json:"operation"` // "SET" or "DELETE"
+	Operation string      `json:"operation"` // "SET"、"DELETE" 或 "EVICT"
 	Key       string      `json:"key"`
 	Value     interface{} `json:"value,omitempty"`
 	TTL       int64       `json:"ttl,omitempty"` // TTL秒数
@@ -132,6 +151,16 @@ type Config struct {
 	SaveInterval    time.Duration `json:"save_interval"`
 	AOFInterval     time.Duration `json:"aof_interval"`
 	Enabled         bool          `json:"enabled"`
+	MaxEntries      int           `json:"max_entries"`  // 最大条目数，0 表示不限制
+	MaxBytes        int64         `json:"max_bytes"`    // 近似最大内存占用（字节），0 表示不限制
+	AppendFsync     AppendFsync   `json:"appendfsync"`  // AOF 刷盘策略，默认 everysec
+	Backend         Backend       `json:"backend"`      // 持久化后端：file（默认）或 bbolt
+	DBPath          string        `json:"db_path"`      // bbolt 数据库文件路径
+	RedisURL        string        `json:"redis_url"`    // redis 后端地址，如 redis://:password@127.0.0.1:6379/0
+	RedisPrefix     string        `json:"redis_prefix"` // redis 键前缀，同时用作失效通知频道名
+	PinsPath        string        `json:"pins_path"`    // 固定解析保存文件，为空则不持久化
+	HitsPath        string        `json:"hits_path"`    // 访问统计保存文件，为空则不持久化
+	Store           Store         `json:"-"`            // 自定义持久化后端，设置后忽略 Backend
 }
 
 // DefaultConfig 返回默认配置
@@ -144,13 +173,23 @@ func DefaultConfig() *Config {
 		SaveInterval:    DefaultSaveInterval,
 		AOFInterval:     DefaultAOFInterval,
 		Enabled:         true,
+		AppendFsync:     AppendFsyncEverySec,
+		Backend:         BackendFile,
+		DBPath:          "./dns_cache.db",
+		RedisURL:        "redis://127.0.0.1:6379/0",
+		RedisPrefix:     DefaultRedisPrefix,
+		PinsPath:        "./dns_cache_pins.json",
+		HitsPath:        "./dns_cache_hits.json",
 	}
 }
 
-// New 创建新的DNS缓存实例
+// New 创建新的DNS缓存实例，AOF 文件与缓存文件放在同一目录
 func New(filePath string) (*DNSCache, error) {
 	config := DefaultConfig()
 	config.FilePath = filePath
+	if filePath != "" {
+		config.AOFPath = filepath.Join(filepath.Dir(filePath), filepath.Base(config.AOFPath))
+	}
 	return NewWithConfig(config)
 }
 
@@ -159,6 +198,10 @@ func NewWithConfig(config *Config) (*DNSCache, error) {
 	if !config.Enabled {
 		return &DNSCache{}, nil
 	}
+	fsync, err := ParseAppendFsync(string(config.AppendFsync))
+	if err != nil {
+		return nil, err
+	}
 
 	// 确保缓存目录存在
 	dir := filepath.Dir(config.FilePath)
@@ -166,26 +209,37 @@ func NewWithConfig(config *Config) (*DNSCache, error) {
 		return nil, fmt.Errorf("创建缓存目录失败: %w", err)
 	}
 
+	store, err := newStore(config, fsync)
+	if err != nil {
+		return nil, err
+	}
+
 	dc := &DNSCache{
 		cache:      cache.New(config.DefaultTTL, config.CleanupInterval),
-		filePath:   config.FilePath,
-		aofPath:    config.AOFPath,
+		store:      store,
 		done:       make(chan bool),
 		saveTicker: time.NewTicker(config.SaveInterval),
 		aofTicker:  time.NewTicker(config.AOFInterval),
-	}
-
-	// 初始化AOF文件
-	if err := dc.initAOF(); err != nil {
-		return nil, fmt.Errorf("初始化AOF文件失败: %w", err)
-	}
+		lru:        newLRUIndex(config.MaxEntries, config.MaxBytes),
+		pins:       newPinTable(config.PinsPath),
+		hits:       newHitStats(config.HitsPath),
+		fsync:      fsync,
+		appends:    make(chan storeOp, appendQueueSize),
+		writerDone: make(chan struct{}),
+	}
+	go dc.storeWriter()
+	// 删除和过期清理时同步移除索引
+	dc.cache.OnEvicted(func(key string, _ interface{}) {
+		dc.lru.remove(key)
+	})
 
 	// 加载已有缓存
 	if err := dc.Load(); err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Printf("警告: 加载缓存失败: %v，将创建新的缓存\n", err)
-		}
+		dc.closeAppends()
+		store.Close()
+		return nil, err
 	}
+	dc.rebuildLRU()
 
 	// 启动定期保存任务
 	dc.wg.Add(2)
@@ -234,7 +288,7 @@ func (dc *DNSCache) GetIPs(dnsType, domain string) ([]net.IP, bool) {
 	}
 
 	key := dc.makeKey(dnsType, domain)
-	if value, found := dc.cache.Get(key); found {
+	if value, found := dc.lookup(key); found {
 		if ips, ok := value.([]net.IP); ok {
 			return ips, true
 		}
@@ -278,7 +332,8 @@ func (dc *DNSCache) SetIPs(dnsType, domain string, ips []net.IP, ttl time.Durati
 	dc.cache.Set(key, ips, ttl)
 
 	// 追加到AOF日志
-	go dc.appendAOF("SET", key, ips, int64(ttl.Seconds()))
+	dc.appendStore("SET", key, ips, ttl)
+	dc.evict(dc.lru.add(key, ips))
 }
 
 // SetIP 设置单个IP地址
@@ -293,7 +348,29 @@ func (dc *DNSCache) Get(dnsType, domain string) (interface{}, bool) {
 	}
 
 	key := dc.makeKey(dnsType, domain)
-	return dc.cache.Get(key)
+	return dc.lookup(key)
+}
+
+// lookup 优先返回固定解析，其次读取本地缓存，未命中且后端为共享存储时回源读取并填充本地缓存
+func (dc *DNSCache) lookup(key string) (interface{}, bool) {
+	if value, ok := dc.pinnedValue(key); ok {
+		return value, true
+	}
+	if value, found := dc.cache.Get(key); found {
+		dc.lru.touch(key)
+		return value, true
+	}
+	remote, ok := dc.store.(RemoteStore)
+	if !ok {
+		return nil, false
+	}
+	value, ttl, found := remote.Fetch(key)
+	if !found {
+		return nil, false
+	}
+	dc.cache.Set(key, value, ttl)
+	dc.evict(dc.lru.add(key, value))
+	return value, true
 }
 
 // Set 设置通用DNS记录
@@ -309,254 +386,113 @@ func (dc *DNSCache) Set(dnsType, domain string, value interface{}, ttl time.Dura
 	dc.cache.Set(key, value, ttl)
 
 	// 追加到AOF日志
-	go dc.appendAOF("SET", key, value, int64(ttl.Seconds()))
+	dc.appendStore("SET", key, value, ttl)
+	dc.evict(dc.lru.add(key, value))
 }
 
-// Delete 删除DNS记录
-func (dc *DNSCache) Delete(dnsType, domain string) {
-	if dc.cache == nil {
-		return
+// evict 删除被容量限制淘汰的记录，并记录到AOF日志保证重放结果一致
+func (dc *DNSCache) evict(keys []string) {
+	for _, key := range keys {
+		dc.cache.Delete(key)
+		dc.appendStore("EVICT", key, nil, 0)
 	}
-
-	key := dc.makeKey(dnsType, domain)
-	dc.cache.Delete(key)
-
-	// 追加到AOF日志
-	go dc.appendAOF("DELETE", key, nil, 0)
 }
 
-// Save 保存缓存到文件（原子操作）
-func (dc *DNSCache) Save() error {
-	if dc.cache == nil {
-		return nil
+// rebuildLRU 加载缓存后按过期时间重建索引（先过期的视为较旧），并执行容量限制
+func (dc *DNSCache) rebuildLRU() {
+	type loaded struct {
+		key        string
+		value      interface{}
+		expiration int64
 	}
-
-	dc.mu.Lock()
-	defer dc.mu.Unlock()
-
 	items := dc.cache.Items()
-	now := time.Now()
-	validItems := make(map[string]cacheItem)
-
-	// 只保存未过期的项
+	loadedItems := make([]loaded, 0, len(items))
 	for k, item := range items {
-		// go-cache 的 Expiration 是 int64 类型的 Unix 时间戳（纳秒精度），或者 0 表示永不过期
-		// 某些情况下可能是纳秒时间戳而不是秒时间戳
-		if item.Expiration == 0 {
-			// 对于永不过期的项，使用零时间
-			expirationTime := time.Time{}
-			// 特殊处理IP列表，确保序列化格式一致
-			if ips, ok := item.Object.([]net.IP); ok {
-				var ipsStr []string
-				for _, ip := range ips {
-					ipsStr = append(ipsStr, ip.String())
-				}
-				validItems[k] = cacheItem{
-					Value:      ipsStr,
-					Expiration: expirationTime,
-				}
-			} else {
-				validItems[k] = cacheItem{
-					Value:      item.Object,
-					Expiration: expirationTime,
-				}
-			}
-		} else {
-			// 尝试将时间戳转换为有效的时间
-			var expirationTime time.Time
-			var unixSeconds int64
-
-			// 判断是秒时间戳还是纳秒时间戳
-			if item.Expiration > 1e18 { // 纳秒时间戳
-				unixSeconds = item.Expiration / 1e9
-			} else { // 秒时间戳
-				unixSeconds = item.Expiration
-			}
-
-			// 验证时间戳范围
-			if unixSeconds < 0 || unixSeconds > 253402300799 { // 9999-12-31 23:59:59 UTC
-				fmt.Printf("警告: 跳过无效的过期时间戳 %d for key %s (转换为秒: %d)\n", item.Expiration, k, unixSeconds)
-				continue
-			}
-
-			expirationTime = time.Unix(unixSeconds, 0)
-
-			// 验证是否未过期
-			if expirationTime.After(now) {
-				// 特殊处理IP列表，确保序列化格式一致
-				if ips, ok := item.Object.([]net.IP); ok {
-					var ipsStr []string
-					for _, ip := range ips {
-						ipsStr = append(ipsStr, ip.String())
-					}
-					validItems[k] = cacheItem{
-						Value:      ipsStr,
-						Expiration: expirationTime,
-					}
-				} else {
-					validItems[k] = cacheItem{
-						Value:      item.Object,
-						Expiration: expirationTime,
-					}
-				}
-			}
-		}
+		loadedItems = append(loadedItems, loaded{key: k, value: item.Object, expiration: item.Expiration})
 	}
+	sort.Slice(loadedItems, func(i, j int) bool {
+		return loadedItems[i].expiration < loadedItems[j].expiration
+	})
 
-	// 如果没有有效数据，删除文件
-	if len(validItems) == 0 {
-		if err := os.Remove(dc.filePath); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("删除空缓存文件失败: %w", err)
-		}
-		return nil
+	dc.lru.reset()
+	evicted := 0
+	for _, item := range loadedItems {
+		victims := dc.lru.add(item.key, item.value)
+		evicted += len(victims)
+		dc.evict(victims)
 	}
-
-	// 写入临时文件后重命名，保证原子性
-	data, err := json.MarshalIndent(validItems, "", "  ")
-	if err != nil {
-		return fmt.Errorf("序列化失败: %w", err)
+	if evicted > 0 {
+		fmt.Printf("加载缓存后超过容量限制，淘汰 %d 条记录\n", evicted)
 	}
-
-	tempFile := dc.filePath + ".tmp"
-	if err := os.WriteFile(tempFile, data, 0644); err != nil {
-		return fmt.Errorf("写入临时文件失败: %w", err)
-	}
-
-	// 尝试原子重命名
-	if err := os.Rename(tempFile, dc.filePath); err != nil {
-		// 如果重命名失败（常见于Docker bind mount），使用复制和删除的备选方案
-		fmt.Printf("重命名失败，尝试备选方案: %v\n", err)
-
-		// 先读取临时文件内容
-		tempData, readErr := os.ReadFile(tempFile)
-		if readErr != nil {
-			return fmt.Errorf("读取临时文件失败: %w", readErr)
-		}
-
-		// 直接写入目标文件
-		if writeErr := os.WriteFile(dc.filePath, tempData, 0644); writeErr != nil {
-			return fmt.Errorf("直接写入目标文件失败: %w", writeErr)
-		}
-
-		// 删除临时文件
-		if removeErr := os.Remove(tempFile); removeErr != nil {
-			// 删除失败不作为致命错误，只记录警告
-			fmt.Printf("警告: 删除临时文件失败: %v\n", removeErr)
-		}
-	}
-
-	return nil
 }
 
-// Load 从文件加载缓存
-func (dc *DNSCache) Load() error {
+// Delete 删除DNS记录
+func (dc *DNSCache) Delete(dnsType, domain string) {
 	if dc.cache == nil {
-		return nil
-	}
-
-	dc.mu.Lock()
-	defer dc.mu.Unlock()
-
-	// 1. 先加载全量缓存文件
-	if err := dc.loadSnapshot(); err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Printf("警告: 加载快照文件失败: %v\n", err)
-		}
-	}
-
-	// 2. 重放AOF日志，恢复增量数据
-	if err := dc.replayAOF(); err != nil {
-		fmt.Printf("警告: 重放AOF日志失败: %v\n", err)
+		return
 	}
 
-	return nil
+	dc.deleteKey(dc.makeKey(dnsType, domain))
 }
 
-// loadSnapshot 加载快照文件
-func (dc *DNSCache) loadSnapshot() error {
-	data, err := os.ReadFile(dc.filePath)
-	if err != nil {
-		return err
-	}
-
-	// 检查文件是否为空
-	if len(data) == 0 {
-		fmt.Printf("缓存快照文件为空\n")
-		return nil
-	}
-
-	// 检查是否只包含空白字符
-	trimmed := strings.TrimSpace(string(data))
-	if len(trimmed) == 0 {
-		fmt.Printf("缓存快照文件只包含空白字符\n")
-		return nil
-	}
-
-	var fileItems map[string]cacheItem
-	if err := json.Unmarshal(data, &fileItems); err != nil {
-		return fmt.Errorf("快照文件反序列化失败: %w", err)
-	}
+// deleteKey 删除一条记录并追加到AOF日志
+func (dc *DNSCache) deleteKey(key string) {
+	dc.cache.Delete(key)
+	dc.appendStore("DELETE", key, nil, 0)
+}
 
-	// 检查是否解析出了有效的数据结构
-	if fileItems == nil {
-		fmt.Printf("缓存快照文件格式无效\n")
-		return nil
+// appendStore 把一次写入排入持久化队列，关闭后的写入被忽略
+func (dc *DNSCache) appendStore(operation, key string, value interface{}, ttl time.Duration) {
+	dc.appendsMu.RLock()
+	defer dc.appendsMu.RUnlock()
+	if dc.appendsClosed {
+		return
 	}
-
-	now := time.Now()
-	loadedCount := 0
-
-	for k, item := range fileItems {
-		// 如果过期时间是零值，表示永不过期，使用默认TTL加载
-		if item.Expiration.IsZero() {
-			// 尝试将字符串IP转换为net.IP
-			if ipsStr := convertToStringSlice(item.Value); len(ipsStr) > 0 {
-				var ips []net.IP
-				for _, ipStr := range ipsStr {
-					if ip := net.ParseIP(ipStr); ip != nil {
-						ips = append(ips, ip)
-					}
-				}
-				if len(ips) > 0 {
-					dc.cache.Set(k, ips, DefaultTTL) // 使用默认TTL
-					loadedCount++
+	dc.appends <- storeOp{operation: operation, key: key, value: value, ttl: ttl}
+}
+
+// storeWriter 按顺序把队列中的写入交给持久化后端，队列关闭并排空后退出。
+// 后端支持批量写入时，把队列中积压的写入合并为一次提交
+func (dc *DNSCache) storeWriter() {
+	defer close(dc.writerDone)
+	batch := make([]storeOp, 0, appendQueueSize)
+	for op := range dc.appends {
+		batch = append(batch[:0], op)
+	drain:
+		for len(batch) < appendQueueSize {
+			select {
+			case op, ok := <-dc.appends:
+				if !ok {
+					break drain
 				}
-			} else {
-				dc.cache.Set(k, item.Value, DefaultTTL) // 使用默认TTL
-				loadedCount++
+				batch = append(batch, op)
+			default:
+				break drain
 			}
-			continue
 		}
-
-		// 验证过期时间是否有效
-		if item.Expiration.Year() < 0 || item.Expiration.Year() > 9999 {
-			fmt.Printf("警告: 跳过无效的过期时间 for key %s: %v\n", k, item.Expiration)
+		if bs, ok := dc.store.(batchStore); ok {
+			if err := bs.appendBatch(batch); err != nil {
+				fmt.Printf("持久化缓存写入失败: %v\n", err)
+			}
 			continue
 		}
-
-		if item.Expiration.After(now) {
-			ttl := time.Until(item.Expiration)
-			// 尝试将字符串IP转换为net.IP
-			if ipsStr := convertToStringSlice(item.Value); len(ipsStr) > 0 {
-				var ips []net.IP
-				for _, ipStr := range ipsStr {
-					if ip := net.ParseIP(ipStr); ip != nil {
-						ips = append(ips, ip)
-					}
-				}
-				if len(ips) > 0 {
-					dc.cache.Set(k, ips, ttl)
-					loadedCount++
-				}
-			} else {
-				dc.cache.Set(k, item.Value, ttl)
-				loadedCount++
+		for _, op := range batch {
+			if err := dc.store.Append(op.operation, op.key, op.value, op.ttl); err != nil {
+				fmt.Printf("持久化缓存写入失败: %v\n", err)
 			}
 		}
 	}
+}
 
-	fmt.Printf("快照文件加载完成，共加载 %d 条记录\n", loadedCount)
-	return nil
+// closeAppends 停止接收新的写入，等待队列中已有的写入完成
+func (dc *DNSCache) closeAppends() {
+	dc.appendsMu.Lock()
+	if !dc.appendsClosed {
+		dc.appendsClosed = true
+		close(dc.appends)
+	}
+	dc.appendsMu.Unlock()
+	<-dc.writerDone
 }
 
 // periodicSave 定期保存任务
@@ -586,17 +522,15 @@ func (dc *DNSCache) Close() {
 	dc.aofTicker.Stop()
 	close(dc.done)
 	dc.wg.Wait()
-
-	// 关闭AOF文件
-	if dc.aofFile != nil {
-		dc.aofFile.Close()
-		dc.aofFile = nil
-	}
+	dc.closeAppends()
 
 	// 关闭时保存
 	if err := dc.Save(); err != nil {
 		fmt.Printf("关闭时保存缓存失败: %v\n", err)
 	}
+	if err := dc.store.Close(); err != nil {
+		fmt.Printf("关闭缓存存储失败: %v\n", err)
+	}
 }
 
 // Flush 清空所有缓存
@@ -606,6 +540,7 @@ func (dc *DNSCache) Flush() {
 	}
 
 	dc.cache.Flush()
+	dc.lru.reset()
 }
 
 // ItemCount 返回缓存项数量
@@ -628,238 +563,72 @@ func (dc *DNSCache) Stats() map[string]interface{} {
 		}
 	}
 
-	return map[string]interface{}{
-		"enabled":    true,
-		"item_count": dc.cache.ItemCount(),
-		"file_path":  dc.filePath,
-		"aof_path":   dc.aofPath,
+	entries, bytes := dc.lru.usage()
+	stats := map[string]interface{}{
+		"enabled":       true,
+		"item_count":    dc.cache.ItemCount(),
+		"file_path":     "",
+		"aof_path":      "",
+		"max_entries":   dc.lru.maxEntries,
+		"max_bytes":     dc.lru.maxBytes,
+		"lru_entries":   entries,
+		"approx_bytes":  bytes,
+		"evictions":     dc.lru.evictions.Load(),
+		"evicted_bytes": dc.lru.evictedBytes.Load(),
+		"pinned":        dc.pins.len(),
 	}
-}
-
-// AOF相关方法
-
-// initAOF 初始化AOF文件
-func (dc *DNSCache) initAOF() error {
-	file, err := os.OpenFile(dc.aofPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	for k, v := range dc.store.Stats() {
+		stats[k] = v
 	}
-
-	dc.aofFile = file
-	dc.aofEncoder = json.NewEncoder(file)
-
-	return nil
+	return stats
 }
 
-// appendAOF 追加AOF日志条目
-func (dc *DNSCache) appendAOF(operation, key string, value interface{}, ttl int64) error {
-	if dc.aofFile == nil || dc.aofEncoder == nil {
-		return nil // AOF未初始化，忽略
+// Save 保存缓存（file 后端写入全量快照，其它后端由各自实现决定）
+func (dc *DNSCache) Save() error {
+	if dc.cache == nil {
+		return nil
 	}
 
-	entry := AOFEntry{
-		Timestamp: time.Now(),
-		Operation: operation,
-		Key:       key,
-		Value:     value,
-		TTL:       ttl,
+	if err := dc.hits.save(); err != nil {
+		fmt.Printf("保存访问统计失败: %v\n", err)
 	}
-
-	dc.mu.Lock()
-	defer dc.mu.Unlock()
-
-	return dc.aofEncoder.Encode(entry)
+	return dc.store.Checkpoint()
 }
 
-// replayAOF 重放AOF日志
-func (dc *DNSCache) replayAOF() error {
-	file, err := os.Open(dc.aofPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // AOF文件不存在，正常情况
-		}
-		return err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	replayedCount := 0
-
-	for scanner.Scan() {
-		var entry AOFEntry
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-			fmt.Printf("警告: AOF日志条目解析失败: %v\n", err)
-			continue
-		}
-
-		// 根据操作类型重放
-		switch entry.Operation {
-		case "SET":
-			ttl := time.Duration(entry.TTL) * time.Second
-			if ttl <= 0 {
-				ttl = DefaultTTL
-			}
-
-			// 特殊处理IP类型数据
-			if ipsStr := convertToStringSlice(entry.Value); len(ipsStr) > 0 {
-				var ips []net.IP
-				for _, ipStr := range ipsStr {
-					if ip := net.ParseIP(ipStr); ip != nil {
-						ips = append(ips, ip)
-					}
-				}
-				if len(ips) > 0 {
-					dc.cache.Set(entry.Key, ips, ttl)
-				}
-			} else {
-				dc.cache.Set(entry.Key, entry.Value, ttl)
-			}
-			replayedCount++
-
-		case "DELETE":
-			dc.cache.Delete(entry.Key)
-			replayedCount++
-
-		default:
-			fmt.Printf("警告: 未知的AOF操作类型: %s\n", entry.Operation)
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("读取AOF文件错误: %w", err)
+// Load 从持久化后端加载缓存
+func (dc *DNSCache) Load() error {
+	if dc.cache == nil {
+		return nil
 	}
 
-	fmt.Printf("AOF重放完成，共处理 %d 条记录\n", replayedCount)
-	return nil
+	return dc.store.Load(dc.cache)
 }
 
 // periodicAOFCheckpoint 定期AOF检查点
 func (dc *DNSCache) periodicAOFCheckpoint() {
 	defer dc.wg.Done()
 
+	// everysec 策略每秒 fsync 一次
+	var syncC <-chan time.Time
+	if dc.fsync == AppendFsyncEverySec {
+		syncTicker := time.NewTicker(time.Second)
+		defer syncTicker.Stop()
+		syncC = syncTicker.C
+	}
+
 	for {
 		select {
 		case <-dc.aofTicker.C:
 			// 检查是否需要压缩AOF
-			if err := dc.checkAOFSize(); err != nil {
+			if err := dc.store.Maintain(); err != nil {
 				fmt.Printf("AOF检查点错误: %v\n", err)
 			}
+		case <-syncC:
+			if err := dc.store.Sync(); err != nil {
+				fmt.Printf("AOF同步失败: %v\n", err)
+			}
 		case <-dc.done:
 			return
 		}
 	}
 }
-
-// checkAOFSize 检查AOF文件大小并在需要时压缩
-func (dc *DNSCache) checkAOFSize() error {
-	if dc.aofPath == "" {
-		return nil
-	}
-
-	info, err := os.Stat(dc.aofPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
-	}
-
-	// 如果AOF文件超过10MB，进行压缩
-	if info.Size() > 10*1024*1024 {
-		fmt.Printf("AOF文件过大(%d bytes)，开始压缩...\n", info.Size())
-		return dc.compactAOF()
-	}
-
-	return nil
-}
-
-// compactAOF 压缩AOF文件
-func (dc *DNSCache) compactAOF() error {
-	tempPath := dc.aofPath + ".compact"
-
-	// 创建临时文件
-	tempFile, err := os.Create(tempPath)
-	if err != nil {
-		return err
-	}
-	defer tempFile.Close()
-
-	encoder := json.NewEncoder(tempFile)
-
-	// 获取当前所有有效缓存项
-	items := dc.cache.Items()
-	now := time.Now()
-
-	for key, item := range items {
-		var expirationTime time.Time
-		var hasExpiration bool
-
-		// 只包含未过期的项
-		if item.Expiration > 0 {
-			hasExpiration = true
-			if item.Expiration > 1e18 { // 纳秒时间戳
-				expirationTime = time.Unix(item.Expiration/1e9, 0)
-			} else { // 秒时间戳
-				expirationTime = time.Unix(item.Expiration, 0)
-			}
-
-			if expirationTime.Before(now) {
-				continue // 已过期，跳过
-			}
-		}
-
-		// 计算TTL
-		var ttl int64
-		if item.Expiration == 0 {
-			ttl = int64(DefaultTTL.Seconds())
-		} else if hasExpiration {
-			ttl = int64(time.Until(expirationTime).Seconds())
-			if ttl <= 0 {
-				continue // 已过期，跳过
-			}
-		} else {
-			continue // 无效的过期时间，跳过
-		}
-
-		// 写入压缩后的AOF条目
-		entry := AOFEntry{
-			Timestamp: time.Now(),
-			Operation: "SET",
-			Key:       key,
-			Value:     item.Object,
-			TTL:       ttl,
-		}
-
-		if err := encoder.Encode(entry); err != nil {
-			return err
-		}
-	}
-
-	// 原子替换文件
-	if err := tempFile.Close(); err != nil {
-		return err
-	}
-
-	// 关闭当前AOF文件
-	if dc.aofFile != nil {
-		dc.aofFile.Close()
-	}
-
-	// 重命名
-	if err := os.Rename(tempPath, dc.aofPath); err != nil {
-		// 如果重命名失败，尝试复制方案
-		data, err := os.ReadFile(tempPath)
-		if err != nil {
-			return err
-		}
-
-		if err := os.WriteFile(dc.aofPath, data, 0644); err != nil {
-			return err
-		}
-
-		os.Remove(tempPath)
-	}
-
-	// 重新打开AOF文件
-	return dc.initAOF()
-}
@@ -0,0 +1,155 @@
+package dnscache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFakeIPRange 默认的 fake-IP 地址段（RFC 2544 基准测试保留地址）
+	DefaultFakeIPRange = "198.18.0.0/15"
+	// DefaultFakeIPTTL fake-IP 映射默认保留时间
+	DefaultFakeIPTTL = 24 * time.Hour
+
+	// fake-IP 映射在缓存中的类型：FAKEIP:域名 -> IP，FAKEIP-REVERSE:IP -> 域名
+	fakeIPType        = "fakeip"
+	fakeIPReverseType = "fakeip-reverse"
+)
+
+// fakeIPPool fake-IP 分配器，按顺序在地址段中分配 IPv4 地址
+// 映射关系保存在 DNSCache 中，随缓存快照和 AOF 一起持久化
+type fakeIPPool struct {
+	network *net.IPNet
+	base    uint32
+	size    uint32
+	cursor  uint32
+	ttl     time.Duration
+	mu      sync.Mutex
+}
+
+// EnableFakeIP 在该缓存上启用 fake-IP 模式
+// cidr 为空时使用 DefaultFakeIPRange，ttl 不大于0时使用 DefaultFakeIPTTL
+func (dc *DNSCache) EnableFakeIP(cidr string, ttl time.Duration) error {
+	if dc.cache == nil {
+		return fmt.Errorf("fake-ip 模式需要启用DNS缓存")
+	}
+	if cidr == "" {
+		cidr = DefaultFakeIPRange
+	}
+	if ttl <= 0 {
+		ttl = DefaultFakeIPTTL
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("无效的 fake-ip 地址段 %s: %w", cidr, err)
+	}
+	ip4 := network.IP.To4()
+	ones, bits := network.Mask.Size()
+	if ip4 == nil || bits != 32 || bits-ones < 2 {
+		return fmt.Errorf("fake-ip 地址段必须是至少包含4个地址的IPv4网段: %s", cidr)
+	}
+	dc.fakeIP = &fakeIPPool{
+		network: network,
+		base:    binary.BigEndian.Uint32(ip4),
+		size:    uint32(1) << uint(bits-ones),
+		ttl:     ttl,
+	}
+	log.Printf("fake-ip 模式已启用，地址段: %s，映射保留时间: %v", cidr, ttl)
+	return nil
+}
+
+// FakeIPEnabled 返回是否启用了 fake-IP 模式
+func (dc *DNSCache) FakeIPEnabled() bool {
+	return dc != nil && dc.fakeIP != nil
+}
+
+// IsFakeIP 判断 IP 是否属于 fake-IP 地址段
+func (dc *DNSCache) IsFakeIP(ip net.IP) bool {
+	return dc.FakeIPEnabled() && ip != nil && dc.fakeIP.network.Contains(ip)
+}
+
+// lookupString 读取字符串类型的缓存值
+func (dc *DNSCache) lookupString(dnsType, key string) (string, bool) {
+	value, found := dc.Get(dnsType, key)
+	if !found {
+		return "", false
+	}
+	s, ok := value.(string)
+	return s, ok
+}
+
+// AllocateFakeIP 为域名分配（或返回已有的）fake-IP
+func (dc *DNSCache) AllocateFakeIP(domain string) (net.IP, error) {
+	if !dc.FakeIPEnabled() {
+		return nil, fmt.Errorf("fake-ip 模式未启用")
+	}
+	domain = normalizeDomain(domain)
+	pool := dc.fakeIP
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	// 已有映射且反向映射仍指向该域名时直接复用，并刷新保留时间
+	if ipStr, ok := dc.lookupString(fakeIPType, domain); ok {
+		if owner, ok := dc.lookupString(fakeIPReverseType, ipStr); ok && owner == domain {
+			dc.Set(fakeIPType, domain, ipStr, pool.ttl)
+			dc.Set(fakeIPReverseType, ipStr, domain, pool.ttl)
+			return net.ParseIP(ipStr), nil
+		}
+	}
+
+	// 从游标开始寻找空闲地址，跳过网络地址、.1（常用作网关）和广播地址；
+	// 地址段用尽时覆盖游标处最旧的映射
+	var candidate net.IP
+	for i := uint32(0); i < pool.size; i++ {
+		offset := pool.cursor
+		pool.cursor = (pool.cursor + 1) % pool.size
+		if offset < 2 || offset == pool.size-1 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, pool.base+offset)
+		if candidate == nil {
+			candidate = ip
+		}
+		if _, used := dc.lookupString(fakeIPReverseType, ip.String()); !used {
+			candidate = ip
+			break
+		}
+	}
+
+	ipStr := candidate.String()
+	dc.Set(fakeIPType, domain, ipStr, pool.ttl)
+	dc.Set(fakeIPReverseType, ipStr, domain, pool.ttl)
+	log.Printf("fake-ip 分配: %s -> %s", domain, ipStr)
+	return candidate, nil
+}
+
+// LookupFakeIP 根据 fake-IP 反查域名
+func (dc *DNSCache) LookupFakeIP(ip net.IP) (string, bool) {
+	if !dc.IsFakeIP(ip) {
+		return "", false
+	}
+	return dc.lookupString(fakeIPReverseType, ip.String())
+}
+
+// RestoreFakeIPAddress 将 host:port 形式地址中的 fake-IP 还原为域名
+// 不是 fake-IP 或找不到映射时原样返回
+func (dc *DNSCache) RestoreFakeIPAddress(addr string) string {
+	if !dc.FakeIPEnabled() {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	domain, ok := dc.LookupFakeIP(net.ParseIP(host))
+	if !ok {
+		return addr
+	}
+	log.Printf("fake-ip 还原: %s -> %s", host, domain)
+	return net.JoinHostPort(domain, port)
+}
@@ -0,0 +1,53 @@
+package dnscache
+
+import (
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// fakeIPAnswerTTL 返回给客户端的 DNS 记录 TTL（秒）
+// 保持很短，使客户端频繁重新查询从而刷新映射，而映射本身在缓存中保留更久
+const fakeIPAnswerTTL = 1
+
+// ServeDNS 实现 dns.Handler，对 A 查询返回 fake-IP，其余类型返回空应答
+// 这样客户端本地不会产生真实的DNS查询，真实域名由代理或上游代理解析
+func (dc *DNSCache) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	resp.Authoritative = true
+	for _, q := range r.Question {
+		if q.Qclass != dns.ClassINET || q.Qtype != dns.TypeA {
+			// AAAA 等类型返回 NOERROR 空应答，促使客户端使用 IPv4 fake-IP
+			continue
+		}
+		domain := strings.TrimSuffix(q.Name, ".")
+		ip, err := dc.AllocateFakeIP(domain)
+		if err != nil {
+			log.Println("fake-ip 分配失败", domain, err)
+			resp.Rcode = dns.RcodeServerFailure
+			break
+		}
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: fakeIPAnswerTTL},
+			A:   ip,
+		})
+	}
+	if err := w.WriteMsg(resp); err != nil {
+		log.Println("fake-ip DNS 应答失败", err)
+	}
+}
+
+// ServeFakeIPDNS 在指定地址上同时以 UDP 和 TCP 提供 fake-IP DNS 服务（阻塞）
+func (dc *DNSCache) ServeFakeIPDNS(addr string) error {
+	tcpServer := &dns.Server{Addr: addr, Net: "tcp", Handler: dc}
+	go func() {
+		if err := tcpServer.ListenAndServe(); err != nil {
+			log.Printf("fake-ip DNS(TCP) 服务启动失败: %v", err)
+		}
+	}()
+	udpServer := &dns.Server{Addr: addr, Net: "udp", Handler: dc}
+	log.Printf("fake-ip DNS 服务已启动，监听地址: %s", addr)
+	return udpServer.ListenAndServe()
+}
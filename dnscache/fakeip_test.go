@@ -0,0 +1,122 @@
+package dnscache
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func newFakeIPTestCache(t *testing.T, dir string) *DNSCache {
+	t.Helper()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	return cache
+}
+
+func TestFakeIPAllocateAndRestore(t *testing.T) {
+	cache := newFakeIPTestCache(t, t.TempDir())
+	defer cache.Close()
+
+	if err := cache.EnableFakeIP("", 0); err != nil {
+		t.Fatalf("EnableFakeIP failed: %v", err)
+	}
+
+	ip1, err := cache.AllocateFakeIP("Example.COM.")
+	if err != nil {
+		t.Fatalf("AllocateFakeIP failed: %v", err)
+	}
+	if !cache.IsFakeIP(ip1) {
+		t.Errorf("Expected %s to be in fake-ip range", ip1)
+	}
+
+	t.Run("同一域名复用地址", func(t *testing.T) {
+		ip, _ := cache.AllocateFakeIP("example.com")
+		if !ip.Equal(ip1) {
+			t.Errorf("Expected %s, got %s", ip1, ip)
+		}
+	})
+
+	t.Run("不同域名分配不同地址", func(t *testing.T) {
+		ip, _ := cache.AllocateFakeIP("example.org")
+		if ip.Equal(ip1) {
+			t.Errorf("Expected different fake ip for example.org, got %s", ip)
+		}
+	})
+
+	t.Run("反查域名", func(t *testing.T) {
+		domain, ok := cache.LookupFakeIP(ip1)
+		if !ok || domain != "example.com" {
+			t.Errorf("Expected example.com, got %q (found=%v)", domain, ok)
+		}
+	})
+
+	t.Run("还原地址", func(t *testing.T) {
+		addr := cache.RestoreFakeIPAddress(net.JoinHostPort(ip1.String(), "443"))
+		if addr != "example.com:443" {
+			t.Errorf("Expected example.com:443, got %s", addr)
+		}
+		if got := cache.RestoreFakeIPAddress("1.1.1.1:443"); got != "1.1.1.1:443" {
+			t.Errorf("Expected non fake ip to be unchanged, got %s", got)
+		}
+	})
+}
+
+func TestFakeIPPersistence(t *testing.T) {
+	dir := t.TempDir()
+	cache := newFakeIPTestCache(t, dir)
+	if err := cache.EnableFakeIP("198.18.0.0/24", 0); err != nil {
+		t.Fatalf("EnableFakeIP failed: %v", err)
+	}
+	ip, _ := cache.AllocateFakeIP("internal.example")
+	cache.Close()
+
+	reloaded := newFakeIPTestCache(t, dir)
+	defer reloaded.Close()
+	if err := reloaded.EnableFakeIP("198.18.0.0/24", 0); err != nil {
+		t.Fatalf("EnableFakeIP failed: %v", err)
+	}
+	domain, ok := reloaded.LookupFakeIP(ip)
+	if !ok || domain != "internal.example" {
+		t.Errorf("Expected mapping to survive reload, got %q (found=%v)", domain, ok)
+	}
+	again, _ := reloaded.AllocateFakeIP("internal.example")
+	if !again.Equal(ip) {
+		t.Errorf("Expected %s after reload, got %s", ip, again)
+	}
+}
+
+func TestFakeIPDisabled(t *testing.T) {
+	var cache *DNSCache
+	if cache.FakeIPEnabled() {
+		t.Errorf("Expected nil cache to report fake-ip disabled")
+	}
+	if got := cache.RestoreFakeIPAddress("198.18.0.2:80"); got != "198.18.0.2:80" {
+		t.Errorf("Expected address unchanged, got %s", got)
+	}
+}
+
+func TestDeleteMatchingKeepsFakeIP(t *testing.T) {
+	cache := newFakeIPTestCache(t, t.TempDir())
+	defer cache.Close()
+	if err := cache.EnableFakeIP("", 0); err != nil {
+		t.Fatalf("EnableFakeIP failed: %v", err)
+	}
+	ip, _ := cache.AllocateFakeIP("example.com")
+	cache.Set("lookupip", "tcp:example.com", []net.IP{net.ParseIP("192.0.2.1")}, 0)
+
+	// POST /cache/flush 和 DELETE ?pattern=* 都会删除所有匹配的记录
+	if deleted := cache.DeleteMatching("*"); deleted != 1 {
+		t.Errorf("Expected only the lookupip entry to be deleted, got %d", deleted)
+	}
+	if domain, ok := cache.LookupFakeIP(ip); !ok || domain != "example.com" {
+		t.Errorf("Expected fake-ip mapping to survive, got %q (found=%v)", domain, ok)
+	}
+	if again, _ := cache.AllocateFakeIP("example.com"); !again.Equal(ip) {
+		t.Errorf("Expected %s to stay allocated to example.com, got %s", ip, again)
+	}
+}
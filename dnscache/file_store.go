@@ -0,0 +1,490 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// snapshotVersion 当前快照文件格式版本，旧版快照是不带版本的 map[string]cacheItem
+const snapshotVersion = 2
+
+// snapshotFile 快照文件结构
+type snapshotFile struct {
+	Version    int                  `json:"version"`
+	Generation uint64               `json:"generation"`
+	Items      map[string]cacheItem `json:"items"`
+}
+
+// fileStore JSON 快照 + 带校验 AOF 的持久化后端
+// 每条写入追加到 AOF，定期把内存中的全部记录写成快照并切换到新代号的空 AOF
+type fileStore struct {
+	filePath   string
+	aofPath    string
+	fsync      AppendFsync // AOF 刷盘策略
+	cache      *cache.Cache
+	mu         sync.Mutex
+	aofFile    *os.File
+	aofDirty   bool   // 有尚未 fsync 的AOF记录
+	generation uint64 // 当前快照和AOF的代号
+	closed     bool
+}
+
+// NewFileStore 创建 JSON 快照 + AOF 持久化后端
+func NewFileStore(filePath, aofPath string, fsync AppendFsync) Store {
+	return &fileStore{filePath: filePath, aofPath: aofPath, fsync: fsync}
+}
+
+// Checkpoint 将内存中的全部记录写入快照（原子操作），并切换到新代号的空AOF
+func (fs *fileStore) Checkpoint() error {
+	if fs.cache == nil {
+		return nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	items := fs.cache.Items()
+	now := time.Now()
+	validItems := make(map[string]cacheItem)
+
+	// 只保存未过期的项
+	for k, item := range items {
+		// go-cache 的 Expiration 是 int64 类型的 Unix 时间戳（纳秒精度），或者 0 表示永不过期
+		// 某些情况下可能是纳秒时间戳而不是秒时间戳
+		if item.Expiration == 0 {
+			// 对于永不过期的项，使用零时间
+			expirationTime := time.Time{}
+			// 特殊处理IP列表，确保序列化格式一致
+			if ips, ok := item.Object.([]net.IP); ok {
+				var ipsStr []string
+				for _, ip := range ips {
+					ipsStr = append(ipsStr, ip.String())
+				}
+				validItems[k] = cacheItem{
+					Value:      ipsStr,
+					Expiration: expirationTime,
+				}
+			} else {
+				validItems[k] = cacheItem{
+					Value:      item.Object,
+					Expiration: expirationTime,
+				}
+			}
+		} else {
+			// 尝试将时间戳转换为有效的时间
+			var expirationTime time.Time
+			var unixSeconds int64
+
+			// 判断是秒时间戳还是纳秒时间戳
+			if item.Expiration > 1e18 { // 纳秒时间戳
+				unixSeconds = item.Expiration / 1e9
+			} else { // 秒时间戳
+				unixSeconds = item.Expiration
+			}
+
+			// 验证时间戳范围
+			if unixSeconds < 0 || unixSeconds > 253402300799 { // 9999-12-31 23:59:59 UTC
+				fmt.Printf("警告: 跳过无效的过期时间戳 %d for key %s (转换为秒: %d)\n", item.Expiration, k, unixSeconds)
+				continue
+			}
+
+			expirationTime = time.Unix(unixSeconds, 0)
+
+			// 验证是否未过期
+			if expirationTime.After(now) {
+				// 特殊处理IP列表，确保序列化格式一致
+				if ips, ok := item.Object.([]net.IP); ok {
+					var ipsStr []string
+					for _, ip := range ips {
+						ipsStr = append(ipsStr, ip.String())
+					}
+					validItems[k] = cacheItem{
+						Value:      ipsStr,
+						Expiration: expirationTime,
+					}
+				} else {
+					validItems[k] = cacheItem{
+						Value:      item.Object,
+						Expiration: expirationTime,
+					}
+				}
+			}
+		}
+	}
+
+	// 快照带上新的代号，写入后切换到同代号的空AOF，旧代号的AOF在加载时会被跳过
+	generation := fs.generation + 1
+	data, err := json.MarshalIndent(snapshotFile{
+		Version:    snapshotVersion,
+		Generation: generation,
+		Items:      validItems,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化失败: %w", err)
+	}
+
+	// 写入临时文件、fsync 后重命名，保证崩溃时快照要么是旧版本要么是新版本
+	if err := writeFileAtomic(fs.filePath, data); err != nil {
+		return err
+	}
+	fs.generation = generation
+
+	if !fs.closed {
+		if err := fs.rewriteAOFLocked(false); err != nil {
+			return fmt.Errorf("切换AOF文件失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// Load 从快照和AOF恢复缓存，并用恢复后的数据重写AOF：
+// 去掉损坏的尾部，并把旧版格式升级为带校验的格式
+func (fs *fileStore) Load(c *cache.Cache) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.cache = c
+
+	// 1. 先加载全量缓存文件
+	if err := fs.loadSnapshot(); err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("警告: 加载快照文件失败: %v\n", err)
+		}
+	}
+
+	// 2. 重放AOF日志，恢复增量数据
+	if err := fs.replayAOF(); err != nil {
+		fmt.Printf("警告: 重放AOF日志失败: %v\n", err)
+	}
+
+	if err := fs.rewriteAOFLocked(true); err != nil {
+		return fmt.Errorf("初始化AOF文件失败: %w", err)
+	}
+	return nil
+}
+
+// loadSnapshot 加载快照文件
+func (fs *fileStore) loadSnapshot() error {
+	data, err := os.ReadFile(fs.filePath)
+	if err != nil {
+		return err
+	}
+
+	// 检查文件是否为空
+	if len(data) == 0 {
+		fmt.Printf("缓存快照文件为空\n")
+		return nil
+	}
+
+	// 检查是否只包含空白字符
+	trimmed := strings.TrimSpace(string(data))
+	if len(trimmed) == 0 {
+		fmt.Printf("缓存快照文件只包含空白字符\n")
+		return nil
+	}
+
+	var fileItems map[string]cacheItem
+	var snapshot snapshotFile
+	if err := json.Unmarshal(data, &snapshot); err == nil && snapshot.Version > 0 {
+		fileItems = snapshot.Items
+		fs.generation = snapshot.Generation
+		if fileItems == nil {
+			fileItems = map[string]cacheItem{}
+		}
+	} else if err := json.Unmarshal(data, &fileItems); err != nil {
+		// 旧版快照：直接是 map[string]cacheItem
+		return fmt.Errorf("快照文件反序列化失败: %w", err)
+	}
+
+	// 检查是否解析出了有效的数据结构
+	if fileItems == nil {
+		fmt.Printf("缓存快照文件格式无效\n")
+		return nil
+	}
+
+	now := time.Now()
+	loadedCount := 0
+
+	for k, item := range fileItems {
+		// 如果过期时间是零值，表示永不过期，使用默认TTL加载
+		if item.Expiration.IsZero() {
+			// 尝试将字符串IP转换为net.IP
+			if ipsStr := convertToStringSlice(item.Value); len(ipsStr) > 0 {
+				var ips []net.IP
+				for _, ipStr := range ipsStr {
+					if ip := net.ParseIP(ipStr); ip != nil {
+						ips = append(ips, ip)
+					}
+				}
+				if len(ips) > 0 {
+					fs.cache.Set(k, ips, DefaultTTL) // 使用默认TTL
+					loadedCount++
+				}
+			} else {
+				fs.cache.Set(k, item.Value, DefaultTTL) // 使用默认TTL
+				loadedCount++
+			}
+			continue
+		}
+
+		// 验证过期时间是否有效
+		if item.Expiration.Year() < 0 || item.Expiration.Year() > 9999 {
+			fmt.Printf("警告: 跳过无效的过期时间 for key %s: %v\n", k, item.Expiration)
+			continue
+		}
+
+		if item.Expiration.After(now) {
+			ttl := time.Until(item.Expiration)
+			// 尝试将字符串IP转换为net.IP
+			if ipsStr := convertToStringSlice(item.Value); len(ipsStr) > 0 {
+				var ips []net.IP
+				for _, ipStr := range ipsStr {
+					if ip := net.ParseIP(ipStr); ip != nil {
+						ips = append(ips, ip)
+					}
+				}
+				if len(ips) > 0 {
+					fs.cache.Set(k, ips, ttl)
+					loadedCount++
+				}
+			} else {
+				fs.cache.Set(k, item.Value, ttl)
+				loadedCount++
+			}
+		}
+	}
+
+	fmt.Printf("快照文件加载完成，共加载 %d 条记录\n", loadedCount)
+	return nil
+}
+
+// rewriteAOFLocked 以当前代号重写AOF文件并重新打开用于追加，调用方需持有 fs.mu
+// withItems 为 true 时写入当前所有未过期的缓存项（启动和压缩时），否则只写文件头（快照之后）
+func (fs *fileStore) rewriteAOFLocked(withItems bool) error {
+	if fs.aofPath == "" {
+		return nil
+	}
+
+	buf := aofHeader(fs.generation)
+	if withItems {
+		now := time.Now()
+		for key, item := range fs.cache.Items() {
+			ttl := int64(DefaultTTL.Seconds())
+			if item.Expiration > 0 {
+				remaining := time.Unix(0, item.Expiration).Sub(now)
+				if remaining < time.Second {
+					continue // 已过期或即将过期，跳过
+				}
+				ttl = int64(remaining.Seconds())
+			}
+			record, err := encodeAOFRecord(AOFEntry{
+				Timestamp: now,
+				Operation: "SET",
+				Key:       key,
+				Value:     item.Object,
+				TTL:       ttl,
+			})
+			if err != nil {
+				return err
+			}
+			buf = append(buf, record...)
+		}
+	}
+
+	// 先关闭当前AOF，再原子替换
+	if fs.aofFile != nil {
+		fs.aofFile.Close()
+		fs.aofFile = nil
+	}
+	if err := writeFileAtomic(fs.aofPath, buf); err != nil {
+		return err
+	}
+	return fs.initAOF()
+}
+
+// initAOF 打开AOF文件用于追加
+func (fs *fileStore) initAOF() error {
+	file, err := os.OpenFile(fs.aofPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	fs.aofFile = file
+	fs.aofDirty = false
+
+	return nil
+}
+
+// Append 追加AOF日志条目
+func (fs *fileStore) Append(operation, key string, value interface{}, ttl time.Duration) error {
+	record, err := encodeAOFRecord(AOFEntry{
+		Timestamp: time.Now(),
+		Operation: operation,
+		Key:       key,
+		Value:     value,
+		TTL:       int64(ttl.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.aofFile == nil {
+		return nil // AOF未初始化或已关闭，忽略
+	}
+	// 整条记录一次写入，崩溃时最多留下一条不完整的记录
+	if _, err := fs.aofFile.Write(record); err != nil {
+		return err
+	}
+	if fs.fsync == AppendFsyncAlways {
+		return fs.aofFile.Sync()
+	}
+	fs.aofDirty = true
+	return nil
+}
+
+// Sync 将尚未落盘的AOF记录 fsync 到磁盘（everysec 策略）
+func (fs *fileStore) Sync() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.aofFile == nil || !fs.aofDirty {
+		return nil
+	}
+	fs.aofDirty = false
+	return fs.aofFile.Sync()
+}
+
+// replayAOF 重放AOF日志
+// 带代号的AOF早于快照时（快照已写入但AOF尚未切换时崩溃）跳过重放，其内容已包含在快照中
+func (fs *fileStore) replayAOF() error {
+	var entries []AOFEntry
+	result, err := readAOF(fs.aofPath, func(entry AOFEntry) {
+		entries = append(entries, entry)
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // AOF文件不存在，正常情况
+		}
+		if err != errAOFCorrupt {
+			return err
+		}
+		fmt.Printf("警告: AOF文件尾部有 %d 字节无效数据，已在最后一条有效记录处停止\n", result.TotalSize-result.ValidSize)
+	}
+	if !result.Legacy && result.Generation < fs.generation {
+		fmt.Printf("AOF代号 %d 早于快照代号 %d，跳过重放\n", result.Generation, fs.generation)
+		return nil
+	}
+	if result.Generation > fs.generation {
+		fs.generation = result.Generation
+	}
+
+	replayedCount := 0
+	for _, entry := range entries {
+		// 根据操作类型重放
+		switch entry.Operation {
+		case "SET":
+			ttl := time.Duration(entry.TTL) * time.Second
+			if ttl <= 0 {
+				ttl = DefaultTTL
+			}
+
+			// 特殊处理IP类型数据
+			if ipsStr := convertToStringSlice(entry.Value); len(ipsStr) > 0 {
+				var ips []net.IP
+				for _, ipStr := range ipsStr {
+					if ip := net.ParseIP(ipStr); ip != nil {
+						ips = append(ips, ip)
+					}
+				}
+				if len(ips) > 0 {
+					fs.cache.Set(entry.Key, ips, ttl)
+				}
+			} else {
+				fs.cache.Set(entry.Key, entry.Value, ttl)
+			}
+			replayedCount++
+
+		case "DELETE", "EVICT":
+			fs.cache.Delete(entry.Key)
+			replayedCount++
+
+		default:
+			fmt.Printf("警告: 未知的AOF操作类型: %s\n", entry.Operation)
+		}
+	}
+
+	fmt.Printf("AOF重放完成，共处理 %d 条记录\n", replayedCount)
+	return nil
+}
+
+// Maintain 检查AOF文件大小并在需要时压缩
+func (fs *fileStore) Maintain() error {
+	if fs.aofPath == "" {
+		return nil
+	}
+
+	info, err := os.Stat(fs.aofPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// 如果AOF文件超过10MB，进行压缩
+	if info.Size() > 10*1024*1024 {
+		fmt.Printf("AOF文件过大(%d bytes)，开始压缩...\n", info.Size())
+		return fs.compactAOF()
+	}
+
+	return nil
+}
+
+// compactAOF 压缩AOF文件：以当前数据重写，代号不变
+func (fs *fileStore) compactAOF() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return nil
+	}
+	return fs.rewriteAOFLocked(true)
+}
+
+// Close 同步并关闭AOF文件，之后的写入被忽略
+func (fs *fileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.closed = true
+	if fs.aofFile == nil {
+		return nil
+	}
+	fs.aofFile.Sync()
+	err := fs.aofFile.Close()
+	fs.aofFile = nil
+	return err
+}
+
+// Stats 返回持久化相关的统计信息
+func (fs *fileStore) Stats() map[string]interface{} {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return map[string]interface{}{
+		"backend":     string(BackendFile),
+		"file_path":   fs.filePath,
+		"aof_path":    fs.aofPath,
+		"generation":  fs.generation,
+		"appendfsync": string(fs.fsync),
+	}
+}
@@ -0,0 +1,111 @@
+package dnscache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/doh"
+	"github.com/masx200/http-proxy-go-server/hosts"
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+// httpsRecordType HTTPS/SVCB 记录在缓存中的类型，值为端点列表的 JSON 字符串，
+// 空列表表示否定缓存（该域名没有 HTTPS 记录）
+const httpsRecordType = "https"
+
+// GetHTTPSEndpoints 读取缓存的 HTTPS 记录
+func (dc *DNSCache) GetHTTPSEndpoints(ctx context.Context, host, port string) ([]doh.HTTPSEndpoint, bool) {
+	s, ok := dc.lookupString(httpsRecordType, ecsCacheKey(ctx, net.JoinHostPort(host, port)))
+	if !ok {
+		return nil, false
+	}
+	var endpoints []doh.HTTPSEndpoint
+	if err := json.Unmarshal([]byte(s), &endpoints); err != nil {
+		return nil, false
+	}
+	return endpoints, true
+}
+
+// SetHTTPSEndpoints 缓存 HTTPS 记录，ttl 不大于0时使用默认TTL
+func (dc *DNSCache) SetHTTPSEndpoints(ctx context.Context, host, port string, endpoints []doh.HTTPSEndpoint, ttl time.Duration) {
+	if endpoints == nil {
+		endpoints = []doh.HTTPSEndpoint{}
+	}
+	data, err := json.Marshal(endpoints)
+	if err != nil {
+		return
+	}
+	dc.Set(httpsRecordType, ecsCacheKey(ctx, net.JoinHostPort(host, port)), string(data), ttl)
+}
+
+// hasStaticAddress 判断域名是否在 hosts 表或固定解析中配置了地址
+func hasStaticAddress(host string, dnsCache *DNSCache) bool {
+	if len(hosts.DefaultTable().Lookup(host)) > 0 {
+		return true
+	}
+	if dnsCache != nil && dnsCache.pins != nil {
+		if _, ok := dnsCache.pins.lookup(normalizeDomain(host)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupHTTPSEndpoints 查询 host:port 的 HTTPS 记录，优先使用缓存
+// 未启用 HTTPS 记录、host 为 IP 或查询失败时返回 nil。
+// host 在 hosts 表或固定解析中配置了地址时也返回 nil，由解析器按配置的地址拨号，
+// 不让 HTTPS 记录中的地址提示覆盖它们
+func LookupHTTPSEndpoints(ctx context.Context, host, port string, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *DNSCache, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) []doh.HTTPSEndpoint {
+	if !doh.HTTPSRecordsEnabled() || IsIP(host) || hasStaticAddress(host, dnsCache) {
+		return nil
+	}
+	if dnsCache != nil {
+		if endpoints, found := dnsCache.GetHTTPSEndpoints(ctx, host, port); found {
+			log.Printf("DNS cache hit for https: %s:%s", host, port)
+			return endpoints
+		}
+	}
+	selected := options.SelectDNSOptions(proxyoptions, host)
+	Shuffle(selected)
+	for _, opt := range selected {
+		opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+		endpoints, ttl, err := doh.LookupHTTPSWithOption(host, port, opt, Proxy, tranportConfigurations...)
+		if err != nil {
+			continue
+		}
+		if dnsCache != nil {
+			dnsCache.SetHTTPSEndpoints(ctx, host, port, endpoints, time.Duration(ttl)*time.Second)
+		}
+		return endpoints
+	}
+	return nil
+}
+
+// dialHTTPSEndpoints 按 HTTPS 记录的端点依次拨号：优先使用 ipv4hint/ipv6hint，
+// 端点指向其它主机或没有地址提示时再解析目标主机；全部失败返回 nil
+func dialHTTPSEndpoints(ctx context.Context, network, hostname, port string, endpoints []doh.HTTPSEndpoint, resolver NameResolver) net.Conn {
+	for _, ep := range endpoints {
+		targetHost := ep.TargetHost(hostname)
+		targetPort := ep.TargetPort(port)
+		ips := ep.Hints()
+		if len(ips) == 0 && resolver != nil {
+			ips, _ = resolver.LookupIP(ctx, network, targetHost)
+		}
+		if len(ips) == 0 {
+			continue
+		}
+		connection, err := options.DialHappyEyeballs(ctx, network, targetHost, targetPort, ips)
+		if err != nil {
+			log.Printf("Failed to connect to %s via HTTPS record endpoint %s:%s: %v", hostname, targetHost, targetPort, err)
+			continue
+		}
+		log.Printf("Successfully connected to %s via HTTPS record endpoint %s", hostname, connection.RemoteAddr())
+		return connection
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/doh"
+	"github.com/masx200/http-proxy-go-server/hosts"
+)
+
+// hosts 表和固定解析与 HTTPS 记录的地址提示不一致时，以 hosts 表和固定解析为准
+func TestHTTPSEndpointsYieldToHostsAndPins(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.PinsPath = filepath.Join(dir, "pins.json")
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+
+	doh.EnableHTTPSRecords(true)
+	defer doh.EnableHTTPSRecords(false)
+	if err := hosts.Configure([]string{filepath.Join(dir, "hosts")}, map[string][]string{"hosts.example.test": {"127.0.0.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer hosts.Configure(nil, nil)
+	if err := cache.Pin("pinned.example.test", []net.IP{net.ParseIP("127.0.0.1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// HTTPS 记录把连接引向另一个监听端口
+	hinted, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hinted.Close()
+	accepted := make(chan struct{}, 4)
+	go func() {
+		for {
+			c, err := hinted.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			c.Close()
+		}
+	}()
+	endpoint := doh.HTTPSEndpoint{Priority: 1, Target: ".", Port: uint16(hinted.Addr().(*net.TCPAddr).Port), IPv4Hint: []string{"127.0.0.1"}}
+	ctx := context.Background()
+	for _, host := range []string{"hosts.example.test", "pinned.example.test", "other.example.test"} {
+		cache.SetHTTPSEndpoints(ctx, host, "443", []doh.HTTPSEndpoint{endpoint}, time.Minute)
+	}
+
+	if endpoints := LookupHTTPSEndpoints(ctx, "other.example.test", "443", nil, cache, nil); len(endpoints) != 1 {
+		t.Fatalf("Expected the HTTPS record for a name without static addresses, got %v", endpoints)
+	}
+	for _, host := range []string{"hosts.example.test", "pinned.example.test"} {
+		if endpoints := LookupHTTPSEndpoints(ctx, host, "443", nil, cache, nil); endpoints != nil {
+			t.Errorf("%s: expected HTTPS record to be ignored, got %v", host, endpoints)
+		}
+		// 拨号按 hosts/固定解析的地址连接 443 端口，不应到达 HTTPS 记录指向的端口
+		if conn, err := Proxy_net_DialContextCached(ctx, "tcp", net.JoinHostPort(host, "443"), nil, cache, false, nil); err == nil {
+			conn.Close()
+		}
+		select {
+		case <-accepted:
+			t.Errorf("%s: dial followed the HTTPS record to port %d", host, endpoint.Port)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
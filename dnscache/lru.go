@@ -0,0 +1,146 @@
+package dnscache
+
+import (
+	"container/list"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// lruEntry LRU 索引中的一项
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+// lruIndex 记录缓存键的访问顺序和近似内存占用，用于在超过容量限制时淘汰最久未使用的记录
+// go-cache 本身不限制容量，索引只负责挑选淘汰对象，真正的删除由 DNSCache 完成
+type lruIndex struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu         sync.Mutex
+	order      *list.List // 队头为最近使用
+	items      map[string]*list.Element
+	totalBytes int64
+
+	evictions    atomic.Int64
+	evictedBytes atomic.Int64
+}
+
+func newLRUIndex(maxEntries int, maxBytes int64) *lruIndex {
+	return &lruIndex{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// pinnedKey fake-IP 映射不参与淘汰，淘汰后已分配的地址将无法反查域名
+func pinnedKey(key string) bool {
+	return strings.HasPrefix(key, strings.ToUpper(fakeIPType)+":") ||
+		strings.HasPrefix(key, strings.ToUpper(fakeIPReverseType)+":")
+}
+
+// approxSize 估算一条记录占用的字节数，只需与实际占用同一量级
+func approxSize(key string, value interface{}) int64 {
+	const overhead = 64 // go-cache 条目、map 桶和链表节点的固定开销
+	size := int64(len(key)) + overhead
+	switch v := value.(type) {
+	case string:
+		size += int64(len(v))
+	case []net.IP:
+		size += 24 + int64(len(v))*(24+net.IPv6len)
+	case []string:
+		size += 24
+		for _, s := range v {
+			size += 16 + int64(len(s))
+		}
+	case nil:
+	default:
+		if data, err := json.Marshal(v); err == nil {
+			size += int64(len(data))
+		}
+	}
+	return size
+}
+
+// add 记录一次写入，返回超过限制后需要淘汰的键
+func (l *lruIndex) add(key string, value interface{}) []string {
+	if pinnedKey(key) {
+		return nil
+	}
+	size := approxSize(key, value)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		l.totalBytes += size - entry.size
+		entry.size = size
+		l.order.MoveToFront(elem)
+	} else {
+		l.items[key] = l.order.PushFront(&lruEntry{key: key, size: size})
+		l.totalBytes += size
+	}
+	return l.overflowLocked()
+}
+
+// touch 记录一次命中
+func (l *lruIndex) touch(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[key]; ok {
+		l.order.MoveToFront(elem)
+	}
+}
+
+// remove 删除或过期时从索引中移除
+func (l *lruIndex) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[key]; ok {
+		l.removeElementLocked(elem)
+	}
+}
+
+// reset 清空索引，淘汰计数保留
+func (l *lruIndex) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.order.Init()
+	l.items = make(map[string]*list.Element)
+	l.totalBytes = 0
+}
+
+func (l *lruIndex) removeElementLocked(elem *list.Element) *lruEntry {
+	entry := elem.Value.(*lruEntry)
+	l.order.Remove(elem)
+	delete(l.items, entry.key)
+	l.totalBytes -= entry.size
+	return entry
+}
+
+// overflowLocked 从队尾取出超出限制的键并计入淘汰统计，调用方需持有锁
+func (l *lruIndex) overflowLocked() []string {
+	var victims []string
+	for l.order.Len() > 0 &&
+		((l.maxEntries > 0 && l.order.Len() > l.maxEntries) ||
+			(l.maxBytes > 0 && l.totalBytes > l.maxBytes)) {
+		entry := l.removeElementLocked(l.order.Back())
+		victims = append(victims, entry.key)
+		l.evictions.Add(1)
+		l.evictedBytes.Add(entry.size)
+	}
+	return victims
+}
+
+// usage 返回当前索引的条目数和近似字节数
+func (l *lruIndex) usage() (int, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len(), l.totalBytes
+}
@@ -0,0 +1,127 @@
+package dnscache
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDNSCacheLRUEviction(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.MaxEntries = 2
+
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	cache.SetIP("A", "a.example.com", net.ParseIP("192.0.2.1"), time.Minute)
+	cache.SetIP("A", "b.example.com", net.ParseIP("192.0.2.2"), time.Minute)
+	// 访问 a 之后 b 成为最久未使用的记录
+	if _, ok := cache.GetIP("A", "a.example.com"); !ok {
+		t.Fatal("Expected a.example.com to be cached")
+	}
+	cache.SetIP("A", "c.example.com", net.ParseIP("192.0.2.3"), time.Minute)
+
+	if _, ok := cache.GetIP("A", "b.example.com"); ok {
+		t.Error("Expected b.example.com to be evicted")
+	}
+	if cache.ItemCount() != 2 {
+		t.Errorf("Expected 2 items, got %d", cache.ItemCount())
+	}
+	stats := cache.Stats()
+	if stats["evictions"].(int64) != 1 || stats["evicted_bytes"].(int64) <= 0 {
+		t.Errorf("Unexpected eviction stats: %v", stats)
+	}
+
+	// fake-IP 映射不参与淘汰
+	cache.Set(fakeIPType, "pinned.example.com", "198.18.0.1", time.Minute)
+	if cache.ItemCount() != 3 {
+		t.Errorf("Expected pinned entry to be kept, got %d items", cache.ItemCount())
+	}
+
+	// 等待异步 AOF 写入后重新加载，淘汰结果应保持一致
+	time.Sleep(100 * time.Millisecond)
+	cache.Close()
+	reloaded, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to reload cache: %v", err)
+	}
+	defer reloaded.Close()
+	if _, ok := reloaded.GetIP("A", "b.example.com"); ok {
+		t.Error("Expected b.example.com to stay evicted after reload")
+	}
+	if _, ok := reloaded.GetIP("A", "c.example.com"); !ok {
+		t.Error("Expected c.example.com to survive reload")
+	}
+}
+
+func TestDNSCacheMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.MaxBytes = 4 * approxSize("A:host-0.example.com", []net.IP{net.ParseIP("192.0.2.1")})
+
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+	for i := 0; i < 10; i++ {
+		cache.SetIP("A", "host-"+string(rune('0'+i))+".example.com", net.ParseIP("192.0.2.1"), time.Minute)
+	}
+	stats := cache.Stats()
+	if stats["approx_bytes"].(int64) > config.MaxBytes {
+		t.Errorf("Expected approx_bytes <= %d, got %v", config.MaxBytes, stats["approx_bytes"])
+	}
+	if cache.ItemCount() != 4 {
+		t.Errorf("Expected 4 items, got %d", cache.ItemCount())
+	}
+}
+
+// 同一记录连续的写入、淘汰和删除按调用顺序写入AOF日志，关闭时等待队列中的写入完成
+func TestDNSCacheAppendOrder(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.MaxEntries = 5
+
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		domain := fmt.Sprintf("d%d.example", i%10)
+		cache.Set("TXT", domain, fmt.Sprint(i), time.Hour)
+		if i%3 == 0 {
+			cache.Delete("TXT", domain)
+		}
+	}
+	want := make(map[string]interface{})
+	for i := 0; i < 10; i++ {
+		domain := fmt.Sprintf("d%d.example", i)
+		if value, ok := cache.Get("TXT", domain); ok {
+			want[domain] = value
+		}
+	}
+	cache.Close()
+
+	reloaded, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer reloaded.Close()
+	for i := 0; i < 10; i++ {
+		domain := fmt.Sprintf("d%d.example", i)
+		value, ok := reloaded.Get("TXT", domain)
+		if expected, exists := want[domain]; ok != exists || value != expected {
+			t.Errorf("%s: expected %v (exists=%v) after reload, got %v (exists=%v)", domain, expected, exists, value, ok)
+		}
+	}
+}
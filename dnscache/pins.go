@@ -0,0 +1,180 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pinTable 固定的静态解析结果，优先于缓存中的记录，不会过期也不参与淘汰
+// 以 JSON 文件单独保存（域名 -> IP 列表），与缓存后端无关
+type pinTable struct {
+	path string
+
+	mu   sync.RWMutex
+	pins map[string][]net.IP
+}
+
+func newPinTable(path string) *pinTable {
+	t := &pinTable{path: path, pins: make(map[string][]net.IP)}
+	if path == "" {
+		return t
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("警告: 读取固定解析文件失败: %v\n", err)
+		}
+		return t
+	}
+	var stored map[string][]string
+	if err := json.Unmarshal(data, &stored); err != nil {
+		fmt.Printf("警告: 解析固定解析文件失败: %v\n", err)
+		return t
+	}
+	for domain, values := range stored {
+		for _, s := range values {
+			if ip := net.ParseIP(s); ip != nil {
+				t.pins[normalizeDomain(domain)] = append(t.pins[normalizeDomain(domain)], ip)
+			}
+		}
+	}
+	return t
+}
+
+// saveLocked 原子写入固定解析文件，调用方需持有写锁
+func (t *pinTable) saveLocked() error {
+	if t.path == "" {
+		return nil
+	}
+	stored := make(map[string][]string, len(t.pins))
+	for domain, ips := range t.pins {
+		for _, ip := range ips {
+			stored[domain] = append(stored[domain], ip.String())
+		}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(t.path, data)
+}
+
+func (t *pinTable) lookup(domain string) ([]net.IP, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ips, ok := t.pins[domain]
+	return ips, ok
+}
+
+func (t *pinTable) len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.pins)
+}
+
+// keyDomain 从缓存键中取出记录类型、域名和网络类型
+// 键的格式为 TYPE:domain，LOOKUPIP 和 DNSSEC 为 TYPE:network:host，HTTPS 为 TYPE:host:port，
+// 启用 ECS 时末尾带有 @子网
+func keyDomain(key string) (dnsType, domain, network string) {
+	dnsType, rest, _ := strings.Cut(key, ":")
+	if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+		rest = rest[:i]
+	}
+	switch dnsType {
+	case "LOOKUPIP", "DNSSEC":
+		if n, host, ok := strings.Cut(rest, ":"); ok {
+			network, rest = n, host
+		}
+	case "HTTPS":
+		if host, _, err := net.SplitHostPort(rest); err == nil {
+			rest = host
+		}
+	}
+	return dnsType, rest, network
+}
+
+// pinnedValue 按缓存键的类型返回固定解析结果
+func (dc *DNSCache) pinnedValue(key string) (interface{}, bool) {
+	if dc.pins == nil || dc.pins.len() == 0 {
+		return nil, false
+	}
+	dnsType, domain, network := keyDomain(key)
+	ips, ok := dc.pins.lookup(domain)
+	if !ok {
+		return nil, false
+	}
+	switch dnsType {
+	case "RESOLVE":
+		return ips[0], true
+	case "A":
+		network = "ip4"
+	case "AAAA":
+		network = "ip6"
+	case "LOOKUPIP", "DOH":
+	default:
+		return nil, false
+	}
+
+	var filtered []net.IP
+	for _, ip := range ips {
+		is4 := ip.To4() != nil
+		if (strings.HasSuffix(network, "4") && !is4) || (strings.HasSuffix(network, "6") && is4) {
+			continue
+		}
+		filtered = append(filtered, ip)
+	}
+	if len(filtered) == 0 {
+		return nil, false
+	}
+	return filtered, true
+}
+
+// Pin 为域名固定解析结果，之后的查询直接返回这些地址，不会过期也不会被淘汰
+func (dc *DNSCache) Pin(domain string, ips []net.IP) error {
+	if dc.pins == nil {
+		return fmt.Errorf("dns cache is disabled")
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no ip addresses to pin for %s", domain)
+	}
+	domain = normalizeDomain(domain)
+	dc.pins.mu.Lock()
+	defer dc.pins.mu.Unlock()
+	dc.pins.pins[domain] = ips
+	return dc.pins.saveLocked()
+}
+
+// Unpin 取消域名的固定解析，返回是否存在
+func (dc *DNSCache) Unpin(domain string) (bool, error) {
+	if dc.pins == nil {
+		return false, nil
+	}
+	domain = normalizeDomain(domain)
+	dc.pins.mu.Lock()
+	defer dc.pins.mu.Unlock()
+	if _, ok := dc.pins.pins[domain]; !ok {
+		return false, nil
+	}
+	delete(dc.pins.pins, domain)
+	return true, dc.pins.saveLocked()
+}
+
+// Pins 返回所有固定解析，按域名排序
+func (dc *DNSCache) Pins() []EntryInfo {
+	if dc.pins == nil {
+		return nil
+	}
+	dc.pins.mu.RLock()
+	defer dc.pins.mu.RUnlock()
+	entries := make([]EntryInfo, 0, len(dc.pins.pins))
+	for domain, ips := range dc.pins.pins {
+		entries = append(entries, EntryInfo{Key: "PIN:" + domain, Type: "PIN", Domain: domain, Value: ips, TTL: -1, Pinned: true})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Domain < entries[j].Domain })
+	return entries
+}
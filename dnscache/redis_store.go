@@ -0,0 +1,246 @@
+package dnscache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultRedisPrefix redis 键的默认前缀
+	DefaultRedisPrefix = "dnscache:"
+	// redisTimeout 单次 redis 操作的超时时间
+	redisTimeout = 2 * time.Second
+	// redisScanBatch 启动时批量加载的键数量
+	redisScanBatch = 1000
+)
+
+// redisStore Redis 协议服务器作为多个实例共享的后端
+// 记录以 JSON 保存并使用 redis 原生 TTL；本地 go-cache 作为一级缓存，
+// 写入和删除通过 pub/sub 通知其它实例删除各自的一级缓存
+type redisStore struct {
+	client  *redis.Client
+	addr    string
+	prefix  string
+	channel string
+	id      string // 实例标识，用于忽略自己发布的失效通知
+
+	pubsub *redis.PubSub
+	closed atomic.Bool
+
+	remoteHits    atomic.Int64
+	remoteMisses  atomic.Int64
+	invalidations atomic.Int64
+}
+
+// NewRedisStore 连接 redis 并创建共享后端，rawURL 形如 redis://:password@127.0.0.1:6379/0
+func NewRedisStore(rawURL, prefix string) (Store, error) {
+	opt, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("解析redis地址失败: %w", err)
+	}
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	client := redis.NewClient(opt)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("连接redis失败: %w", err)
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &redisStore{
+		client:  client,
+		addr:    opt.Addr,
+		prefix:  prefix,
+		channel: prefix + "invalidate",
+		id:      hex.EncodeToString(id),
+	}, nil
+}
+
+// Load 把 redis 中已有的记录加载到一级缓存，并订阅失效通知
+func (rs *redisStore) Load(c *cache.Cache) error {
+	ctx := context.Background()
+	loadedCount := 0
+	var cursor uint64
+	for {
+		scanCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+		keys, next, err := rs.client.Scan(scanCtx, cursor, rs.prefix+"*", redisScanBatch).Result()
+		if err == nil && len(keys) > 0 {
+			loadedCount += rs.loadKeys(scanCtx, c, keys)
+		}
+		cancel()
+		if err != nil {
+			return fmt.Errorf("读取redis缓存失败: %w", err)
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	fmt.Printf("redis缓存加载完成，共加载 %d 条记录\n", loadedCount)
+
+	rs.pubsub = rs.client.Subscribe(ctx, rs.channel)
+	subCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if _, err := rs.pubsub.Receive(subCtx); err != nil {
+		rs.pubsub.Close()
+		return fmt.Errorf("订阅redis失效通知失败: %w", err)
+	}
+	go rs.listen(c)
+	return nil
+}
+
+// loadKeys 批量读取一组键的值和剩余 TTL
+func (rs *redisStore) loadKeys(ctx context.Context, c *cache.Cache, keys []string) int {
+	pipe := rs.client.Pipeline()
+	gets := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	pipe.Exec(ctx)
+
+	loaded := 0
+	for i, key := range keys {
+		data, err := gets[i].Bytes()
+		if err != nil {
+			continue
+		}
+		value, err := decodeStoredValue(data)
+		if err != nil {
+			continue
+		}
+		c.Set(strings.TrimPrefix(key, rs.prefix), value, remoteTTL(ttls[i].Val()))
+		loaded++
+	}
+	return loaded
+}
+
+// remoteTTL 将 redis 返回的剩余 TTL 转换为本地缓存的 TTL，未设置过期时使用默认值
+func remoteTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return DefaultTTL
+	}
+	return ttl
+}
+
+// listen 处理其它实例发布的失效通知，删除本地一级缓存中的对应记录
+func (rs *redisStore) listen(c *cache.Cache) {
+	for msg := range rs.pubsub.Channel() {
+		sender, key, ok := strings.Cut(msg.Payload, "|")
+		if !ok || sender == rs.id {
+			continue
+		}
+		c.Delete(key)
+		rs.invalidations.Add(1)
+	}
+}
+
+// Append 写入 redis 并通知其它实例，本地容量淘汰不影响共享数据
+func (rs *redisStore) Append(operation, key string, value interface{}, ttl time.Duration) error {
+	if rs.closed.Load() || operation == "EVICT" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	_, err := rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		switch operation {
+		case "SET":
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, rs.prefix+key, data, ttl)
+		case "DELETE":
+			pipe.Del(ctx, rs.prefix+key)
+		default:
+			return fmt.Errorf("unknown store operation %s", operation)
+		}
+		pipe.Publish(ctx, rs.channel, rs.id+"|"+key)
+		return nil
+	})
+	return err
+}
+
+// Fetch 一级缓存未命中时从 redis 读取
+func (rs *redisStore) Fetch(key string) (interface{}, time.Duration, bool) {
+	if rs.closed.Load() {
+		return nil, 0, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	pipe := rs.client.Pipeline()
+	get := pipe.Get(ctx, rs.prefix+key)
+	pttl := pipe.PTTL(ctx, rs.prefix+key)
+	pipe.Exec(ctx)
+
+	data, err := get.Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			fmt.Printf("读取redis缓存失败 %s: %v\n", key, err)
+		}
+		rs.remoteMisses.Add(1)
+		return nil, 0, false
+	}
+	value, err := decodeStoredValue(data)
+	if err != nil {
+		rs.remoteMisses.Add(1)
+		return nil, 0, false
+	}
+	rs.remoteHits.Add(1)
+	return value, remoteTTL(pttl.Val()), true
+}
+
+// Checkpoint redis 自行持久化，无需全量保存
+func (rs *redisStore) Checkpoint() error {
+	return nil
+}
+
+// Maintain redis 后端不需要额外维护
+func (rs *redisStore) Maintain() error {
+	return nil
+}
+
+// Sync redis 后端的刷盘由服务器配置决定
+func (rs *redisStore) Sync() error {
+	return nil
+}
+
+// Close 取消订阅并关闭连接
+func (rs *redisStore) Close() error {
+	if !rs.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	if rs.pubsub != nil {
+		rs.pubsub.Close()
+	}
+	return rs.client.Close()
+}
+
+// Stats 返回共享后端的统计信息
+func (rs *redisStore) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"backend":       string(BackendRedis),
+		"redis_addr":    rs.addr,
+		"redis_prefix":  rs.prefix,
+		"remote_hits":   rs.remoteHits.Load(),
+		"remote_misses": rs.remoteMisses.Load(),
+		"invalidations": rs.invalidations.Load(),
+	}
+}
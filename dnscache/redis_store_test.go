@@ -0,0 +1,88 @@
+package dnscache
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newRedisTestCache(t *testing.T, server *miniredis.Miniredis) *DNSCache {
+	t.Helper()
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.Backend = BackendRedis
+	config.RedisURL = "redis://" + server.Addr() + "/0"
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	return cache
+}
+
+// waitFor 等待异步写入和失效通知完成
+func waitFor(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestRedisStoreSharedCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	a := newRedisTestCache(t, server)
+	defer a.Close()
+	b := newRedisTestCache(t, server)
+	defer b.Close()
+
+	a.SetIP("A", "example.com", net.ParseIP("192.0.2.1"), time.Minute)
+	if !waitFor(t, func() bool { return server.Exists(DefaultRedisPrefix + "A:example.com") }) {
+		t.Fatal("Expected record to be written to redis")
+	}
+	if ttl := server.TTL(DefaultRedisPrefix + "A:example.com"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected native redis TTL of about 1m, got %v", ttl)
+	}
+
+	// b 本地未命中，从 redis 回源
+	if ip, ok := b.GetIP("A", "example.com"); !ok || ip.String() != "192.0.2.1" {
+		t.Fatalf("Expected b to read shared record, got %v", ip)
+	}
+
+	// a 更新后 b 的一级缓存被失效通知清除
+	a.SetIP("A", "example.com", net.ParseIP("192.0.2.2"), time.Minute)
+	if !waitFor(t, func() bool {
+		ip, ok := b.GetIP("A", "example.com")
+		return ok && ip.String() == "192.0.2.2"
+	}) {
+		t.Error("Expected b to observe updated record after invalidation")
+	}
+
+	a.Delete("A", "example.com")
+	if !waitFor(t, func() bool {
+		_, ok := b.GetIP("A", "example.com")
+		return !ok
+	}) {
+		t.Error("Expected delete to propagate to b")
+	}
+
+	// 新实例启动时从 redis 预热一级缓存
+	a.Set("TXT", "warm.example.com", "hello", time.Minute)
+	waitFor(t, func() bool { return server.Exists(DefaultRedisPrefix + "TXT:warm.example.com") })
+	c := newRedisTestCache(t, server)
+	defer c.Close()
+	if _, found := c.cache.Get("TXT:warm.example.com"); !found {
+		t.Error("Expected new instance to warm L1 from redis")
+	}
+	if stats := b.Stats(); stats["backend"] != "redis" || stats["invalidations"].(int64) == 0 {
+		t.Errorf("Unexpected redis stats: %v", stats)
+	}
+}
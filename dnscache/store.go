@@ -0,0 +1,107 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Store DNS缓存的持久化后端
+// DNSCache 始终以内存中的 go-cache 作为查询路径，Store 只负责把写入持久化并在启动时恢复
+type Store interface {
+	// Load 把持久化的记录恢复到 c 中，并绑定 c 供之后的 Checkpoint 读取全部记录
+	Load(c *cache.Cache) error
+	// Append 持久化一次写入，operation 为 "SET"、"DELETE" 或 "EVICT"
+	Append(operation, key string, value interface{}, ttl time.Duration) error
+	// Checkpoint 按保存间隔调用，快照型后端在此写入全量数据，事务型后端可用来清理过期记录
+	Checkpoint() error
+	// Maintain 按AOF间隔调用，用于压缩等后台维护
+	Maintain() error
+	// Sync 将尚未落盘的写入 fsync 到磁盘（everysec 策略每秒调用一次）
+	Sync() error
+	// Close 关闭后端，之后的 Append 被忽略
+	Close() error
+	// Stats 返回后端相关的统计信息
+	Stats() map[string]interface{}
+}
+
+// RemoteStore 多个实例共享的后端（如 Redis），本地缓存未命中时按键回源读取
+type RemoteStore interface {
+	Store
+	// Fetch 读取一条记录及其剩余 TTL
+	Fetch(key string) (interface{}, time.Duration, bool)
+}
+
+// batchStore 可以在一次提交中按顺序持久化多次写入的后端
+type batchStore interface {
+	appendBatch(ops []storeOp) error
+}
+
+// Backend 持久化后端类型
+type Backend string
+
+const (
+	// BackendFile JSON 快照 + 带校验的 AOF（默认）
+	BackendFile Backend = "file"
+	// BackendBolt 嵌入式 bbolt 数据库，每次写入事务提交，不需要定期全量快照
+	BackendBolt Backend = "bbolt"
+	// BackendRedis Redis 协议服务器，多个实例共享缓存，本地内存缓存作为一级缓存
+	BackendRedis Backend = "redis"
+)
+
+// ParseBackend 解析后端类型，空字符串返回 file
+func ParseBackend(s string) (Backend, error) {
+	switch Backend(strings.ToLower(strings.TrimSpace(s))) {
+	case "", BackendFile:
+		return BackendFile, nil
+	case BackendBolt, "bolt":
+		return BackendBolt, nil
+	case BackendRedis:
+		return BackendRedis, nil
+	default:
+		return "", fmt.Errorf("invalid dns cache backend %q (want file, bbolt or redis)", s)
+	}
+}
+
+// newStore 按配置创建持久化后端
+func newStore(config *Config, fsync AppendFsync) (Store, error) {
+	if config.Store != nil {
+		return config.Store, nil
+	}
+	backend, err := ParseBackend(string(config.Backend))
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case BackendBolt:
+		return NewBoltStore(config.DBPath)
+	case BackendRedis:
+		return NewRedisStore(config.RedisURL, config.RedisPrefix)
+	default:
+		return NewFileStore(config.FilePath, config.AOFPath, fsync), nil
+	}
+}
+
+// decodeStoredValue 解码后端中以 JSON 保存的值，IP 列表以字符串数组保存，恢复为 net.IP
+func decodeStoredValue(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if ipsStr := convertToStringSlice(value); len(ipsStr) > 0 {
+		var ips []net.IP
+		for _, ipStr := range ipsStr {
+			if ip := net.ParseIP(ipStr); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) > 0 {
+			return ips, nil
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,205 @@
+package dnscache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maxHitDomains 保存访问统计时最多保留的域名数
+	maxHitDomains = 10000
+	// DefaultWarmupConcurrency 预热时默认的并发解析数
+	DefaultWarmupConcurrency = 8
+)
+
+// hitStats 按域名统计解析请求次数，随缓存一起定期保存，用于启动时预热最常访问的域名
+type hitStats struct {
+	path   string
+	counts sync.Map // domain -> *atomic.Int64
+	dirty  atomic.Bool
+}
+
+func newHitStats(path string) *hitStats {
+	h := &hitStats{path: path}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("警告: 读取访问统计文件失败: %v\n", err)
+		}
+		return h
+	}
+	var stored map[string]int64
+	if err := json.Unmarshal(data, &stored); err != nil {
+		fmt.Printf("警告: 解析访问统计文件失败: %v\n", err)
+		return h
+	}
+	for domain, n := range stored {
+		count := new(atomic.Int64)
+		count.Store(n)
+		h.counts.Store(domain, count)
+	}
+	return h
+}
+
+func (h *hitStats) record(domain string) {
+	count, ok := h.counts.Load(domain)
+	if !ok {
+		count, _ = h.counts.LoadOrStore(domain, new(atomic.Int64))
+	}
+	count.(*atomic.Int64).Add(1)
+	h.dirty.Store(true)
+}
+
+type domainCount struct {
+	domain string
+	count  int64
+}
+
+// sorted 按访问次数从多到少排序，次数相同按域名排序
+func (h *hitStats) sorted() []domainCount {
+	var all []domainCount
+	h.counts.Range(func(k, v interface{}) bool {
+		all = append(all, domainCount{k.(string), v.(*atomic.Int64).Load()})
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].count != all[j].count {
+			return all[i].count > all[j].count
+		}
+		return all[i].domain < all[j].domain
+	})
+	return all
+}
+
+// save 有新的访问记录时写入文件，只保留访问最多的 maxHitDomains 个域名
+func (h *hitStats) save() error {
+	if h.path == "" || !h.dirty.Swap(false) {
+		return nil
+	}
+	all := h.sorted()
+	if len(all) > maxHitDomains {
+		for _, c := range all[maxHitDomains:] {
+			h.counts.Delete(c.domain)
+		}
+		all = all[:maxHitDomains]
+	}
+	stored := make(map[string]int64, len(all))
+	for _, c := range all {
+		stored[c.domain] = c.count
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.path, data)
+}
+
+// warmupContextKey 标记预热发起的解析，不计入访问统计
+type warmupContextKey struct{}
+
+func isWarmup(ctx context.Context) bool {
+	return ctx.Value(warmupContextKey{}) != nil
+}
+
+// RecordHit 记录一次对域名的解析请求
+func (dc *DNSCache) RecordHit(domain string) {
+	if dc.hits == nil {
+		return
+	}
+	dc.hits.record(normalizeDomain(domain))
+}
+
+// TopDomains 返回访问次数最多的 n 个域名
+func (dc *DNSCache) TopDomains(n int) []string {
+	if dc.hits == nil || n <= 0 {
+		return nil
+	}
+	all := dc.hits.sorted()
+	if len(all) > n {
+		all = all[:n]
+	}
+	domains := make([]string, len(all))
+	for i, c := range all {
+		domains[i] = c.domain
+	}
+	return domains
+}
+
+// ReadWarmupFile 读取预热域名列表，每行一个域名，忽略空行和 # 开头的注释
+func ReadWarmupFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开预热文件失败: %w", err)
+	}
+	defer file.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line != "" {
+			domains = append(domains, normalizeDomain(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取预热文件失败: %w", err)
+	}
+	return domains, nil
+}
+
+// Warmup 以有限并发解析一组域名，把结果写入缓存，返回成功和失败的个数
+// 重复的域名只解析一次，预热的解析不计入访问统计
+func Warmup(ctx context.Context, resolver NameResolver, domains []string, concurrency int) (resolved, failed int) {
+	if concurrency <= 0 {
+		concurrency = DefaultWarmupConcurrency
+	}
+	ctx = context.WithValue(ctx, warmupContextKey{}, true)
+
+	var (
+		wg        sync.WaitGroup
+		sem       = make(chan struct{}, concurrency)
+		seen      = make(map[string]bool, len(domains))
+		okCount   atomic.Int64
+		failCount atomic.Int64
+	)
+	for _, domain := range domains {
+		domain = normalizeDomain(domain)
+		if domain == "" || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return int(okCount.Load()), int(failCount.Load())
+		}
+		wg.Add(1)
+		go func(domain string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if _, err := resolver.LookupIP(ctx, "tcp", domain); err != nil {
+				fmt.Printf("预热解析 %s 失败: %v\n", domain, err)
+				failCount.Add(1)
+				return
+			}
+			okCount.Add(1)
+		}(domain)
+	}
+	wg.Wait()
+	return int(okCount.Load()), int(failCount.Load())
+}
@@ -0,0 +1,136 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingResolver 记录解析次数和最大并发数
+type countingResolver struct {
+	mu       sync.Mutex
+	calls    map[string]int
+	inflight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	ips, err := r.LookupIP(ctx, "ip", name)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return ctx, ips[0], nil
+}
+
+func (r *countingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	n := r.inflight.Add(1)
+	defer r.inflight.Add(-1)
+	for {
+		peak := r.peak.Load()
+		if n <= peak || r.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	r.mu.Lock()
+	r.calls[host]++
+	r.mu.Unlock()
+	return []net.IP{net.ParseIP("192.0.2.1")}, nil
+}
+
+func TestWarmupFromHitStats(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.HitsPath = filepath.Join(dir, "hits.json")
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	origin := &countingResolver{calls: make(map[string]int)}
+	resolver := NewCachingResolver(origin, cache)
+	for i, domain := range []string{"a.example", "b.example", "c.example"} {
+		for j := 0; j <= i; j++ {
+			resolver.LookupIP(context.Background(), "tcp", domain)
+		}
+	}
+	cache.Close()
+
+	// 重启后访问统计从文件恢复
+	cache, err = NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	defer cache.Close()
+	top := cache.TopDomains(2)
+	if len(top) != 2 || top[0] != "c.example" || top[1] != "b.example" {
+		t.Fatalf("Expected [c.example b.example], got %v", top)
+	}
+
+	listPath := filepath.Join(dir, "warmup.txt")
+	os.WriteFile(listPath, []byte("# 预热列表\nD.example.\n\nc.example  # 重复\n"), 0644)
+	list, err := ReadWarmupFile(listPath)
+	if err != nil {
+		t.Fatalf("Failed to read warmup file: %v", err)
+	}
+
+	cache.Flush()
+	origin = &countingResolver{calls: make(map[string]int)}
+	resolver = NewCachingResolver(origin, cache)
+	domains := append(list, top...)
+	resolved, failed := Warmup(context.Background(), resolver, domains, 2)
+	if resolved != 3 || failed != 0 {
+		t.Errorf("Expected 3 resolved and 0 failed, got %d and %d", resolved, failed)
+	}
+	if peak := origin.peak.Load(); peak > 2 {
+		t.Errorf("Expected at most 2 concurrent lookups, got %d", peak)
+	}
+	if _, found := cache.Get("lookupip", "tcp:d.example"); !found {
+		t.Error("Expected warmed domain to be cached")
+	}
+	// 预热不计入访问统计
+	if top := cache.TopDomains(1); top[0] != "c.example" {
+		t.Errorf("Expected warmup not to change hit ranking, got %v", top)
+	}
+	if got := cache.TopDomains(10); len(got) != 3 {
+		t.Errorf("Expected warmup not to add domains to hit stats, got %v", got)
+	}
+}
+
+func TestCachingResolverRecordsHitOnce(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	config.HitsPath = filepath.Join(dir, "hits.json")
+	cache, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer cache.Close()
+
+	resolver := NewCachingResolver(&countingResolver{calls: make(map[string]int)}, cache)
+	// SOCKS5 服务器先 Resolve，再用返回的 ctx 拨号，拨号时又会 LookupIP 同一个域名
+	ctx, _, err := resolver.Resolve(context.Background(), "a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver.LookupIP(ctx, "tcp", "a.example")
+	resolver.LookupIP(ctx, "tcp", "b.example")
+	resolver.LookupIP(context.Background(), "tcp", "a.example")
+
+	counts := make(map[string]int64)
+	for _, c := range cache.hits.sorted() {
+		counts[c.domain] = c.count
+	}
+	if counts["a.example"] != 2 || counts["b.example"] != 1 {
+		t.Errorf("Expected a.example counted twice and b.example once, got %v", counts)
+	}
+}
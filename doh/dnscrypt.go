@@ -0,0 +1,111 @@
+package doh
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/miekg/dns"
+)
+
+// dnscryptClientEntry 缓存的 DNSCrypt 客户端条目
+// 证书获取和共享密钥计算只在首次查询或证书过期时进行，后续查询直接复用
+type dnscryptClientEntry struct {
+	mu       sync.Mutex
+	client   *dnscrypt.Client
+	resolver *dnscrypt.ResolverInfo
+}
+
+// dnscryptClientCache 缓存按 stamp 为 key 的 DNSCrypt 客户端
+var dnscryptClientCache sync.Map
+
+// getResolverInfo 返回有效的 resolver 信息，证书即将过期时重新获取
+func (e *dnscryptClientEntry) getResolverInfo(stamp string) (*dnscrypt.ResolverInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.resolver != nil && e.resolver.ResolverCert != nil &&
+		time.Now().Add(time.Minute).Unix() < int64(e.resolver.ResolverCert.NotAfter) {
+		return e.resolver, nil
+	}
+	ri, err := e.client.Dial(stamp)
+	if err != nil {
+		log.Println("DNSCrypt获取证书失败", err)
+		return nil, err
+	}
+	log.Println("DNSCrypt获取证书成功", ri.ProviderName, ri.ServerAddress)
+	e.resolver = ri
+	return ri, nil
+}
+
+// reset 丢弃缓存的证书，下次查询时重新获取
+func (e *dnscryptClientEntry) reset() {
+	e.mu.Lock()
+	e.resolver = nil
+	e.mu.Unlock()
+}
+
+// dnscryptClientCached 使用缓存的 DNSCrypt 客户端执行查询
+func dnscryptClientCached(msg *dns.Msg, stamp string) (*dns.Msg, error) {
+	v, _ := dnscryptClientCache.LoadOrStore(stamp, &dnscryptClientEntry{
+		client: &dnscrypt.Client{Net: "udp", Timeout: 10 * time.Second},
+	})
+	entry := v.(*dnscryptClientEntry)
+
+	ri, err := entry.getResolverInfo(stamp)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := entry.client.Exchange(msg, ri)
+	if err == nil && resp.Truncated {
+		// UDP 响应被截断，改用 TCP 重试
+		tcpClient := &dnscrypt.Client{Net: "tcp", Timeout: 10 * time.Second}
+		resp, err = tcpClient.Exchange(msg, ri)
+	}
+	if err != nil {
+		// 可能是服务器轮换了证书，丢弃缓存以便下次重新获取
+		entry.reset()
+		log.Println(ri.ProviderName, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func DNSCryptnslookup(domain string, dnstype string, stamp string) ([]*dns.Msg, []error) {
+	log.Println("domain:", domain, "dnstype:", dnstype, "dnscrypt:", stamp)
+	var errs = make([]error, 0)
+	var results = make([]*dns.Msg, 0)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, d := range strings.Split(domain, ",") {
+		for _, t := range strings.Split(dnstype, ",") {
+			wg.Add(1)
+			go func(d string, t string) {
+				defer wg.Done()
+				var msg = &dns.Msg{}
+				msg.SetQuestion(d+".", dns.StringToType[t])
+				applyECS(msg, ECSSubnet(nil))
+				msg.RecursionDesired = true
+
+				res, err := dnscryptClientCached(msg, stamp)
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+				results = append(results, res)
+			}(d, t)
+		}
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// ResolveDomainToIPsWithDNSCrypt 通过 DNSCrypt 服务器查询 A 和 AAAA 记录
+func ResolveDomainToIPsWithDNSCrypt(domain string, stamp string) ([]net.IP, []error) {
+	responses, errs := DNSCryptnslookup(domain, "A,AAAA", stamp)
+	return collectIPs(domain, responses, errs)
+}
@@ -0,0 +1,53 @@
+package doh
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/miekg/dns"
+)
+
+// dnscryptTestHandler 对 A 查询回答 192.0.2.1，其余类型返回空应答
+type dnscryptTestHandler struct{}
+
+func (dnscryptTestHandler) ServeDNS(rw dnscrypt.ResponseWriter, r *dns.Msg) error {
+	if r.Question[0].Qtype == dns.TypeA {
+		return rw.WriteMsg(testAnswer(r))
+	}
+	m := new(dns.Msg)
+	m.SetReply(r)
+	return rw.WriteMsg(m)
+}
+
+// 使用本地 DNSCrypt 服务器生成的 sdns:// stamp 解析域名
+func TestResolveDomainToIPsWithDNSCrypt(t *testing.T) {
+	rc, err := dnscrypt.GenerateResolverConfig("example.test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := rc.CreateCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dnscrypt.Server{ProviderName: rc.ProviderName, ResolverCert: cert, Handler: dnscryptTestHandler{}}
+	go server.ServeUDP(l)
+	defer server.Shutdown(context.Background())
+
+	stamp, err := rc.CreateStamp(l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, errs := ResolveDomainToIPsWithDNSCrypt("www.example.org", stamp.String())
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected [192.0.2.1], got %v", ips)
+	}
+}
@@ -0,0 +1,448 @@
+package doh
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/miekg/dns"
+)
+
+// DNSSECStatus DNSSEC 验证结果
+type DNSSECStatus string
+
+const (
+	// DNSSECSecure 应答的信任链从根信任锚开始完整验证通过
+	DNSSECSecure DNSSECStatus = "secure"
+	// DNSSECInsecure 应答未签名，但已证明其所在区为未签名委派
+	DNSSECInsecure DNSSECStatus = "insecure"
+)
+
+// ErrDNSSECBogus 应答签名无效、被剥离或信任链断裂
+var ErrDNSSECBogus = errors.New("dnssec bogus")
+
+// DefaultDNSSECTrustAnchors 默认的根区信任锚（KSK-2017 与 KSK-2024 的 DS 记录）
+var DefaultDNSSECTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// dnssecInsecureTTL 未签名委派证明的缓存时间
+const dnssecInsecureTTL = 10 * time.Minute
+
+// dnssecMaxDepth 信任链递归的最大深度，防止恶意应答造成无限递归
+const dnssecMaxDepth = 16
+
+type dnssecKeyEntry struct {
+	keys    []*dns.DNSKEY
+	expires time.Time
+}
+
+// dnssecValidator 从根信任锚开始逐级验证 DNSKEY/DS 信任链
+// 已验证的区密钥与未签名委派证明会被缓存，与具体的上游服务器无关
+type dnssecValidator struct {
+	anchors  []*dns.DS
+	keys     sync.Map // 区名 -> *dnssecKeyEntry
+	insecure sync.Map // 区名 -> time.Time（证明过期时间）
+}
+
+// dnssecExchange 发送 DNS 查询的函数
+type dnssecExchange func(msg *dns.Msg) (*dns.Msg, error)
+
+var dnssecState atomic.Pointer[dnssecValidator]
+
+// EnableDNSSEC 启用 DNSSEC 验证，trustAnchors 为区文件格式的根区 DS 记录，
+// 为空时使用 DefaultDNSSECTrustAnchors
+func EnableDNSSEC(trustAnchors []string) error {
+	v, err := newDNSSECValidator(trustAnchors)
+	if err != nil {
+		return err
+	}
+	dnssecState.Store(v)
+	log.Printf("DNSSEC 验证已启用，信任锚数量: %d", len(v.anchors))
+	return nil
+}
+
+// DNSSECEnabled 返回是否启用了 DNSSEC 验证
+func DNSSECEnabled() bool {
+	return dnssecState.Load() != nil
+}
+
+func newDNSSECValidator(trustAnchors []string) (*dnssecValidator, error) {
+	if len(trustAnchors) == 0 {
+		trustAnchors = DefaultDNSSECTrustAnchors
+	}
+	v := &dnssecValidator{}
+	for _, s := range trustAnchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dnssec trust anchor %q: %v", s, err)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok || ds.Hdr.Name != "." {
+			return nil, fmt.Errorf("dnssec trust anchor must be a DS record for the root zone: %q", s)
+		}
+		v.anchors = append(v.anchors, ds)
+	}
+	return v, nil
+}
+
+func bogus(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrDNSSECBogus, fmt.Sprintf(format, args...))
+}
+
+// query 发送带 DO 标志的查询
+func (v *dnssecValidator) query(ex dnssecExchange, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = true
+	msg.SetEdns0(4096, true)
+	return ex(msg)
+}
+
+// extractRRset 从记录列表中取出指定名称和类型的 RRset 及覆盖它的签名
+func extractRRset(rrs []dns.RR, name string, qtype uint16) ([]dns.RR, []*dns.RRSIG) {
+	name = dns.CanonicalName(name)
+	var rrset []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range rrs {
+		if dns.CanonicalName(rr.Header().Name) != name {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == qtype {
+				sigs = append(sigs, sig)
+			}
+			continue
+		}
+		if rr.Header().Rrtype == qtype {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset, sigs
+}
+
+// verifyRRset 使用签名者区的已验证密钥校验 RRset
+func (v *dnssecValidator) verifyRRset(ex dnssecExchange, rrset []dns.RR, sigs []*dns.RRSIG, depth int) error {
+	if len(rrset) == 0 {
+		return bogus("empty rrset")
+	}
+	owner := rrset[0].Header().Name
+	if len(sigs) == 0 {
+		return bogus("%s %s is not signed", owner, dns.TypeToString[rrset[0].Header().Rrtype])
+	}
+	var lastErr error
+	now := time.Now()
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(now) {
+			lastErr = bogus("rrsig for %s outside validity period", owner)
+			continue
+		}
+		if !dns.IsSubDomain(sig.SignerName, owner) {
+			lastErr = bogus("signer %s is not an ancestor of %s", sig.SignerName, owner)
+			continue
+		}
+		keys, err := v.zoneKeys(ex, sig.SignerName, depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, k := range keys {
+			if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+				continue
+			}
+			if err := sig.Verify(k, rrset); err != nil {
+				lastErr = bogus("rrsig verify failed for %s: %v", owner, err)
+				continue
+			}
+			return nil
+		}
+		if lastErr == nil {
+			lastErr = bogus("no matching DNSKEY for rrsig of %s", owner)
+		}
+	}
+	return lastErr
+}
+
+// zoneKeys 返回区的已验证 DNSKEY 集合，沿 DS 记录向上验证直到根信任锚
+func (v *dnssecValidator) zoneKeys(ex dnssecExchange, zone string, depth int) ([]*dns.DNSKEY, error) {
+	zone = dns.CanonicalName(zone)
+	if depth > dnssecMaxDepth {
+		return nil, bogus("chain of trust too deep at %s", zone)
+	}
+	if cached, ok := v.keys.Load(zone); ok {
+		entry := cached.(*dnssecKeyEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.keys, nil
+		}
+		v.keys.Delete(zone)
+	}
+
+	resp, err := v.query(ex, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	keyRRs, keySigs := extractRRset(resp.Answer, zone, dns.TypeDNSKEY)
+	if len(keyRRs) == 0 {
+		return nil, bogus("no DNSKEY for %s", zone)
+	}
+
+	// 确定该区的 DS：根区使用信任锚，其余区的 DS 由父区签名
+	var dsSet []*dns.DS
+	if zone == "." {
+		dsSet = v.anchors
+	} else {
+		dsResp, err := v.query(ex, zone, dns.TypeDS)
+		if err != nil {
+			return nil, err
+		}
+		dsRRs, dsSigs := extractRRset(dsResp.Answer, zone, dns.TypeDS)
+		if len(dsRRs) == 0 {
+			return nil, bogus("no DS for signed zone %s", zone)
+		}
+		var parentSigs []*dns.RRSIG
+		for _, sig := range dsSigs {
+			if dns.CanonicalName(sig.SignerName) != zone {
+				parentSigs = append(parentSigs, sig)
+			}
+		}
+		if err := v.verifyRRset(ex, dsRRs, parentSigs, depth); err != nil {
+			return nil, err
+		}
+		for _, rr := range dsRRs {
+			dsSet = append(dsSet, rr.(*dns.DS))
+		}
+	}
+
+	// 找到与 DS 匹配的密钥（安全入口点）
+	var keys []*dns.DNSKEY
+	var entryPoints []*dns.DNSKEY
+	for _, rr := range keyRRs {
+		k := rr.(*dns.DNSKEY)
+		keys = append(keys, k)
+		for _, ds := range dsSet {
+			kds := k.ToDS(ds.DigestType)
+			if kds != nil && kds.KeyTag == ds.KeyTag && k.Algorithm == ds.Algorithm && strings.EqualFold(kds.Digest, ds.Digest) {
+				entryPoints = append(entryPoints, k)
+				break
+			}
+		}
+	}
+	if len(entryPoints) == 0 {
+		return nil, bogus("no DNSKEY of %s matches its DS", zone)
+	}
+
+	// 用安全入口点校验整个 DNSKEY 集合
+	now := time.Now()
+	for _, sig := range keySigs {
+		if !sig.ValidityPeriod(now) || dns.CanonicalName(sig.SignerName) != zone {
+			continue
+		}
+		for _, k := range entryPoints {
+			if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+				continue
+			}
+			if sig.Verify(k, keyRRs) != nil {
+				continue
+			}
+			expires := now.Add(time.Duration(keyRRs[0].Header().Ttl) * time.Second)
+			if sigExpires := time.Unix(int64(sig.Expiration), 0); sigExpires.Before(expires) {
+				expires = sigExpires
+			}
+			v.keys.Store(zone, &dnssecKeyEntry{keys: keys, expires: expires})
+			return keys, nil
+		}
+	}
+	return nil, bogus("DNSKEY rrset of %s is not signed by a trusted key", zone)
+}
+
+// hasType 判断 NSEC/NSEC3 类型位图中是否包含指定类型
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyNoDS 检查 DS 查询的否定应答中经过签名的 NSEC/NSEC3 记录，
+// 返回是否证明了 zone 处没有 DS，以及 zone 是否为委派点
+func (v *dnssecValidator) verifyNoDS(ex dnssecExchange, resp *dns.Msg, zone string, depth int) (bool, bool, error) {
+	for _, rr := range resp.Ns {
+		switch r := rr.(type) {
+		case *dns.NSEC:
+			if dns.CanonicalName(r.Hdr.Name) != zone {
+				continue
+			}
+			rrset, sigs := extractRRset(resp.Ns, r.Hdr.Name, dns.TypeNSEC)
+			if err := v.verifyRRset(ex, rrset, sigs, depth); err != nil {
+				return false, false, err
+			}
+			if hasType(r.TypeBitMap, dns.TypeDS) {
+				return false, false, bogus("NSEC for %s claims DS exists", zone)
+			}
+			return true, hasType(r.TypeBitMap, dns.TypeNS) && !hasType(r.TypeBitMap, dns.TypeSOA), nil
+		case *dns.NSEC3:
+			match := r.Match(zone)
+			optOut := !match && r.Flags&1 == 1 && r.Cover(zone)
+			if !match && !optOut {
+				continue
+			}
+			rrset, sigs := extractRRset(resp.Ns, r.Hdr.Name, dns.TypeNSEC3)
+			if err := v.verifyRRset(ex, rrset, sigs, depth); err != nil {
+				return false, false, err
+			}
+			if optOut {
+				// opt-out 范围内的委派不签名，视为未签名委派
+				return true, true, nil
+			}
+			if hasType(r.TypeBitMap, dns.TypeDS) {
+				return false, false, bogus("NSEC3 for %s claims DS exists", zone)
+			}
+			return true, hasType(r.TypeBitMap, dns.TypeNS) && !hasType(r.TypeBitMap, dns.TypeSOA), nil
+		}
+	}
+	return false, false, nil
+}
+
+// proveInsecure 证明未签名的名称位于未签名委派之下，否则视为签名被剥离
+func (v *dnssecValidator) proveInsecure(ex dnssecExchange, name string) error {
+	labels := dns.SplitDomainName(name)
+	now := time.Now()
+	for i := range labels {
+		zone := dns.CanonicalName(strings.Join(labels[i:], "."))
+		if expires, ok := v.insecure.Load(zone); ok && now.Before(expires.(time.Time)) {
+			return nil
+		}
+	}
+	for i := range labels {
+		zone := dns.CanonicalName(strings.Join(labels[i:], "."))
+		resp, err := v.query(ex, zone, dns.TypeDS)
+		if err != nil {
+			return err
+		}
+		dsRRs, dsSigs := extractRRset(resp.Answer, zone, dns.TypeDS)
+		if len(dsRRs) > 0 {
+			if err := v.verifyRRset(ex, dsRRs, dsSigs, 0); err != nil {
+				return err
+			}
+			return bogus("zone %s is signed but %s is unsigned", zone, name)
+		}
+		proved, delegation, err := v.verifyNoDS(ex, resp, zone, 0)
+		if err != nil {
+			return err
+		}
+		if proved && delegation {
+			v.insecure.Store(zone, now.Add(dnssecInsecureTTL))
+			return nil
+		}
+	}
+	return bogus("no proof of insecure delegation for %s", name)
+}
+
+// validateResponse 校验应答中每一个 RRset，返回整体验证结果
+// 否定应答（NXDOMAIN/NODATA）不包含地址，不做验证
+func (v *dnssecValidator) validateResponse(ex dnssecExchange, resp *dns.Msg) (DNSSECStatus, error) {
+	type rrsetKey struct {
+		name  string
+		rtype uint16
+	}
+	var order []rrsetKey
+	seen := map[rrsetKey]bool{}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		key := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		if !seen[key] {
+			seen[key] = true
+			order = append(order, key)
+		}
+	}
+	status := DNSSECSecure
+	for _, key := range order {
+		rrset, sigs := extractRRset(resp.Answer, key.name, key.rtype)
+		if len(sigs) == 0 {
+			if err := v.proveInsecure(ex, key.name); err != nil {
+				return "", err
+			}
+			status = DNSSECInsecure
+			continue
+		}
+		if err := v.verifyRRset(ex, rrset, sigs, 0); err != nil {
+			return "", err
+		}
+	}
+	return status, nil
+}
+
+// ResolveDomainToIPsWithOptionStatus 查询 A 和 AAAA 记录并进行 DNSSEC 验证
+// 未启用 DNSSEC 时等同于 ResolveDomainToIPsWithOption，状态为空
+func ResolveDomainToIPsWithOptionStatus(domain string, opt options.ProxyOptionDNS, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) ([]net.IP, DNSSECStatus, []error) {
+	v := dnssecState.Load()
+	if v == nil {
+		ips, errs := ResolveDomainToIPsWithOption(domain, opt, Proxy, tranportConfigurations...)
+		return ips, "", errs
+	}
+	ex := func(msg *dns.Msg) (*dns.Msg, error) {
+		return ExchangeWithOption(msg, opt, Proxy, tranportConfigurations...)
+	}
+
+	var errs = make([]error, 0)
+	var results = make([]*dns.Msg, 0)
+	var status = DNSSECSecure
+	var isBogus bool
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		wg.Add(1)
+		go func(t uint16) {
+			defer wg.Done()
+			msg := new(dns.Msg)
+			msg.SetQuestion(dns.Fqdn(domain), t)
+			msg.RecursionDesired = true
+			msg.SetEdns0(4096, true)
+			applyECS(msg, ecsSubnetFromString(opt.ClientSubnet))
+			resp, err := ex(msg)
+			var st DNSSECStatus
+			if err == nil {
+				st, err = v.validateResponse(ex, resp)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if errors.Is(err, ErrDNSSECBogus) {
+					log.Println("DNSSEC 验证失败", domain, opt.ServerURL(), err)
+					isBogus = true
+				}
+				errs = append(errs, err)
+				return
+			}
+			if st == DNSSECInsecure {
+				status = DNSSECInsecure
+			}
+			results = append(results, resp)
+		}(t)
+	}
+	wg.Wait()
+
+	// 任何一个应答被判定为伪造时，整个解析结果都不可信
+	if isBogus {
+		return nil, "", errs
+	}
+	ips, ipErrs := collectIPs(domain, results, errs)
+	if len(ips) == 0 {
+		return nil, "", ipErrs
+	}
+	log.Println("DNSSEC", status, domain)
+	return ips, status, nil
+}
@@ -0,0 +1,132 @@
+package doh
+
+import (
+	"crypto"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type testZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return &testZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+func (z *testZone) sign(t *testing.T, rrset ...dns.RR) []dns.RR {
+	t.Helper()
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  z.key.Algorithm,
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+	}
+	if err := sig.Sign(z.priv, rrset); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return append(rrset, sig)
+}
+
+func testRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return rr
+}
+
+// newTestDNSSECChain 构造根区 -> example.（已签名）与 unsigned.（未签名委派）的信任链
+func newTestDNSSECChain(t *testing.T) (*dnssecValidator, *testZone, map[string][]dns.RR, map[string][]dns.RR) {
+	root := newTestZone(t, ".")
+	child := newTestZone(t, "example.")
+	answers := map[string][]dns.RR{}
+	authority := map[string][]dns.RR{}
+
+	answers[". DNSKEY"] = root.sign(t, root.key)
+	answers["example. DNSKEY"] = child.sign(t, child.key)
+	answers["example. DS"] = root.sign(t, child.key.ToDS(dns.SHA256))
+	authority["unsigned. DS"] = root.sign(t, testRR(t, "unsigned. 3600 IN NSEC zz. NS RRSIG NSEC"))
+
+	v, err := newDNSSECValidator([]string{root.key.ToDS(dns.SHA256).String()})
+	if err != nil {
+		t.Fatalf("newDNSSECValidator: %v", err)
+	}
+	return v, child, answers, authority
+}
+
+func testExchange(answers, authority map[string][]dns.RR) dnssecExchange {
+	return func(msg *dns.Msg) (*dns.Msg, error) {
+		q := msg.Question[0]
+		key := q.Name + " " + dns.TypeToString[q.Qtype]
+		resp := new(dns.Msg)
+		resp.SetReply(msg)
+		resp.Answer = answers[key]
+		resp.Ns = authority[key]
+		return resp, nil
+	}
+}
+
+func TestDNSSECValidateResponse(t *testing.T) {
+	v, child, answers, authority := newTestDNSSECChain(t)
+	ex := testExchange(answers, authority)
+
+	reply := func(answer ...dns.RR) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetQuestion(answer[0].Header().Name, answer[0].Header().Rrtype)
+		resp.Response = true
+		resp.Answer = answer
+		return resp
+	}
+
+	t.Run("secure", func(t *testing.T) {
+		status, err := v.validateResponse(ex, reply(child.sign(t, testRR(t, "www.example. 300 IN A 192.0.2.1"))...))
+		if err != nil || status != DNSSECSecure {
+			t.Errorf("Expected secure, got %q (err=%v)", status, err)
+		}
+	})
+
+	t.Run("篡改的应答", func(t *testing.T) {
+		signed := child.sign(t, testRR(t, "www.example. 300 IN A 192.0.2.1"))
+		signed[0].(*dns.A).A = net.ParseIP("203.0.113.66")
+		_, err := v.validateResponse(ex, reply(signed...))
+		if !errors.Is(err, ErrDNSSECBogus) {
+			t.Errorf("Expected bogus, got %v", err)
+		}
+	})
+
+	t.Run("签名被剥离", func(t *testing.T) {
+		_, err := v.validateResponse(ex, reply(testRR(t, "www.example. 300 IN A 192.0.2.1")))
+		if !errors.Is(err, ErrDNSSECBogus) {
+			t.Errorf("Expected bogus, got %v", err)
+		}
+	})
+
+	t.Run("未签名委派", func(t *testing.T) {
+		status, err := v.validateResponse(ex, reply(testRR(t, "www.unsigned. 300 IN A 192.0.2.2")))
+		if err != nil || status != DNSSECInsecure {
+			t.Errorf("Expected insecure, got %q (err=%v)", status, err)
+		}
+	})
+}
@@ -29,6 +29,7 @@ func Dohnslookup(domain string, dnstype string, dohurl string, dohip string, Pro
 				log.Println("domain:", d, "dnstype:", t, "dohurl:", dohurl)
 				var msg = &dns.Msg{}
 				msg.SetQuestion(d+".", dns.StringToType[t])
+				applyECS(msg, ECSSubnet(nil))
 				// log.Println(msg.String())
 
 				res, err := dns_experiment.DohClient(msg, dohurl, dohip, Proxy, tranportConfigurations...)
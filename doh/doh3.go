@@ -9,11 +9,13 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/masx200/http-proxy-go-server/options"
 	"github.com/miekg/dns"
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
@@ -171,7 +173,14 @@ func createH3Client(dohurl string, dohip string) *h3ClientEntry {
 		createdAt: time.Now(),
 	}
 
-	udpConn, err := net.ListenUDP("udp", nil)
+	// 按 DoH 服务器选择出站配置（源地址、网卡、防火墙标记）
+	serverHost := dohip
+	if serverHost == "" {
+		if u, err := url.Parse(dohurl); err == nil {
+			serverHost = u.Hostname()
+		}
+	}
+	udpConn, err := options.ListenPacket(context.Background(), "udp", net.JoinHostPort(serverHost, "443"))
 	if err != nil {
 		// 降级：返回一个不含 quic.Transport 的基础 entry
 		log.Println("H3客户端：创建UDP socket失败", err)
@@ -316,6 +325,7 @@ func Doh3nslookup(domain string, dnstype string, dohurl string, dohip ...string)
 				log.Println("domain:", d, "dnstype:", t, "dohurl:", dohurl)
 				var msg = &dns.Msg{}
 				msg.SetQuestion(d+".", dns.StringToType[t])
+				applyECS(msg, ECSSubnet(nil))
 
 				// 使用缓存的 H3 客户端，不再每次创建新的 quic.Transport
 				res, err := doHTTP3ClientCached(msg, dohurl, dohip...)
@@ -0,0 +1,274 @@
+package doh
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqClientEntry 缓存的 DoQ 客户端条目，持有可复用的 QUIC 连接
+// 每个查询使用连接上的一个独立流（RFC 9250），多个查询可并发复用同一连接
+type doqClientEntry struct {
+	addr       string // 实际拨号地址 host:port
+	serverName string // TLS SNI
+	mu         sync.Mutex
+	conn       *quic.Conn
+	closed     atomic.Bool
+}
+
+// Close 关闭 DoQ 客户端条目及其连接
+func (e *doqClientEntry) Close() {
+	if e.closed.Swap(true) {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.CloseWithError(0, "")
+		e.conn = nil
+	}
+}
+
+// getConn 返回当前可用的 QUIC 连接，连接已断开时重新拨号
+func (e *doqClientEntry) getConn(ctx context.Context) (*quic.Conn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed.Load() {
+		return nil, errors.New("doq client closed")
+	}
+	if e.conn != nil && e.conn.Context().Err() == nil {
+		return e.conn, nil
+	}
+
+	tlsConf := &tls.Config{ServerName: e.serverName, NextProtos: []string{"doq"}, RootCAs: dnsServerRootCAs}
+	conn, err := dialQUIC(ctx, e.addr, tlsConf, &quic.Config{KeepAlivePeriod: 20 * time.Second})
+	if err != nil {
+		log.Println("DoQ连接失败", e.serverName, e.addr, err)
+		return nil, err
+	}
+	log.Println("DoQ连接成功", e.serverName, conn.LocalAddr(), conn.RemoteAddr())
+	e.conn = conn
+	return conn, nil
+}
+
+// dialQUIC 使用按出站配置（源地址、网卡、防火墙标记）创建的 UDP socket 建立 QUIC 连接，
+// 连接关闭时一并关闭 socket
+func dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config, quicConf *quic.Config) (*quic.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := options.ListenPacket(ctx, "udp", udpAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	tr := &quic.Transport{Conn: pconn}
+	conn, err := tr.Dial(ctx, udpAddr, tlsConf, quicConf)
+	if err != nil {
+		tr.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		tr.Close()
+	}()
+	return conn, nil
+}
+
+// exchangeOnConn 在指定连接上打开一个新流完成一次查询
+func exchangeOnConn(ctx context.Context, conn *quic.Conn, msg *dns.Msg) (*dns.Msg, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CancelRead(0)
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	// RFC 9250: 报文 ID 必须为 0，报文前带2字节长度
+	q := msg.Copy()
+	q.Id = 0
+	body, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2+len(body))
+	binary.BigEndian.PutUint16(buf, uint16(len(body)))
+	copy(buf[2:], body)
+	if _, err := stream.Write(buf); err != nil {
+		return nil, err
+	}
+	// 关闭写方向，告知服务器查询已发送完毕
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, data); err != nil {
+		return nil, err
+	}
+	resp := &dns.Msg{}
+	if err := resp.Unpack(data); err != nil {
+		return nil, err
+	}
+	resp.Id = msg.Id
+	return resp, nil
+}
+
+// Exchange 通过复用的 QUIC 连接执行一次查询
+// 连接被服务器关闭时自动重连并重试一次
+func (e *doqClientEntry) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, err := e.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := exchangeOnConn(ctx, conn, msg)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || conn.Context().Err() == nil {
+			// 连接仍然存活说明是本次查询自身的错误，无需重连
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// doqClientCache 缓存按 (doqurl+doqip) 为 key 的 DoQ 客户端
+var doqClientCache sync.Map
+
+// parseDoQServer 解析 DoQ 服务器地址，返回主机名和端口（默认853）
+func parseDoQServer(doqurl string) (string, string, error) {
+	if !strings.Contains(doqurl, "://") {
+		doqurl = "quic://" + doqurl
+	}
+	u, err := url.Parse(doqurl)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Hostname() == "" {
+		return "", "", fmt.Errorf("invalid doq url: %s", doqurl)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "853"
+	}
+	return u.Hostname(), port, nil
+}
+
+// getOrCreateDoQClient 获取或创建一个可复用的 DoQ 客户端
+func getOrCreateDoQClient(doqurl string, doqip string) (*doqClientEntry, error) {
+	cacheKey := doqurl + "|" + doqip
+	if v, ok := doqClientCache.Load(cacheKey); ok {
+		entry := v.(*doqClientEntry)
+		if !entry.closed.Load() {
+			return entry, nil
+		}
+		doqClientCache.Delete(cacheKey)
+	}
+
+	host, port, err := parseDoQServer(doqurl)
+	if err != nil {
+		return nil, err
+	}
+	dialHost := host
+	if doqip != "" {
+		dialHost = doqip
+	}
+	entry := &doqClientEntry{
+		addr:       net.JoinHostPort(dialHost, port),
+		serverName: host,
+	}
+	if actual, loaded := doqClientCache.LoadOrStore(cacheKey, entry); loaded {
+		return actual.(*doqClientEntry), nil
+	}
+	return entry, nil
+}
+
+// CloseDoQClientCache 关闭并清理所有 DoQ 客户端缓存
+func CloseDoQClientCache() {
+	closed := 0
+	doqClientCache.Range(func(key, value interface{}) bool {
+		value.(*doqClientEntry).Close()
+		doqClientCache.Delete(key)
+		closed++
+		return true
+	})
+	log.Printf("DoQ 客户端缓存已关闭，共清理 %d 个客户端", closed)
+}
+
+// doQClientCached 使用缓存的 DoQ 客户端执行查询
+func doQClientCached(msg *dns.Msg, doqurl string, doqip string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry, err := getOrCreateDoQClient(doqurl, doqip)
+	if err != nil {
+		log.Println(doqurl, err)
+		return nil, err
+	}
+	resp, err := entry.Exchange(ctx, msg)
+	if err != nil {
+		log.Println(doqurl, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func Doqnslookup(domain string, dnstype string, doqurl string, doqip string) ([]*dns.Msg, []error) {
+	log.Println("domain:", domain, "dnstype:", dnstype, "doqurl:", doqurl)
+	var errs = make([]error, 0)
+	var results = make([]*dns.Msg, 0)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, d := range strings.Split(domain, ",") {
+		for _, t := range strings.Split(dnstype, ",") {
+			wg.Add(1)
+			go func(d string, t string) {
+				defer wg.Done()
+				var msg = &dns.Msg{}
+				msg.SetQuestion(d+".", dns.StringToType[t])
+				applyECS(msg, ECSSubnet(nil))
+
+				res, err := doQClientCached(msg, doqurl, doqip)
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+				results = append(results, res)
+			}(d, t)
+		}
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// ResolveDomainToIPsWithDoQ 通过复用的 QUIC 连接查询 A 和 AAAA 记录
+func ResolveDomainToIPsWithDoQ(domain string, doqurl string, doqip string) ([]net.IP, []error) {
+	responses, errs := Doqnslookup(domain, "A,AAAA", doqurl, doqip)
+	return collectIPs(domain, responses, errs)
+}
@@ -0,0 +1,106 @@
+package doh
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// serveDoQStream 读取流上带长度前缀的查询并写回应答（RFC 9250）
+func serveDoQStream(stream *quic.Stream) {
+	defer stream.Close()
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, data); err != nil {
+		return
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(data); err != nil {
+		return
+	}
+	body, err := testAnswer(r).Pack()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 2+len(body))
+	binary.BigEndian.PutUint16(buf, uint16(len(body)))
+	copy(buf[2:], body)
+	stream.Write(buf)
+}
+
+// 顺序和并发的 DoQ 查询都在同一条 QUIC 连接上各开一个流
+func TestDoQClientReusesConnection(t *testing.T) {
+	tlsConf := newTestServerTLS(t)
+	tlsConf.NextProtos = []string{"doq"}
+	l, err := quic.ListenAddr("127.0.0.1:0", tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go serveDoQStream(stream)
+				}
+			}()
+		}
+	}()
+	defer CloseDoQClientCache()
+
+	doqurl := "quic://dns.example.test:" + strconv.Itoa(l.Addr().(*net.UDPAddr).Port)
+	query := func() error {
+		msg := new(dns.Msg)
+		msg.SetQuestion("www.example.org.", dns.TypeA)
+		resp, err := doQClientCached(msg, doqurl, "127.0.0.1")
+		if err != nil {
+			return err
+		}
+		if resp.Id != msg.Id || len(resp.Answer) != 1 {
+			t.Errorf("unexpected response: %v", resp)
+		}
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := query(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := query(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("Expected 1 DoQ connection, got %d", n)
+	}
+}
@@ -0,0 +1,337 @@
+package doh
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/miekg/dns"
+)
+
+// dotConn 一条持久的 DoT（DNS over TLS）连接
+// 同一连接上允许多个查询同时在途（RFC 7766 管线化），响应按报文 ID 分发
+type dotConn struct {
+	conn    *dns.Conn
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	pending map[uint16]chan *dns.Msg
+	nextID  uint16
+	err     error
+	done    chan struct{}
+	once    sync.Once
+}
+
+// fail 关闭连接并唤醒所有等待中的查询
+func (c *dotConn) fail(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.pending = map[uint16]chan *dns.Msg{}
+		c.mu.Unlock()
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// failure 返回导致连接失效的错误
+func (c *dotConn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return errors.New("dot connection closed")
+	}
+	return c.err
+}
+
+// readLoop 持续读取响应并分发给对应的查询
+func (c *dotConn) readLoop() {
+	for {
+		m, err := c.conn.ReadMsg()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		ch := c.pending[m.Id]
+		delete(c.pending, m.Id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+	}
+}
+
+// exchange 在该连接上发送一个查询并等待响应
+func (c *dotConn) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	q := msg.Copy()
+	ch := make(chan *dns.Msg, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	// 分配一个当前连接上未被占用的报文 ID
+	for {
+		c.nextID++
+		if _, used := c.pending[c.nextID]; !used {
+			break
+		}
+	}
+	id := c.nextID
+	q.Id = id
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+	}
+	err := c.conn.WriteMsg(q)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		resp.Id = msg.Id
+		return resp, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.failure()
+	}
+}
+
+// dotClientEntry 缓存的 DoT 客户端条目，持有可复用的 TLS 连接
+type dotClientEntry struct {
+	addr       string // 实际拨号地址 host:port
+	serverName string // TLS SNI
+	mu         sync.Mutex
+	conn       *dotConn
+	closed     atomic.Bool
+}
+
+// Close 关闭 DoT 客户端条目及其连接
+func (e *dotClientEntry) Close() {
+	if e.closed.Swap(true) {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.fail(errors.New("dot client closed"))
+		e.conn = nil
+	}
+}
+
+// getConn 返回当前可用的连接，连接已失效时重新拨号
+func (e *dotClientEntry) getConn(ctx context.Context) (*dotConn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed.Load() {
+		return nil, errors.New("dot client closed")
+	}
+	if e.conn != nil {
+		select {
+		case <-e.conn.done:
+		default:
+			return e.conn, nil
+		}
+	}
+
+	dialer := &tls.Dialer{NetDialer: options.NewDialer("tcp", e.addr), Config: &tls.Config{ServerName: e.serverName, RootCAs: dnsServerRootCAs}}
+	raw, err := dialer.DialContext(ctx, "tcp", e.addr)
+	if err != nil {
+		log.Println("DoT连接失败", e.serverName, e.addr, err)
+		return nil, err
+	}
+	log.Println("DoT连接成功", e.serverName, raw.LocalAddr(), raw.RemoteAddr())
+	c := &dotConn{
+		conn:    &dns.Conn{Conn: raw},
+		pending: map[uint16]chan *dns.Msg{},
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	e.conn = c
+	return c, nil
+}
+
+// Exchange 通过复用的 TLS 连接执行一次查询
+// 连接被服务器关闭时自动重连并重试一次
+func (e *dotClientEntry) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		c, err := e.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.exchange(ctx, msg)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// dotClientCache 缓存按 (doturl+dotip) 为 key 的 DoT 客户端
+var dotClientCache sync.Map
+
+// dnsServerRootCAs 验证 DoT 和 DoQ 服务器证书的根证书，nil 时使用系统根证书，测试中替换
+var dnsServerRootCAs *x509.CertPool
+
+// parseDoTServer 解析 DoT 服务器地址，返回主机名和端口（默认853）
+func parseDoTServer(doturl string) (string, string, error) {
+	if !strings.Contains(doturl, "://") {
+		doturl = "tls://" + doturl
+	}
+	u, err := url.Parse(doturl)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Hostname() == "" {
+		return "", "", fmt.Errorf("invalid dot url: %s", doturl)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "853"
+	}
+	return u.Hostname(), port, nil
+}
+
+// getOrCreateDoTClient 获取或创建一个可复用的 DoT 客户端
+func getOrCreateDoTClient(doturl string, dotip string) (*dotClientEntry, error) {
+	cacheKey := doturl + "|" + dotip
+	if v, ok := dotClientCache.Load(cacheKey); ok {
+		entry := v.(*dotClientEntry)
+		if !entry.closed.Load() {
+			return entry, nil
+		}
+		dotClientCache.Delete(cacheKey)
+	}
+
+	host, port, err := parseDoTServer(doturl)
+	if err != nil {
+		return nil, err
+	}
+	dialHost := host
+	if dotip != "" {
+		dialHost = dotip
+	}
+	entry := &dotClientEntry{
+		addr:       net.JoinHostPort(dialHost, port),
+		serverName: host,
+	}
+	if actual, loaded := dotClientCache.LoadOrStore(cacheKey, entry); loaded {
+		return actual.(*dotClientEntry), nil
+	}
+	return entry, nil
+}
+
+// CloseDoTClientCache 关闭并清理所有 DoT 客户端缓存
+func CloseDoTClientCache() {
+	closed := 0
+	dotClientCache.Range(func(key, value interface{}) bool {
+		value.(*dotClientEntry).Close()
+		dotClientCache.Delete(key)
+		closed++
+		return true
+	})
+	log.Printf("DoT 客户端缓存已关闭，共清理 %d 个客户端", closed)
+}
+
+// doTClientCached 使用缓存的 DoT 客户端执行查询
+func doTClientCached(msg *dns.Msg, doturl string, dotip string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry, err := getOrCreateDoTClient(doturl, dotip)
+	if err != nil {
+		log.Println(doturl, err)
+		return nil, err
+	}
+	resp, err := entry.Exchange(ctx, msg)
+	if err != nil {
+		log.Println(doturl, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func Dotnslookup(domain string, dnstype string, doturl string, dotip string) ([]*dns.Msg, []error) {
+	log.Println("domain:", domain, "dnstype:", dnstype, "doturl:", doturl)
+	var errs = make([]error, 0)
+	var results = make([]*dns.Msg, 0)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, d := range strings.Split(domain, ",") {
+		for _, t := range strings.Split(dnstype, ",") {
+			wg.Add(1)
+			go func(d string, t string) {
+				defer wg.Done()
+				var msg = &dns.Msg{}
+				msg.SetQuestion(d+".", dns.StringToType[t])
+				applyECS(msg, ECSSubnet(nil))
+
+				res, err := doTClientCached(msg, doturl, dotip)
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+				results = append(results, res)
+			}(d, t)
+		}
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// ResolveDomainToIPsWithDoT 通过复用的 DoT 连接查询 A 和 AAAA 记录
+func ResolveDomainToIPsWithDoT(domain string, doturl string, dotip string) ([]net.IP, []error) {
+	responses, errs := Dotnslookup(domain, "A,AAAA", doturl, dotip)
+	return collectIPs(domain, responses, errs)
+}
+
+// collectIPs 从查询结果中提取 A/AAAA 记录的 IP 地址
+func collectIPs(domain string, responses []*dns.Msg, errs []error) ([]net.IP, []error) {
+	if len(responses) == 0 && len(errs) > 0 {
+		return nil, errs
+	}
+	var ips []net.IP
+	for _, response := range responses {
+		for _, record := range response.Answer {
+			switch r := record.(type) {
+			case *dns.A:
+				ips = append(ips, r.A)
+			case *dns.AAAA:
+				ips = append(ips, r.AAAA)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, []error{fmt.Errorf("no IP addresses found for domain %s", domain)}
+	}
+	log.Println("dns resolved " + domain + " ips:[" + formatIPs(ips) + "]")
+	return ips, nil
+}
@@ -0,0 +1,129 @@
+package doh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newTestServerTLS 生成 dns.example.test 的自签名证书，并让 DoT/DoQ 客户端信任它
+func newTestServerTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.example.test"},
+		DNSNames:              []string{"dns.example.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	old := dnsServerRootCAs
+	dnsServerRootCAs = pool
+	t.Cleanup(func() { dnsServerRootCAs = old })
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// testAnswer 对 A 查询回答 192.0.2.1
+func testAnswer(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	return m
+}
+
+// countingListener 统计服务器接受的连接数
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return c, err
+}
+
+// 顺序和并发的 DoT 查询都复用同一条 TLS 连接
+func TestDoTClientReusesConnection(t *testing.T) {
+	tlsConf := newTestServerTLS(t)
+	raw, err := tls.Listen("tcp", "127.0.0.1:0", tlsConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &countingListener{Listener: raw}
+	server := &dns.Server{Listener: l, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(testAnswer(r))
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	defer CloseDoTClientCache()
+
+	_, port, _ := net.SplitHostPort(raw.Addr().String())
+	doturl := "tls://dns.example.test:" + port
+	query := func() error {
+		msg := new(dns.Msg)
+		msg.SetQuestion("www.example.org.", dns.TypeA)
+		resp, err := doTClientCached(msg, doturl, "127.0.0.1")
+		if err != nil {
+			return err
+		}
+		if resp.Id != msg.Id || len(resp.Answer) != 1 {
+			t.Errorf("unexpected response: %v", resp)
+		}
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := query(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := query(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := l.accepted.Load(); n != 1 {
+		t.Errorf("Expected 1 DoT connection, got %d", n)
+	}
+}
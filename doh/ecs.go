@@ -0,0 +1,186 @@
+package doh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ECSModeStrip 发送 SOURCE PREFIX-LENGTH 为 0 的 ECS，要求上游不使用任何客户端子网
+	ECSModeStrip = "strip"
+	// ECSModeFixed 始终发送固定的子网
+	ECSModeFixed = "fixed"
+	// ECSModeClient 根据连接到代理的客户端 IP 生成子网
+	ECSModeClient = "client"
+
+	// DefaultECSPrefixV4 根据客户端 IPv4 地址生成子网时默认保留的前缀长度
+	DefaultECSPrefixV4 = 24
+	// DefaultECSPrefixV6 根据客户端 IPv6 地址生成子网时默认保留的前缀长度
+	DefaultECSPrefixV6 = 56
+)
+
+// ECSPolicy EDNS Client Subnet（RFC 7871）策略
+type ECSPolicy struct {
+	Mode     string
+	Subnet   *net.IPNet // 仅 ECSModeFixed 使用
+	PrefixV4 int        // 仅 ECSModeClient 使用
+	PrefixV6 int        // 仅 ECSModeClient 使用
+}
+
+var ecsPolicy atomic.Pointer[ECSPolicy]
+
+// ParseECSPolicy 解析 ECS 策略，spec 可以是 "strip"、"client" 或固定的 IP/CIDR，
+// 为空或 "off" 时返回 nil（不修改查询中的 ECS）
+func ParseECSPolicy(spec string, prefixV4, prefixV6 int) (*ECSPolicy, error) {
+	if prefixV4 <= 0 {
+		prefixV4 = DefaultECSPrefixV4
+	}
+	if prefixV6 <= 0 {
+		prefixV6 = DefaultECSPrefixV6
+	}
+	if prefixV4 > 32 || prefixV6 > 128 {
+		return nil, fmt.Errorf("invalid ecs prefix length: v4=%d v6=%d", prefixV4, prefixV6)
+	}
+	spec = strings.TrimSpace(spec)
+	switch strings.ToLower(spec) {
+	case "", "off":
+		return nil, nil
+	case ECSModeStrip:
+		return &ECSPolicy{Mode: ECSModeStrip}, nil
+	case ECSModeClient:
+		return &ECSPolicy{Mode: ECSModeClient, PrefixV4: prefixV4, PrefixV6: prefixV6}, nil
+	}
+	if !strings.Contains(spec, "/") {
+		ip := net.ParseIP(spec)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ecs value %q: want strip, client, an IP or a CIDR", spec)
+		}
+		if ip.To4() != nil {
+			spec = fmt.Sprintf("%s/%d", ip, prefixV4)
+		} else {
+			spec = fmt.Sprintf("%s/%d", ip, prefixV6)
+		}
+	}
+	_, subnet, err := net.ParseCIDR(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ecs subnet %q: %v", spec, err)
+	}
+	return &ECSPolicy{Mode: ECSModeFixed, Subnet: subnet}, nil
+}
+
+// SetECSPolicy 设置全局 ECS 策略，nil 表示不修改查询中的 ECS
+func SetECSPolicy(p *ECSPolicy) {
+	ecsPolicy.Store(p)
+}
+
+type clientIPKey struct{}
+
+// WithClientIP 在 context 中记录连接到代理的客户端 IP，供 ECSModeClient 使用
+func WithClientIP(ctx context.Context, ip net.IP) context.Context {
+	if ip == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// WithClientAddr 与 WithClientIP 相同，参数为 host:port 形式的远端地址
+func WithClientAddr(ctx context.Context, addr string) context.Context {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return WithClientIP(ctx, net.ParseIP(host))
+}
+
+// ClientIPFromContext 返回 context 中记录的客户端 IP
+func ClientIPFromContext(ctx context.Context) net.IP {
+	if ctx == nil {
+		return nil
+	}
+	ip, _ := ctx.Value(clientIPKey{}).(net.IP)
+	return ip
+}
+
+// ECSSubnet 根据全局策略返回查询应携带的子网，nil 表示不修改查询
+func ECSSubnet(clientIP net.IP) *net.IPNet {
+	p := ecsPolicy.Load()
+	if p == nil {
+		return nil
+	}
+	switch p.Mode {
+	case ECSModeStrip:
+		return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	case ECSModeFixed:
+		return p.Subnet
+	case ECSModeClient:
+		if clientIP == nil || clientIP.IsLoopback() || clientIP.IsPrivate() {
+			// 私有地址对上游没有地理意义，保持查询不变
+			return nil
+		}
+		if ip4 := clientIP.To4(); ip4 != nil {
+			mask := net.CIDRMask(p.PrefixV4, 32)
+			return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+		}
+		mask := net.CIDRMask(p.PrefixV6, 128)
+		return &net.IPNet{IP: clientIP.Mask(mask), Mask: mask}
+	}
+	return nil
+}
+
+// ECSScopeFromContext 返回 context 对应的 ECS 子网字符串，未启用 ECS 时为空
+// 同时用作 ProxyOptionDNS.ClientSubnet 的值和 DNS 缓存 key 的后缀
+func ECSScopeFromContext(ctx context.Context) string {
+	subnet := ECSSubnet(ClientIPFromContext(ctx))
+	if subnet == nil {
+		return ""
+	}
+	return subnet.String()
+}
+
+// ecsSubnetFromString 解析 ProxyOptionDNS.ClientSubnet，为空时使用全局策略的固定值
+func ecsSubnetFromString(s string) *net.IPNet {
+	if s == "" {
+		return ECSSubnet(nil)
+	}
+	_, subnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil
+	}
+	return subnet
+}
+
+// applyECS 移除查询中已有的 ECS 选项，并在 subnet 非空时写入新的 ECS 选项
+func applyECS(msg *dns.Msg, subnet *net.IPNet) {
+	if subnet == nil {
+		return
+	}
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(4096, false)
+		opt = msg.IsEdns0()
+	}
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0SUBNET {
+			options = append(options, o)
+		}
+	}
+	ones, _ := subnet.Mask.Size()
+	ecs := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+		Address:       subnet.IP,
+	}
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		ecs.Family = 1
+		ecs.Address = ip4
+	} else {
+		ecs.Family = 2
+	}
+	opt.Option = append(options, ecs)
+}
@@ -0,0 +1,77 @@
+package doh
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func ecsOption(msg *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+	return nil
+}
+
+func TestECSPolicy(t *testing.T) {
+	defer SetECSPolicy(nil)
+
+	t.Run("client 模式按客户端生成子网", func(t *testing.T) {
+		p, err := ParseECSPolicy("client", 0, 0)
+		if err != nil {
+			t.Fatalf("ParseECSPolicy failed: %v", err)
+		}
+		SetECSPolicy(p)
+		ctx := WithClientAddr(context.Background(), "203.0.113.77:51234")
+		if scope := ECSScopeFromContext(ctx); scope != "203.0.113.0/24" {
+			t.Errorf("Expected 203.0.113.0/24, got %q", scope)
+		}
+		if scope := ECSScopeFromContext(WithClientAddr(context.Background(), "10.1.2.3:1")); scope != "" {
+			t.Errorf("Expected private client to leave query unchanged, got %q", scope)
+		}
+	})
+
+	t.Run("固定子网替换已有的 ECS", func(t *testing.T) {
+		p, err := ParseECSPolicy("2001:db8::1", 0, 48)
+		if err != nil {
+			t.Fatalf("ParseECSPolicy failed: %v", err)
+		}
+		SetECSPolicy(p)
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeA)
+		applyECS(msg, &net.IPNet{IP: net.ParseIP("198.51.100.0").To4(), Mask: net.CIDRMask(24, 32)})
+		applyECS(msg, ECSSubnet(nil))
+		ecs := ecsOption(msg)
+		if ecs == nil || ecs.Family != 2 || ecs.SourceNetmask != 48 || !ecs.Address.Equal(net.ParseIP("2001:db8::")) {
+			t.Errorf("Unexpected ecs option: %v", ecs)
+		}
+		if n := len(msg.IsEdns0().Option); n != 1 {
+			t.Errorf("Expected a single ecs option, got %d", n)
+		}
+	})
+
+	t.Run("strip 模式发送前缀长度为 0", func(t *testing.T) {
+		p, _ := ParseECSPolicy("strip", 0, 0)
+		SetECSPolicy(p)
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeA)
+		applyECS(msg, ECSSubnet(nil))
+		if ecs := ecsOption(msg); ecs == nil || ecs.SourceNetmask != 0 {
+			t.Errorf("Expected ecs with source prefix 0, got %v", ecs)
+		}
+	})
+
+	t.Run("无效配置", func(t *testing.T) {
+		if _, err := ParseECSPolicy("nearby", 0, 0); err == nil {
+			t.Errorf("Expected error for invalid ecs value")
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package doh
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/miekg/dns"
+)
+
+// plainDNSExchange 通过明文 UDP/TCP 查询DNS，UDP 响应被截断时自动改用 TCP 重试
+func plainDNSExchange(msg *dns.Msg, network string, dnsaddr string) (*dns.Msg, error) {
+	client := &dns.Client{Net: network, Timeout: 5 * time.Second, Dialer: options.NewDialer(network, dnsaddr)}
+	resp, _, err := client.Exchange(msg, dnsaddr)
+	if err == nil && resp.Truncated && network == "udp" {
+		log.Println(dnsaddr, "udp response truncated, retrying with tcp")
+		client.Net = "tcp"
+		resp, _, err = client.Exchange(msg, dnsaddr)
+	}
+	if err != nil {
+		log.Println(dnsaddr, err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func PlainDNSnslookup(domain string, dnstype string, network string, dnsaddr string) ([]*dns.Msg, []error) {
+	log.Println("domain:", domain, "dnstype:", dnstype, "dns:", network+"://"+dnsaddr)
+	var errs = make([]error, 0)
+	var results = make([]*dns.Msg, 0)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, d := range strings.Split(domain, ",") {
+		for _, t := range strings.Split(dnstype, ",") {
+			wg.Add(1)
+			go func(d string, t string) {
+				defer wg.Done()
+				var msg = &dns.Msg{}
+				msg.SetQuestion(d+".", dns.StringToType[t])
+				applyECS(msg, ECSSubnet(nil))
+				msg.RecursionDesired = true
+
+				res, err := plainDNSExchange(msg, network, dnsaddr)
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+				results = append(results, res)
+			}(d, t)
+		}
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// ResolveDomainToIPsWithPlainDNS 通过明文 UDP/TCP DNS 查询 A 和 AAAA 记录
+// 主要用于分流DNS中的内网解析器
+func ResolveDomainToIPsWithPlainDNS(domain string, network string, dnsaddr string) ([]net.IP, []error) {
+	responses, errs := PlainDNSnslookup(domain, "A,AAAA", network, dnsaddr)
+	return collectIPs(domain, responses, errs)
+}
@@ -0,0 +1,110 @@
+package doh
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	dns_experiment "github.com/masx200/http-proxy-go-server/dns_experiment"
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/miekg/dns"
+)
+
+// ResolveDomainToIPsWithOption 根据 DNS 配置的协议类型（DoH/DoH3/DoT/DoQ/DNSCrypt/UDP/TCP）解析域名
+// DoH3、DoT、DoQ 均复用缓存的长连接，避免每次查询重新握手
+// 启用 DNSSEC 验证后，伪造（bogus）的应答会被拒绝并以错误返回
+// 启用 HTTPS 记录后，DoH 服务器自身声明了 h3 时自动改用 DoH3
+func ResolveDomainToIPsWithOption(domain string, opt options.ProxyOptionDNS, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) ([]net.IP, []error) {
+	if DNSSECEnabled() {
+		ips, _, errs := ResolveDomainToIPsWithOptionStatus(domain, opt, Proxy, tranportConfigurations...)
+		return ips, errs
+	}
+	upgraded := upgradeDoHOption(opt, Proxy, tranportConfigurations...)
+	// 携带按客户端生成的 ECS 或升级为 DoH3 时，经由 ExchangeWithOption 查询
+	if opt.ClientSubnet != "" || upgraded.GetProtocol() != opt.GetProtocol() {
+		return resolveWithExchange(domain, opt, Proxy, tranportConfigurations...)
+	}
+	opt = upgraded
+	switch opt.GetProtocol() {
+	case "dot":
+		return ResolveDomainToIPsWithDoT(domain, opt.Doturl, opt.Dotip)
+	case "doq":
+		return ResolveDomainToIPsWithDoQ(domain, opt.Doqurl, opt.Doqip)
+	case "udp", "tcp":
+		return ResolveDomainToIPsWithPlainDNS(domain, opt.Protocol, opt.Dnsaddr)
+	case "dnscrypt":
+		return ResolveDomainToIPsWithDNSCrypt(domain, opt.Stamp)
+	case "doh3":
+		if opt.Dohip != "" {
+			return ResolveDomainToIPsWithDoh3(domain, opt.Dohurl, opt.Dohip)
+		}
+		return ResolveDomainToIPsWithDoh3(domain, opt.Dohurl)
+	default:
+		return ResolveDomainToIPsWithDoh(domain, opt.Dohurl, opt.Dohip, Proxy, tranportConfigurations...)
+	}
+}
+
+// ExchangeWithOption 使用指定的 DNS 配置发送任意 DNS 查询报文并返回应答
+func ExchangeWithOption(msg *dns.Msg, opt options.ProxyOptionDNS, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) (*dns.Msg, error) {
+	upgraded := upgradeDoHOption(opt, Proxy, tranportConfigurations...)
+	if upgraded.GetProtocol() == "doh3" && opt.GetProtocol() == "doh" {
+		resp, err := doHTTP3ClientCached(msg, upgraded.Dohurl, upgraded.Dohip)
+		if err == nil {
+			return resp, nil
+		}
+		// QUIC 不可用时回退到 DoH，并在发现结果过期前不再尝试 DoH3
+		log.Println("DoH3升级查询失败，回退到DoH", opt.Dohurl, err)
+		downgradeDoHOption(opt.Dohurl)
+	}
+	opt.Dohip = upgraded.Dohip
+	switch opt.GetProtocol() {
+	case "dot":
+		return doTClientCached(msg, opt.Doturl, opt.Dotip)
+	case "doq":
+		return doQClientCached(msg, opt.Doqurl, opt.Doqip)
+	case "udp", "tcp":
+		return plainDNSExchange(msg, opt.Protocol, opt.Dnsaddr)
+	case "dnscrypt":
+		return dnscryptClientCached(msg, opt.Stamp)
+	case "doh3":
+		if opt.Dohip != "" {
+			return doHTTP3ClientCached(msg, opt.Dohurl, opt.Dohip)
+		}
+		return doHTTP3ClientCached(msg, opt.Dohurl)
+	default:
+		return dns_experiment.DohClient(msg, opt.Dohurl, opt.Dohip, Proxy, tranportConfigurations...)
+	}
+}
+
+// resolveWithExchange 使用 ExchangeWithOption 查询 A 和 AAAA 记录，查询携带 opt.ClientSubnet 指定的 ECS
+func resolveWithExchange(domain string, opt options.ProxyOptionDNS, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) ([]net.IP, []error) {
+	log.Println("domain:", domain, "ecs:", opt.ClientSubnet, "server:", opt.ServerURL())
+	subnet := ecsSubnetFromString(opt.ClientSubnet)
+	var errs = make([]error, 0)
+	var results = make([]*dns.Msg, 0)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		wg.Add(1)
+		go func(t uint16) {
+			defer wg.Done()
+			var msg = &dns.Msg{}
+			msg.SetQuestion(dns.Fqdn(domain), t)
+			msg.RecursionDesired = true
+			applyECS(msg, subnet)
+
+			res, err := ExchangeWithOption(msg, opt, Proxy, tranportConfigurations...)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			results = append(results, res)
+		}(t)
+	}
+	wg.Wait()
+	return collectIPs(domain, results, errs)
+}
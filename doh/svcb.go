@@ -0,0 +1,261 @@
+package doh
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/miekg/dns"
+)
+
+// HTTPSEndpoint HTTPS/SVCB 记录（RFC 9460）中的一个服务端点
+type HTTPSEndpoint struct {
+	Priority uint16   `json:"priority"` // 0 表示 AliasMode
+	Target   string   `json:"target"`   // "." 表示与查询的域名相同
+	Port     uint16   `json:"port,omitempty"`
+	ALPN     []string `json:"alpn,omitempty"`
+	IPv4Hint []string `json:"ipv4hint,omitempty"`
+	IPv6Hint []string `json:"ipv6hint,omitempty"`
+}
+
+// TargetHost 返回端点实际连接的主机名
+func (e HTTPSEndpoint) TargetHost(host string) string {
+	target := strings.TrimSuffix(e.Target, ".")
+	if target == "" {
+		return host
+	}
+	return target
+}
+
+// TargetPort 返回端点实际连接的端口，记录中没有 port 参数时使用 defaultPort
+func (e HTTPSEndpoint) TargetPort(defaultPort string) string {
+	if e.Port == 0 {
+		return defaultPort
+	}
+	return fmt.Sprint(e.Port)
+}
+
+// SupportsHTTP3 判断端点是否声明了 h3
+func (e HTTPSEndpoint) SupportsHTTP3() bool {
+	for _, alpn := range e.ALPN {
+		if alpn == "h3" {
+			return true
+		}
+	}
+	return false
+}
+
+// Hints 返回 ipv4hint 与 ipv6hint 中的地址
+func (e HTTPSEndpoint) Hints() []net.IP {
+	var ips []net.IP
+	for _, s := range append(append([]string{}, e.IPv4Hint...), e.IPv6Hint...) {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+var httpsRecordsEnabled atomic.Bool
+
+// EnableHTTPSRecords 设置是否在拨号和 DoH 客户端中使用 HTTPS/SVCB 记录
+func EnableHTTPSRecords(enabled bool) {
+	httpsRecordsEnabled.Store(enabled)
+}
+
+// HTTPSRecordsEnabled 返回是否启用了 HTTPS/SVCB 记录
+func HTTPSRecordsEnabled() bool {
+	return httpsRecordsEnabled.Load()
+}
+
+// HTTPSQueryName 返回 HTTPS 记录的查询名，非 443 端口使用 _port._https. 前缀
+func HTTPSQueryName(host string, port string) string {
+	if port == "" || port == "443" {
+		return dns.Fqdn(host)
+	}
+	return dns.Fqdn(fmt.Sprintf("_%s._https.%s", port, host))
+}
+
+// HTTPSEndpointsFromMsg 从应答中提取 HTTPS/SVCB 端点（按优先级排序）和最小 TTL
+func HTTPSEndpointsFromMsg(resp *dns.Msg) ([]HTTPSEndpoint, uint32) {
+	var endpoints []HTTPSEndpoint
+	var minTTL uint32
+	for _, rr := range resp.Answer {
+		var svcb *dns.SVCB
+		switch r := rr.(type) {
+		case *dns.HTTPS:
+			svcb = &r.SVCB
+		case *dns.SVCB:
+			svcb = r
+		default:
+			continue
+		}
+		if minTTL == 0 || svcb.Hdr.Ttl < minTTL {
+			minTTL = svcb.Hdr.Ttl
+		}
+		ep := HTTPSEndpoint{Priority: svcb.Priority, Target: svcb.Target}
+		for _, kv := range svcb.Value {
+			switch v := kv.(type) {
+			case *dns.SVCBPort:
+				ep.Port = v.Port
+			case *dns.SVCBAlpn:
+				ep.ALPN = append(ep.ALPN, v.Alpn...)
+			case *dns.SVCBIPv4Hint:
+				for _, ip := range v.Hint {
+					ep.IPv4Hint = append(ep.IPv4Hint, ip.String())
+				}
+			case *dns.SVCBIPv6Hint:
+				for _, ip := range v.Hint {
+					ep.IPv6Hint = append(ep.IPv6Hint, ip.String())
+				}
+			}
+		}
+		endpoints = append(endpoints, ep)
+	}
+	// AliasMode（优先级 0）排在最前，其余按优先级从小到大
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return endpoints[i].Priority < endpoints[j].Priority
+	})
+	return endpoints, minTTL
+}
+
+// LookupHTTPSWithOption 使用指定的 DNS 配置查询 host:port 的 HTTPS 记录
+// 没有记录时返回空切片和 nil 错误，便于调用方做否定缓存。
+// 启用 DNSSEC 时与 A/AAAA 一样验证应答，验证失败的记录（包括其中的地址提示）不会被使用
+func LookupHTTPSWithOption(host string, port string, opt options.ProxyOptionDNS, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) ([]HTTPSEndpoint, uint32, error) {
+	v := dnssecState.Load()
+	msg := new(dns.Msg)
+	msg.SetQuestion(HTTPSQueryName(host, port), dns.TypeHTTPS)
+	msg.RecursionDesired = true
+	if v != nil {
+		msg.SetEdns0(4096, true)
+	}
+	applyECS(msg, ecsSubnetFromString(opt.ClientSubnet))
+	ex := func(msg *dns.Msg) (*dns.Msg, error) {
+		return ExchangeWithOption(msg, opt, Proxy, tranportConfigurations...)
+	}
+	resp, err := ex(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, 0, fmt.Errorf("HTTPS query for %s failed: %s", host, dns.RcodeToString[resp.Rcode])
+	}
+	if v != nil {
+		if _, err := v.validateResponse(ex, resp); err != nil {
+			log.Println("DNSSEC 验证 HTTPS 记录失败", host, opt.ServerURL(), err)
+			return nil, 0, err
+		}
+	}
+	endpoints, ttl := HTTPSEndpointsFromMsg(resp)
+	log.Println("HTTPS records", host, port, opt.ServerURL(), endpoints)
+	return endpoints, ttl, nil
+}
+
+// dohUpgradeTTL DoH 服务器 HTTPS 记录的最长缓存时间
+const dohUpgradeTTL = time.Hour
+
+// dohUpgradeEntry DoH 服务器自身 HTTPS 记录的发现结果
+type dohUpgradeEntry struct {
+	done    atomic.Bool
+	h3      atomic.Bool
+	ip      string
+	expires time.Time
+}
+
+// dohUpgradeCache 按 DoH URL 缓存发现结果
+var dohUpgradeCache sync.Map
+
+// upgradeDoHOption 根据 DoH 服务器自身的 HTTPS 记录升级配置：
+// 声明了 h3 时改用 DoH3，未配置 dohip 时使用 ipv4hint/ipv6hint 避免额外的引导解析
+// 首次调用时在后台发现，不阻塞当前查询
+func upgradeDoHOption(opt options.ProxyOptionDNS, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) options.ProxyOptionDNS {
+	if !HTTPSRecordsEnabled() || opt.GetProtocol() != "doh" {
+		return opt
+	}
+	u, err := url.Parse(opt.Dohurl)
+	if err != nil || u.Scheme != "https" {
+		return opt
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	if v, ok := dohUpgradeCache.Load(opt.Dohurl); ok {
+		entry := v.(*dohUpgradeEntry)
+		if !entry.done.Load() {
+			return opt
+		}
+		if time.Now().Before(entry.expires) {
+			if entry.ip != "" && opt.Dohip == "" {
+				opt.Dohip = entry.ip
+			}
+			// 经由上游代理访问 DoH 时无法使用 QUIC
+			if entry.h3.Load() && !dohViaProxy(opt.Dohurl, Proxy) {
+				opt.Protocol = "doh3"
+				opt.Dohalpn = "h3"
+			}
+			return opt
+		}
+		dohUpgradeCache.Delete(opt.Dohurl)
+	}
+
+	entry := &dohUpgradeEntry{}
+	if _, loaded := dohUpgradeCache.LoadOrStore(opt.Dohurl, entry); loaded {
+		return opt
+	}
+	go func(opt options.ProxyOptionDNS) {
+		defer entry.done.Store(true)
+		entry.expires = time.Now().Add(dohUpgradeTTL)
+		endpoints, ttl, err := LookupHTTPSWithOption(u.Hostname(), port, opt, Proxy, tranportConfigurations...)
+		if err != nil {
+			log.Println("DoH服务器HTTPS记录查询失败", opt.Dohurl, err)
+			return
+		}
+		if ttl > 0 && time.Duration(ttl)*time.Second < dohUpgradeTTL {
+			entry.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+		}
+		for _, ep := range endpoints {
+			// 只采用指向自身且端口不变的端点，否则 URL 无法直接复用
+			if ep.Priority == 0 || ep.TargetHost(u.Hostname()) != u.Hostname() || ep.TargetPort(port) != port {
+				continue
+			}
+			entry.h3.Store(ep.SupportsHTTP3())
+			if hints := ep.Hints(); len(hints) > 0 {
+				entry.ip = hints[0].String()
+			}
+			log.Printf("DoH服务器 %s HTTPS记录: h3=%v ip=%s", opt.Dohurl, entry.h3.Load(), entry.ip)
+			return
+		}
+	}(opt)
+	return opt
+}
+
+// downgradeDoHOption 记录 DoH 服务器的 DoH3 升级失败，之后继续使用 DoH
+func downgradeDoHOption(dohurl string) {
+	if v, ok := dohUpgradeCache.Load(dohurl); ok {
+		entry := v.(*dohUpgradeEntry)
+		entry.h3.Store(false)
+	}
+}
+
+// dohViaProxy 判断访问 DoH 服务器时是否经过上游代理
+func dohViaProxy(dohurl string, Proxy func(*http.Request) (*url.URL, error)) bool {
+	if Proxy == nil {
+		return false
+	}
+	req, err := http.NewRequest("POST", dohurl, nil)
+	if err != nil {
+		return false
+	}
+	proxyURL, err := Proxy(req)
+	return err == nil && proxyURL != nil
+}
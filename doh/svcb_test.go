@@ -0,0 +1,76 @@
+package doh
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/miekg/dns"
+)
+
+func TestHTTPSEndpointsFromMsg(t *testing.T) {
+	resp := new(dns.Msg)
+	for _, s := range []string{
+		`example.com. 300 IN HTTPS 2 . alpn="h2" port=8443`,
+		`example.com. 120 IN HTTPS 1 . alpn="h3,h2" ipv4hint="192.0.2.1,192.0.2.2" ipv6hint="2001:db8::1"`,
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+
+	endpoints, ttl := HTTPSEndpointsFromMsg(resp)
+	if len(endpoints) != 2 || ttl != 120 {
+		t.Fatalf("Expected 2 endpoints with ttl 120, got %d (ttl=%d)", len(endpoints), ttl)
+	}
+
+	first := endpoints[0]
+	if first.Priority != 1 || !first.SupportsHTTP3() || len(first.Hints()) != 3 {
+		t.Errorf("Unexpected first endpoint: %+v", first)
+	}
+	if first.TargetHost("example.com") != "example.com" || first.TargetPort("443") != "443" {
+		t.Errorf("Expected endpoint to target example.com:443, got %s:%s", first.TargetHost("example.com"), first.TargetPort("443"))
+	}
+	if second := endpoints[1]; second.SupportsHTTP3() || second.TargetPort("443") != "8443" {
+		t.Errorf("Unexpected second endpoint: %+v", second)
+	}
+
+	if name := HTTPSQueryName("example.com", "8443"); name != "_8443._https.example.com." {
+		t.Errorf("Unexpected query name %s", name)
+	}
+}
+
+// 启用 DNSSEC 时，签名无效的 HTTPS 记录（例如伪造的地址提示）不会被使用
+func TestLookupHTTPSWithOptionDNSSEC(t *testing.T) {
+	v, child, answers, authority := newTestDNSSECChain(t)
+	answers["www.example. HTTPS"] = child.sign(t, testRR(t, `www.example. 300 IN HTTPS 1 . alpn="h3" ipv4hint="192.0.2.1"`))
+	forged := child.sign(t, testRR(t, `forged.example. 300 IN HTTPS 1 . alpn="h3" ipv4hint="192.0.2.1"`))
+	forged[0].(*dns.HTTPS).Value = []dns.SVCBKeyValue{&dns.SVCBIPv4Hint{Hint: []net.IP{net.ParseIP("203.0.113.66")}}}
+	answers["forged.example. HTTPS"] = forged
+	ex := testExchange(answers, authority)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp, _ := ex(r)
+		w.WriteMsg(resp)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	dnssecState.Store(v)
+	defer dnssecState.Store(nil)
+	opt := options.ProxyOptionDNS{Protocol: "udp", Dnsaddr: pc.LocalAddr().String()}
+
+	endpoints, _, err := LookupHTTPSWithOption("www.example", "443", opt, nil)
+	if err != nil || len(endpoints) != 1 || endpoints[0].IPv4Hint[0] != "192.0.2.1" {
+		t.Fatalf("Expected the signed endpoint, got %v (err=%v)", endpoints, err)
+	}
+
+	if endpoints, _, err := LookupHTTPSWithOption("forged.example", "443", opt, nil); !errors.Is(err, ErrDNSSECBogus) {
+		t.Errorf("Expected bogus, got %v (err=%v)", endpoints, err)
+	}
+}
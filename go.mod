@@ -13,14 +13,18 @@ replace github.com/quic-go/quic-go => github.com/quic-go/quic-go v0.55.0
 require github.com/gin-gonic/gin v1.12.0
 
 require (
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/ameshkov/dnscrypt/v2 v2.4.0
+	github.com/ameshkov/dnsstamps v1.0.3
 	github.com/masx200/dnsproxy v1.0.4
 	github.com/masx200/doq-go v0.55.0
 	github.com/masx200/http3-reverse-proxy-server-experiment v0.0.0-20251004130120-07f6b38af34d
 	github.com/masx200/socks5-websocket-proxy-golang v0.0.0-20251004132949-7c6ed24bfd07
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/quic-go/quic-go v0.59.0
+	github.com/redis/go-redis/v9 v9.9.0
 	github.com/xeipuuv/gojsonschema v1.2.0
+	go.etcd.io/bbolt v1.4.3
 	go.uber.org/goleak v1.3.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -29,12 +33,14 @@ require github.com/bytedance/gopkg v0.1.3 // indirect
 
 require (
 	github.com/AdguardTeam/golibs v0.35.2 // indirect
-	github.com/ameshkov/dnsstamps v1.0.3 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/goccy/go-yaml v1.19.2 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/quic-go/qpack v0.6.0 // indirect
 	github.com/xeipuuv/gojsonpointer v0.0.0-20180127040702-4e3ac2762d5f // indirect
 	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	go.mongodb.org/mongo-driver/v2 v2.5.0 // indirect
 	golang.org/x/exp v0.0.0-20251113190631-e25ba8c21ef6 // indirect
 	golang.org/x/mod v0.35.0 // indirect
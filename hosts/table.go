@@ -0,0 +1,260 @@
+package hosts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkInterval 检查 hosts 文件修改时间的最小间隔，避免每次查询都 stat 文件
+const checkInterval = time.Second
+
+// SystemHostsFile 返回当前操作系统的 hosts 文件路径
+func SystemHostsFile() string {
+	switch runtime.GOOS {
+	case "windows":
+		return `C:\Windows\System32\drivers\etc\hosts`
+	default: // linux、darwin 等
+		return "/etc/hosts"
+	}
+}
+
+type wildcardEntry struct {
+	suffix string // "*.dev.local" 存为 "dev.local"
+	ips    []net.IP
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Table 解析后建立索引的 hosts 表
+// 文件只在修改时间或大小变化时重新解析，内联条目优先于文件中的条目
+type Table struct {
+	files  []string
+	inline map[string][]net.IP
+
+	mu        sync.RWMutex
+	exact     map[string][]net.IP
+	wildcards []wildcardEntry // 按后缀长度从长到短排序
+	states    map[string]fileState
+	lastCheck time.Time
+}
+
+// NewTable 创建 hosts 表，files 为 hosts 文件列表，inline 为内联条目（域名 -> IP 列表，
+// 域名支持 *.example.com 形式的通配符）
+func NewTable(files []string, inline map[string][]string) (*Table, error) {
+	t := &Table{
+		files:  files,
+		inline: make(map[string][]net.IP),
+		states: make(map[string]fileState),
+	}
+	for name, values := range inline {
+		for _, value := range values {
+			for _, s := range strings.Split(value, ",") {
+				ip := net.ParseIP(strings.TrimSpace(s))
+				if ip == nil {
+					return nil, fmt.Errorf("invalid ip %q for hosts entry %s", s, name)
+				}
+				key := normalizeHost(name)
+				t.inline[key] = append(t.inline[key], ip)
+			}
+		}
+	}
+	t.reload()
+	return t, nil
+}
+
+func normalizeHost(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// parseHosts 解析 hosts 格式的内容，每行的所有别名都指向该行的 IP，# 之后为注释
+func parseHosts(r io.Reader, add func(name string, ip net.IP)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+		ip := net.ParseIP(parts[0])
+		if ip == nil {
+			// 可能是带区域的 IPv6 链路本地地址，如 fe80::1%lo0
+			if i := strings.IndexByte(parts[0], '%'); i > 0 {
+				ip = net.ParseIP(parts[0][:i])
+			}
+			if ip == nil {
+				continue
+			}
+		}
+		for _, name := range parts[1:] {
+			add(normalizeHost(name), ip)
+		}
+	}
+	return scanner.Err()
+}
+
+// stale 判断文件是否有变化，调用方需持有写锁
+func (t *Table) stale() bool {
+	for _, file := range t.files {
+		info, err := os.Stat(file)
+		state, seen := t.states[file]
+		if err != nil {
+			if seen {
+				return true
+			}
+			continue
+		}
+		if !seen || !info.ModTime().Equal(state.modTime) || info.Size() != state.size {
+			return true
+		}
+	}
+	return false
+}
+
+// reload 重新解析所有文件并重建索引，调用方需持有写锁或在初始化时调用
+func (t *Table) reload() {
+	exact := make(map[string][]net.IP)
+	states := make(map[string]fileState)
+	add := func(name string, ip net.IP) {
+		for _, existing := range exact[name] {
+			if existing.Equal(ip) {
+				return
+			}
+		}
+		exact[name] = append(exact[name], ip)
+	}
+	for _, file := range t.files {
+		f, err := os.Open(file)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("读取hosts文件失败 %s: %v", file, err)
+			}
+			continue
+		}
+		if info, err := f.Stat(); err == nil {
+			states[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		if err := parseHosts(f, add); err != nil {
+			log.Printf("解析hosts文件失败 %s: %v", file, err)
+		}
+		f.Close()
+	}
+	for name, ips := range t.inline {
+		exact[name] = ips
+	}
+
+	var wildcards []wildcardEntry
+	for name, ips := range exact {
+		if strings.HasPrefix(name, "*.") {
+			wildcards = append(wildcards, wildcardEntry{suffix: name[2:], ips: ips})
+			delete(exact, name)
+		}
+	}
+	sort.Slice(wildcards, func(i, j int) bool {
+		return len(wildcards[i].suffix) > len(wildcards[j].suffix)
+	})
+
+	t.exact = exact
+	t.wildcards = wildcards
+	t.states = states
+	t.lastCheck = time.Now()
+	log.Printf("hosts表已加载，精确条目 %d 个，通配符条目 %d 个", len(exact), len(wildcards))
+}
+
+// refresh 距离上次检查超过 checkInterval 时检查文件变化
+func (t *Table) refresh() {
+	t.mu.RLock()
+	due := time.Since(t.lastCheck) >= checkInterval
+	t.mu.RUnlock()
+	if !due {
+		return
+	}
+	t.mu.Lock()
+	if time.Since(t.lastCheck) < checkInterval {
+		t.mu.Unlock()
+		return
+	}
+	if !t.stale() {
+		t.lastCheck = time.Now()
+		t.mu.Unlock()
+		return
+	}
+	t.reload()
+	t.mu.Unlock()
+	notifyReload()
+}
+
+// Lookup 查找域名对应的 IP，精确条目优先，其次是后缀最长的通配符条目
+// *.dev.local 同时匹配 dev.local 本身
+func (t *Table) Lookup(domain string) []net.IP {
+	t.refresh()
+	name := normalizeHost(domain)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if ips, ok := t.exact[name]; ok {
+		return ips
+	}
+	for _, w := range t.wildcards {
+		if name == w.suffix || strings.HasSuffix(name, "."+w.suffix) {
+			return w.ips
+		}
+	}
+	return nil
+}
+
+var defaultTable atomic.Pointer[Table]
+var defaultTableOnce sync.Once
+
+// reloadHook hosts 表重新加载后调用，用于丢弃按旧解析结果建立的连接
+var reloadHook atomic.Pointer[func()]
+
+// SetReloadHook 设置 hosts 文件变化重新加载或 Configure 替换默认表之后调用的函数
+func SetReloadHook(fn func()) {
+	reloadHook.Store(&fn)
+}
+
+func notifyReload() {
+	if fn := reloadHook.Load(); fn != nil && *fn != nil {
+		(*fn)()
+	}
+}
+
+// Configure 替换默认 hosts 表，files 为空时使用系统 hosts 文件
+func Configure(files []string, inline map[string][]string) error {
+	if len(files) == 0 {
+		files = []string{SystemHostsFile()}
+	}
+	t, err := NewTable(files, inline)
+	if err != nil {
+		return err
+	}
+	defaultTable.Store(t)
+	notifyReload()
+	return nil
+}
+
+// DefaultTable 返回默认 hosts 表，未调用 Configure 时加载系统 hosts 文件
+func DefaultTable() *Table {
+	defaultTableOnce.Do(func() {
+		if defaultTable.Load() == nil {
+			t, _ := NewTable([]string{SystemHostsFile()}, nil)
+			defaultTable.CompareAndSwap(nil, t)
+		}
+	})
+	return defaultTable.Load()
+}
@@ -0,0 +1,74 @@
+package hosts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTableLookup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hosts")
+	content := "127.0.0.1 localhost\n" +
+		"10.0.0.5 app.internal app api.internal # 内部服务\n" +
+		"# 10.0.0.6 commented.internal\n" +
+		"10.0.0.7 *.svc.internal\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := NewTable([]string{file}, map[string][]string{
+		"*.dev.local":   {"127.0.0.1"},
+		"a.dev.local":   {"127.0.0.2,::1"},
+		"API.internal.": {"10.0.0.9"},
+	})
+	if err != nil {
+		t.Fatalf("NewTable failed: %v", err)
+	}
+
+	cases := []struct {
+		domain string
+		want   string
+	}{
+		{"app", "10.0.0.5"},
+		{"api.internal", "10.0.0.9"}, // 内联条目优先于文件
+		{"x.svc.internal", "10.0.0.7"},
+		{"dev.local", "127.0.0.1"},
+		{"b.c.dev.local", "127.0.0.1"},
+		{"A.dev.local.", "127.0.0.2"},
+		{"commented.internal", ""},
+		{"svc.internal.example", ""},
+	}
+	for _, c := range cases {
+		ips := table.Lookup(c.domain)
+		got := ""
+		if len(ips) > 0 {
+			got = ips[0].String()
+		}
+		if got != c.want {
+			t.Errorf("Lookup(%q) = %q, want %q", c.domain, got, c.want)
+		}
+	}
+	if ips := table.Lookup("a.dev.local"); len(ips) != 2 {
+		t.Errorf("Expected 2 ips for a.dev.local, got %v", ips)
+	}
+
+	t.Run("文件修改后重新加载", func(t *testing.T) {
+		reloaded := false
+		SetReloadHook(func() { reloaded = true })
+		defer SetReloadHook(nil)
+		if err := os.WriteFile(file, []byte("10.0.0.8 app\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(file, future, future)
+		table.mu.Lock()
+		table.lastCheck = time.Time{}
+		table.mu.Unlock()
+		if ips := table.Lookup("app"); len(ips) != 1 || ips[0].String() != "10.0.0.8" {
+			t.Errorf("Expected reloaded entry 10.0.0.8, got %v", ips)
+		}
+		if !reloaded {
+			t.Error("Expected reload hook to be called")
+		}
+	})
+}
@@ -2,7 +2,6 @@ package http
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -19,6 +18,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/masx200/http-proxy-go-server/dnscache"
+	"github.com/masx200/http-proxy-go-server/doh"
 	"github.com/masx200/http-proxy-go-server/options"
 	"github.com/masx200/socks5-websocket-proxy-golang/pkg/interfaces"
 	socks5_websocket_proxy_golang_websocket "github.com/masx200/socks5-websocket-proxy-golang/pkg/websocket"
@@ -28,7 +28,7 @@ import (
 )
 
 func startsWithHTTP(s string) bool {
-	return strings.HasPrefix(s, "http://")
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
 }
 
 // 辅助函数：将ForwardedBy列表转换为集合（set），用于快速判断重复项
@@ -88,7 +88,10 @@ func parseForwardedHeader(header string) ([]ForwardedBy, error) {
 
 	return forwardedByList, nil
 }
-func proxyHandler(w http.ResponseWriter, r *http.Request, LocalAddr string, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, username, password string, upstreamResolveIPs bool, ipPriority options.IPPriority, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) error {
+
+// proxyHandler 转发一个请求。frontEnd 为 true 时本跳直接面对客户端（TLS 拦截），附加 Forwarded、Via 等标识头；
+// 为 false 时是前端之后的内部 HTTP 代理，标识头已由前端附加，只删除逐跳头
+func proxyHandler(w http.ResponseWriter, r *http.Request, LocalAddr string, frontEnd bool, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, username, password string, upstreamResolveIPs bool, ipPriority options.IPPriority, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) error {
 	log.Println("method:", r.Method)
 	log.Println("url:", r.URL)
 	log.Println("host:", r.Host)
@@ -114,6 +117,12 @@ func proxyHandler(w http.ResponseWriter, r *http.Request, LocalAddr string, prox
 	}
 
 	r.Header.Del("Proxy-Authorization")
+	// 内部 HTTP 代理的连接来自前端，真实的客户端地址由前端放在 ClientAddrHeader 中；
+	// 直接面对客户端时不信任这个头，它和其它逐跳头一起被删除
+	clientAddr := r.RemoteAddr
+	if v := r.Header.Get(options.ClientAddrHeader); v != "" && !frontEnd {
+		clientAddr = v
+	}
 	clienthost, port, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		log.Println(err)
@@ -121,32 +130,45 @@ func proxyHandler(w http.ResponseWriter, r *http.Request, LocalAddr string, prox
 	}
 	log.Println("clienthost:", clienthost)
 	log.Println("clientport:", port)
+	proto := "http"
+	if r.TLS != nil {
+		// TLS 拦截后解密的请求
+		proto = "https"
+	}
 	forwarded := fmt.Sprintf(
 		"for=%s;by=%s;host=%s;proto=%s",
 		clienthost, // 代理自己的标识或IP地址
 		LocalAddr,  // 代理的标识
 		r.Host,     // 原始请求的目标主机名
-		"http",     // 或者 "https" 根据实际协议
+		proto,      // 客户端使用的协议
 	)
-	r.Header.Add("Forwarded", forwarded)
+	var checkLoop bool
+	if frontEnd {
+		// 删除逐跳头，按设置附加 Forwarded、Via、X-Forwarded-For，匿名模式下删除所有标识头
+		checkLoop = options.PrepareForwardRequestHeaders(r.Header, r.ProtoMajor, r.ProtoMinor, clienthost, forwarded)
+	} else {
+		checkLoop = options.PrepareInternalRequestHeaders(r.Header)
+	}
 	for k, v := range r.Header {
 		// log.Println("key:", k)
 		log.Println("proxyHandler", k, ":", strings.Join(v, ","))
 	}
-	forwardedHeader := strings.Join(r.Header.Values("Forwarded"), ", ")
-	log.Println("forwardedHeader:", forwardedHeader)
-	forwardedByList, err := parseForwardedHeader(forwardedHeader)
-	log.Println("forwardedByList:", forwardedByList)
-	if len(forwardedByList) != len(setFromForwardedBy(forwardedByList)) {
-		w.WriteHeader(508)
-		fmt.Fprintln(w, "508 Loop Detected")
-		log.Println("Duplicate 'by' identifiers found in 'Forwarded' header.")
-		return nil
-	}
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error parsing 'Forwarded' header: %v", err)
-		return err
+	if checkLoop {
+		forwardedHeader := strings.Join(r.Header.Values("Forwarded"), ", ")
+		log.Println("forwardedHeader:", forwardedHeader)
+		forwardedByList, err := parseForwardedHeader(forwardedHeader)
+		log.Println("forwardedByList:", forwardedByList)
+		if len(forwardedByList) != len(setFromForwardedBy(forwardedByList)) {
+			w.WriteHeader(508)
+			fmt.Fprintln(w, "508 Loop Detected")
+			log.Println("Duplicate 'by' identifiers found in 'Forwarded' header.")
+			return nil
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Error parsing 'Forwarded' header: %v", err)
+			return err
+		}
 	}
 	targetUrl := "http://" + r.Host + r.RequestURI
 	/*r.URL可能是http://开头,也可能只有路径  */
@@ -166,130 +188,8 @@ func proxyHandler(w http.ResponseWriter, r *http.Request, LocalAddr string, prox
 	// }
 
 	// log.Println("body:", string(bodyBytes))
-	transport := &http.Transport{
-		ForceAttemptHTTP2: true,
-		// 自定义 DialContext 函数
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-
-			var host, _, err = net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
-			}
-			if utils.IsLoopbackIP(host) {
-				var dialer = &net.Dialer{}
-				return dialer.DialContext(ctx, network, addr)
-			}
-			// 解析出原地址中的端口
-			hostname, _, err := net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
-			}
-
-			if IsIP(hostname) {
-				dialer := &net.Dialer{}
-				//				// 发起连接
-				return dialer.DialContext(ctx, network, addr)
-			}
-
-			// 如果启用了上游IP解析，先解析目标地址
-			targetAddr := addr
-			if upstreamResolveIPs {
-				log.Printf("upstream-resolve-ips enabled, resolving target address %s before connection", targetAddr)
-
-				resolvedAddrs, err := resolveTargetAddressForAuth(targetAddr, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
-				if err != nil {
-					log.Printf("Failed to resolve target address %s: %v, using original", targetAddr, err)
-					resolvedAddrs = []string{targetAddr}
-				}
-
-				// 使用轮询从解析的地址中选择一个
-				resolvedAddr := resolveTargetAddressForAuthWithRoundRobin(resolvedAddrs, targetAddr, ipPriority)
-
-				if upstreamResolveIPs && resolvedAddr != targetAddr {
-					log.Printf("Using resolved address %s instead of original %s", resolvedAddr, targetAddr)
-					targetAddr = resolvedAddr
-				}
-			}
-
-			return dnscache.Proxy_net_DialContextCached(ctx, network, targetAddr, proxyoptions, dnsCache, upstreamResolveIPs, Proxy, tranportConfigurations...)
-		},
-		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-
-			//				// 解析出原地址中的端口
-			hostname, _, err := net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
-			}
-
-			// 如果启用了上游IP解析，先解析目标地址
-			targetAddr := addr
-			if upstreamResolveIPs {
-				log.Printf("upstream-resolve-ips enabled, resolving TLS target address %s before connection", targetAddr)
-
-				resolvedAddrs, err := resolveTargetAddressForAuth(targetAddr, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
-				if err != nil {
-					log.Printf("Failed to resolve TLS target address %s: %v, using original", targetAddr, err)
-					resolvedAddrs = []string{targetAddr}
-				}
-
-				// 使用轮询从解析的地址中选择一个
-				resolvedAddr := resolveTargetAddressForAuthWithRoundRobin(resolvedAddrs, targetAddr, ipPriority)
-
-				if upstreamResolveIPs && resolvedAddr != targetAddr {
-					log.Printf("Using resolved TLS address %s instead of original %s", resolvedAddr, targetAddr)
-					targetAddr = resolvedAddr
-
-					// 如果地址被解析为IP，需要更新hostname用于TLS配置
-					resolvedHostname, _, err := net.SplitHostPort(resolvedAddr)
-					if err == nil {
-						// 只有当解析后的hostname不是IP时才更新ServerName
-						// 如果是IP地址，保持原来的hostname作为SNI
-						if net.ParseIP(resolvedHostname) == nil {
-							hostname = resolvedHostname
-						}
-					}
-				}
-			}
-
-			//				// 用指定的 IP 地址和原端口创建新地址
-			//				newAddr := net.JoinHostPort(serverIP, port)
-			//				// 创建 net.Dialer 实例
-			//				dialer := &net.Dialer{}
-			//				// 发起连接
-			conn, err := dnscache.Proxy_net_DialContextCached(ctx, network, targetAddr, proxyoptions, dnsCache, upstreamResolveIPs, Proxy, tranportConfigurations...) //dialer.DialContext(ctx, network, newAddr)
-			if err != nil {
-				return nil, err
-			}
-			//			var address = addr
-			tlsConfig := &tls.Config{
-				ServerName: hostname,
-			}
-			// 创建 TLS 连接
-			tlsConn := tls.Client(conn, tlsConfig)
-			// 进行 TLS 握手
-			err = tlsConn.HandshakeContext(ctx)
-			if err != nil {
-				conn.Close()
-				return nil, err
-			}
-			return tlsConn, nil
-		},
-	}
-	client := &http.Client{ /* Transport: newTransport("http://your_proxy_address:port") */
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse /* 不进入重定向 */
-		},
-
-		/* Jar: jar */} // 替换为你的代理服务器地址和端口
-	for _, f := range tranportConfigurations {
-		transport = f(transport)
-	}
-	if len(proxyoptions) > 0 {
-
-		client.Transport = transport
-	}
 	/* 流式处理,防止内存溢出 */
-	proxyReq, err := http.NewRequest(r.Method, targetUrl, r.Body /* bytes.NewReader(bodyBytes) */)
+	proxyReq, err := http.NewRequestWithContext(doh.WithClientAddr(r.Context(), clientAddr), r.Method, targetUrl, r.Body /* bytes.NewReader(bodyBytes) */)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -301,78 +201,46 @@ func proxyHandler(w http.ResponseWriter, r *http.Request, LocalAddr string, prox
 		log.Println(err)
 		return err
 	}
-	if proxyUrl != nil && (proxyUrl.Scheme == "ws" || proxyUrl.Scheme == "wss") {
-
-		log.Println("使用代理：" + proxyUrl.String())
-
-		if client.Transport == nil {
-			client.Transport = http.DefaultTransport
-		}
-
-		if transport, ok := client.Transport.(*http.Transport); ok {
-			transport.Proxy = nil
-
-			log.Println("已经修改了代理为websocket", proxyUrl.String())
-			var DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-				var host, _, err = net.SplitHostPort(addr)
-				if err != nil {
-					return nil, err
-				}
-				if utils.IsLoopbackIP(host) {
-					var dialer = &net.Dialer{}
-					return dialer.DialContext(ctx, network, addr)
-				}
-				log.Println("使用代理：" + proxyUrl.String())
-
-				log.Println("network,addr", network, addr)
-				return websocketDialContext(ctx, network, addr, proxyUrl, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority)
-			}
-			transport.DialContext = DialContext
-			transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-
-				//				// 解析出原地址中的端口
-				hostname, _, err := net.SplitHostPort(addr)
-				if err != nil {
-					return nil, err
-				}
-				//				// 用指定的 IP 地址和原端口创建新地址
-				//				newAddr := net.JoinHostPort(serverIP, port)
-				//				// 创建 net.Dialer 实例
-				//				dialer := &net.Dialer{}
-				//				// 发起连接
-				conn, err := DialContext(ctx, network, addr)
-				if err != nil {
-					return nil, err
-				}
-				//			var address = addr
-				tlsConfig := &tls.Config{
-					ServerName: hostname,
-				}
-				// 创建 TLS 连接
-				tlsConn := tls.Client(conn, tlsConfig)
-				// 进行 TLS 握手
-				err = tlsConn.HandshakeContext(ctx)
-				if err != nil {
-					conn.Close()
-					return nil, err
-				}
-				return tlsConn, nil
-			}
-		}
+	// 按监听地址和路由复用 Transport，保持到目标和上游的连接池
+	client := &http.Client{
+		Transport: getOrCreateProxyTransport(LocalAddr, proxyUrl, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, Proxy, tranportConfigurations...),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse /* 不进入重定向 */
+		},
 	}
 
 	proxyReq.Header = r.Header.Clone()
 	proxyReq.ContentLength = r.ContentLength
-	resp, err := client.Do(proxyReq)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
-		return err
+	// 协议升级请求（WebSocket、h2c 等）只能通过 HTTP/1.1 转发
+	upgrade := options.UpgradeType(proxyReq.Header)
+	var resp *http.Response
+	var usedHTTP3 bool
+	// 直连时，目标的 HTTPS 记录声明了 h3 则优先尝试 HTTP/3
+	if proxyUrl == nil && upgrade == "" {
+		resp, usedHTTP3 = roundTripHTTP3Upstream(doh.WithClientAddr(r.Context(), clientAddr), proxyReq, proxyoptions, dnsCache, Proxy, tranportConfigurations...)
+	}
+	if !usedHTTP3 {
+		resp, err = client.Do(proxyReq)
+		if err != nil {
+			log.Println(err)
+			// 连接失败返回 502/504 并在 Proxy-Status 中说明原因，熔断时附带 Retry-After
+			http.Error(w, err.Error(), options.SetProxyErrorHeaders(w.Header(), err))
+			return err
+		}
 	}
 	defer resp.Body.Close()
-	w.WriteHeader(resp.StatusCode)
-	// Copy headers from the response to the client's response.
+	// 响应头必须在 WriteHeader 之前复制，否则不会发给客户端
+	if frontEnd {
+		options.PrepareForwardResponseHeaders(resp.Header, resp.StatusCode, resp.ProtoMajor, resp.ProtoMinor)
+	} else {
+		options.PrepareInternalResponseHeaders(resp.Header, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// 101 之后接管客户端连接，双向转发原始数据
+		return handleUpgradeResponse(w, upgrade, resp)
+	}
 	maps.Copy(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
 
 	// Copy the response body back to the client.
 	/* bodyBytes2, err := io.ReadAll(resp.Body)
@@ -407,7 +275,8 @@ func Http(hostname string, port int, proxyoptions options.ProxyOptionsDNSSLICE,
 	engine.Use(func(c *gin.Context) {
 		var w = c.Writer
 		var r = c.Request
-		err := proxyHandler(w, r /* jar, */, LocalAddr, proxyoptions, dnsCache, username, password, upstreamResolveIPs, ipPriority, Proxy, tranportConfigurations...)
+		// 内部 HTTP 代理只接受前端转发来的请求，标识头由前端附加
+		err := proxyHandler(w, r /* jar, */, LocalAddr, false, proxyoptions, dnsCache, username, password, upstreamResolveIPs, ipPriority, Proxy, tranportConfigurations...)
 		c.Abort()
 
 		if err != nil {
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/dnscache"
+	"github.com/masx200/http-proxy-go-server/doh"
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// h3UpstreamFailureTTL HTTP/3 连接失败后，在这段时间内不再尝试该目标
+const h3UpstreamFailureTTL = 10 * time.Minute
+
+// h3UpstreamTransports 按目标 host:port 缓存的 HTTP/3 Transport，复用 QUIC 连接
+var h3UpstreamTransports sync.Map
+
+// h3UpstreamFailures 按目标 host:port 记录 HTTP/3 失败的时间
+var h3UpstreamFailures sync.Map
+
+// h3UpstreamRootCAs 验证 HTTP/3 目标证书的根证书，nil 时使用系统根证书，测试中替换
+var h3UpstreamRootCAs *x509.CertPool
+
+// getOrCreateH3UpstreamTransport 获取或创建连接到 HTTPS 记录端点的 HTTP/3 Transport
+func getOrCreateH3UpstreamTransport(key string, ips []net.IP, port string, resolver dnscache.NameResolver) *http3.Transport {
+	if v, ok := h3UpstreamTransports.Load(key); ok {
+		return v.(*http3.Transport)
+	}
+	tr := &http3.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: h3UpstreamRootCAs},
+		Dial: func(ctx context.Context, addr string, tlsConf *tls.Config, quicConf *quic.Config) (*quic.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			targets := ips
+			if len(targets) == 0 && resolver != nil {
+				targets, _ = resolver.LookupIP(ctx, "ip", host)
+			}
+			if len(targets) == 0 {
+				return dialQUICEarly(ctx, net.JoinHostPort(host, port), tlsConf, quicConf)
+			}
+			var lastErr error
+			for _, ip := range targets {
+				conn, err := dialQUICEarly(ctx, net.JoinHostPort(ip.String(), port), tlsConf, quicConf)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				log.Println("HTTP/3上游连接成功", addr, conn.RemoteAddr())
+				return conn, nil
+			}
+			return nil, lastErr
+		},
+	}
+	if actual, loaded := h3UpstreamTransports.LoadOrStore(key, tr); loaded {
+		tr.Close()
+		return actual.(*http3.Transport)
+	}
+	return tr
+}
+
+// roundTripHTTP3Upstream 目标的 HTTPS 记录声明了 h3 时尝试用 HTTP/3 转发请求
+// 只用于没有请求体的请求，失败时返回 false 以便调用方回退到 TCP。
+// 前端转发给内部 HTTP 代理的都是 http 请求，只有 TLS 拦截（ServeIntercepted）解密出的 https 请求会用到这里
+func roundTripHTTP3Upstream(ctx context.Context, proxyReq *http.Request, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) (*http.Response, bool) {
+	if !doh.HTTPSRecordsEnabled() || proxyReq.URL.Scheme != "https" || proxyReq.ContentLength != 0 {
+		return nil, false
+	}
+	host := proxyReq.URL.Hostname()
+	port := proxyReq.URL.Port()
+	if port == "" {
+		port = "443"
+	}
+	key := net.JoinHostPort(host, port)
+	if v, ok := h3UpstreamFailures.Load(key); ok {
+		if time.Since(v.(time.Time)) < h3UpstreamFailureTTL {
+			return nil, false
+		}
+		h3UpstreamFailures.Delete(key)
+	}
+
+	// hosts 表或固定解析中的域名在这里得不到端点，启用 DNSSEC 时端点的地址提示都已通过验证
+	endpoints := dnscache.LookupHTTPSEndpoints(ctx, host, port, proxyoptions, dnsCache, Proxy, tranportConfigurations...)
+	for _, ep := range endpoints {
+		// 只处理指向同一主机的端点，其它目标交给 TCP 路径按记录拨号
+		if ep.Priority == 0 || !ep.SupportsHTTP3() || ep.TargetHost(host) != host {
+			continue
+		}
+		var resolver dnscache.NameResolver
+		if len(proxyoptions) > 0 && dnsCache != nil {
+			resolver = dnscache.CreateHostsAndDohResolverCached(proxyoptions, dnsCache, Proxy, tranportConfigurations...)
+		}
+		tr := getOrCreateH3UpstreamTransport(key, ep.Hints(), ep.TargetPort(port), resolver)
+		h3Req := proxyReq.Clone(ctx)
+		h3Req.Body = http.NoBody
+		resp, err := tr.RoundTrip(h3Req)
+		if err != nil {
+			log.Println("HTTP/3上游请求失败，回退到TCP", key, err)
+			h3UpstreamFailures.Store(key, time.Now())
+			h3UpstreamTransports.Delete(key)
+			tr.Close()
+			return nil, false
+		}
+		log.Println("使用HTTP/3转发请求", proxyReq.URL.String())
+		return resp, true
+	}
+	return nil, false
+}
+
+// dialQUICEarly 使用按出站配置（源地址、网卡、防火墙标记）创建的 UDP socket 建立 QUIC 连接，
+// 连接关闭时一并关闭 socket
+func dialQUICEarly(ctx context.Context, addr string, tlsConf *tls.Config, quicConf *quic.Config) (*quic.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := options.ListenPacket(ctx, "udp", udpAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	tr := &quic.Transport{Conn: pconn}
+	conn, err := tr.DialEarly(ctx, udpAddr, tlsConf, quicConf)
+	if err != nil {
+		tr.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		tr.Close()
+	}()
+	return conn, nil
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/dnscache"
+	"github.com/masx200/http-proxy-go-server/doh"
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// TLS 拦截解密出的 https 请求在目标的 HTTPS 记录声明 h3 时经 HTTP/3 转发
+func TestServeInterceptedHTTP3Upstream(t *testing.T) {
+	defer InvalidateTransports()
+	doh.EnableHTTPSRecords(true)
+	defer doh.EnableHTTPSRecords(false)
+
+	ca, caCert := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	h3UpstreamRootCAs = pool
+	defer func() { h3UpstreamRootCAs = nil }()
+
+	// HTTP/3 源站使用同一个 CA 签发的证书
+	const host = "h3.example.test"
+	cert, err := ca.Certificate(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	h3Server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{*cert}}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto+" "+r.URL.Path)
+		}),
+	}
+	go h3Server.Serve(udpConn)
+	defer h3Server.Close()
+	port := strconv.Itoa(udpConn.LocalAddr().(*net.UDPAddr).Port)
+	address := net.JoinHostPort(host, port)
+
+	dir := t.TempDir()
+	config := dnscache.DefaultConfig()
+	config.FilePath = filepath.Join(dir, "cache.json")
+	config.AOFPath = filepath.Join(dir, "cache.aof")
+	cache, err := dnscache.NewWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cache.SetHTTPSEndpoints(context.Background(), host, port, []doh.HTTPSEndpoint{
+		{Priority: 1, Target: ".", ALPN: []string{"h3"}, IPv4Hint: []string{"127.0.0.1"}},
+	}, time.Minute)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		ServeIntercepted(conn, address, ca, nil, cache, false, options.ParseIPPriority("random"), nil)
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool, ServerName: host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /h3 HTTP/1.1\r\nHost: "+address+"\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "HTTP/3.0 /h3" {
+		t.Errorf("Expected the request to be forwarded over HTTP/3, got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/masx200/http-proxy-go-server/dnscache"
+	"github.com/masx200/http-proxy-go-server/mitm"
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+// ServeIntercepted 拦截一个已回复 200 的 CONNECT 隧道：用 ca 即时签发的证书终止客户端的 TLS，
+// 解密后的请求作为 https 请求交给 proxyHandler，与普通 HTTP 请求一样经过标识头处理、日志和路由规则，
+// 转发时重新用 TLS 连接源站。address 是 CONNECT 的目标 host:port，客户端没有发送 SNI 时用于签发证书
+func ServeIntercepted(client net.Conn, address string, ca *mitm.CA, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		log.Println(err)
+		client.Close()
+		return
+	}
+	tlsConn := tls.Server(client, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return ca.Certificate(name)
+		},
+		NextProtos: []string{"http/1.1"},
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		// 客户端不信任本地 CA 时握手失败
+		log.Printf("MITM TLS handshake with client for %s failed: %v", address, err)
+		tlsConn.Close()
+		return
+	}
+	log.Println("MITM TLS intercepted:", address)
+
+	// 默认端口不写进 URL，转发的 Host 头与客户端直接访问时相同
+	targetHost := address
+	if port == "443" {
+		targetHost = host
+		if strings.Contains(host, ":") {
+			targetHost = "[" + host + "]"
+		}
+	}
+	LocalAddr := client.LocalAddr().String()
+	l := &singleConnListener{conn: tlsConn, done: make(chan struct{})}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 目标始终是 CONNECT 的地址，隧道内的请求不能借 Host 头改变目标
+			r.URL.Scheme = "https"
+			r.URL.Host = targetHost
+			// CONNECT 请求已经通过了身份验证
+			if err := proxyHandler(w, r, LocalAddr, true, proxyoptions, dnsCache, "", "", upstreamResolveIPs, ipPriority, Proxy, tranportConfigurations...); err != nil {
+				log.Println(err)
+			}
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				l.Close()
+			}
+		},
+	}
+	server.Serve(l)
+}
+
+// singleConnListener 只返回一个连接的 net.Listener，连接关闭或被接管后 Accept 返回错误，http.Server.Serve 随之退出
+type singleConnListener struct {
+	conn      net.Conn
+	acceptOne sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.acceptOne.Do(func() {
+		conn = l.conn
+	})
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *singleConnListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/mitm"
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+func TestServeIntercepted(t *testing.T) {
+	defer InvalidateTransports()
+
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Method+" "+r.URL.Path+" "+r.Header.Get("Forwarded"))
+	}))
+	defer origin.Close()
+	// 没有 DNS 选项时代理到源站使用 http.DefaultTransport 的副本，让它信任测试源站的证书
+	originPool := x509.NewCertPool()
+	originPool.AddCert(origin.Certificate())
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	oldTLSConfig := defaultTransport.TLSClientConfig
+	defaultTransport.TLSClientConfig = &tls.Config{RootCAs: originPool}
+	defer func() { defaultTransport.TLSClientConfig = oldTLSConfig }()
+
+	ca, caCert := newTestCA(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	address := strings.TrimPrefix(origin.URL, "https://")
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		ServeIntercepted(conn, address, ca, nil, nil, false, options.ParseIPPriority("random"), nil)
+	}()
+
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(caCert)
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: clientPool, ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if issuer := conn.ConnectionState().PeerCertificates[0].Issuer.CommonName; issuer != "test MITM CA" {
+		t.Errorf("Expected certificate issued by the MITM CA, got %q", issuer)
+	}
+
+	reader := bufio.NewReader(conn)
+	for _, path := range []string{"/a", "/b"} {
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+address+"\r\n\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "GET "+path+" ") || !strings.Contains(string(body), "proto=https") {
+			t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+		}
+	}
+}
+
+// newTestCA 创建测试用的 MITM CA
+func newTestCA(t *testing.T) (*mitm.CA, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test MITM CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+	ca, err := mitm.NewCA(caCert, key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, caCert
+}
@@ -0,0 +1,294 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masx200/http-proxy-go-server/dnscache"
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/masx200/http-proxy-go-server/utils"
+)
+
+// TransportOptions 内部 HTTP 代理转发请求时使用的共享 Transport 的连接池和超时设置
+type TransportOptions struct {
+	// MaxIdleConns 所有目标的空闲连接总数上限，0 表示不限制
+	MaxIdleConns int
+	// MaxIdleConnsPerHost 每个目标保留的空闲连接数
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost 每个目标的连接数上限，0 表示不限制
+	MaxConnsPerHost int
+	// IdleConnTimeout 空闲连接保留时间
+	IdleConnTimeout time.Duration
+	// ResponseHeaderTimeout 发出请求后等待响应头的超时，0 表示不限制
+	ResponseHeaderTimeout time.Duration
+	// TLSHandshakeTimeout TLS 握手超时
+	TLSHandshakeTimeout time.Duration
+	// DisableHTTP2 不与 HTTPS 目标协商 HTTP/2
+	DisableHTTP2 bool
+	// HTTP2PingInterval HTTP/2 连接空闲多久后发送 PING 检查连接，0 表示不检查
+	HTTP2PingInterval time.Duration
+	// HTTP2PingTimeout 等待 PING 响应的超时，超时后关闭连接
+	HTTP2PingTimeout time.Duration
+}
+
+// DefaultTransportOptions 默认的共享 Transport 设置
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 16,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+	HTTP2PingTimeout:    15 * time.Second,
+}
+
+var transportOptions atomic.Pointer[TransportOptions]
+
+// proxyTransports 按监听地址和路由（直连或 WebSocket 上游）缓存的 Transport，复用到目标和上游的连接
+var proxyTransports sync.Map
+
+// SetTransportOptions 设置共享 Transport 的连接池和超时，已缓存的 Transport 会被丢弃
+func SetTransportOptions(o TransportOptions) {
+	transportOptions.Store(&o)
+	InvalidateTransports()
+}
+
+// InvalidateTransports 丢弃所有缓存的 Transport 并关闭其空闲连接，配置重新加载后调用；
+// 正在进行的请求不受影响，之后的请求使用新建的 Transport
+func InvalidateTransports() {
+	proxyTransports.Range(func(key, value any) bool {
+		proxyTransports.Delete(key)
+		value.(*http.Transport).CloseIdleConnections()
+		return true
+	})
+}
+
+// getOrCreateProxyTransport 获取或创建监听地址 LocalAddr 转发请求使用的 Transport，
+// proxyUrl 为 WebSocket 上游时每个上游使用单独的 Transport，其它路由在拨号时按目标选择
+func getOrCreateProxyTransport(LocalAddr string, proxyUrl *url.URL, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) *http.Transport {
+	key := LocalAddr + "|direct"
+	if proxyUrl != nil && (proxyUrl.Scheme == "ws" || proxyUrl.Scheme == "wss") {
+		key = LocalAddr + "|" + proxyUrl.String()
+	} else {
+		proxyUrl = nil
+	}
+	if v, ok := proxyTransports.Load(key); ok {
+		return v.(*http.Transport)
+	}
+	transport := newProxyTransport(proxyUrl, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, Proxy, tranportConfigurations...)
+	if v, loaded := proxyTransports.LoadOrStore(key, transport); loaded {
+		return v.(*http.Transport)
+	}
+	log.Println("创建共享Transport:", key)
+	return transport
+}
+
+// applyTransportOptions 把连接池和超时设置应用到 transport
+func applyTransportOptions(transport *http.Transport) {
+	o := DefaultTransportOptions
+	if p := transportOptions.Load(); p != nil {
+		o = *p
+	}
+	transport.MaxIdleConns = o.MaxIdleConns
+	transport.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = o.MaxConnsPerHost
+	transport.IdleConnTimeout = o.IdleConnTimeout
+	transport.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+	transport.TLSHandshakeTimeout = o.TLSHandshakeTimeout
+	transport.ForceAttemptHTTP2 = !o.DisableHTTP2
+	if o.DisableHTTP2 {
+		// 非 nil 的空 TLSNextProto 关闭 HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	transport.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout: o.HTTP2PingInterval,
+		PingTimeout:     o.HTTP2PingTimeout,
+	}
+}
+
+// newProxyTransport 创建转发请求使用的 Transport：配置了DNS服务器时通过DNS缓存解析并拨号，
+// proxyUrl 不为 nil 时通过 WebSocket 上游建立连接
+func newProxyTransport(proxyUrl *url.URL, proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, Proxy func(*http.Request) (*url.URL, error), tranportConfigurations ...func(*http.Transport) *http.Transport) *http.Transport {
+	var transport *http.Transport
+	// 自定义 DialTLSContext 返回的连接通过 ALPN 协商出 h2 时 Transport 才会使用 HTTP/2
+	nextProtos := []string{"h2", "http/1.1"}
+	if p := transportOptions.Load(); p != nil && p.DisableHTTP2 {
+		nextProtos = []string{"http/1.1"}
+	}
+	if len(proxyoptions) > 0 {
+		transport = &http.Transport{
+			ForceAttemptHTTP2: true,
+			// 自定义 DialContext 函数
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+				var host, _, err = net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				if utils.IsLoopbackIP(host) {
+					dialer := options.NewDialer(network, addr)
+					return dialer.DialContext(ctx, network, addr)
+				}
+				// 解析出原地址中的端口
+				hostname, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				if IsIP(hostname) {
+					dialer := options.NewDialer(network, addr)
+					//				// 发起连接
+					return dialer.DialContext(ctx, network, addr)
+				}
+
+				// 如果启用了上游IP解析，先解析目标地址，出站配置仍按原始主机名选择
+				targetAddr := addr
+				ctx = options.WithOutboundHost(ctx, hostname)
+				if upstreamResolveIPs {
+					log.Printf("upstream-resolve-ips enabled, resolving target address %s before connection", targetAddr)
+
+					resolvedAddrs, err := resolveTargetAddressForAuth(targetAddr, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+					if err != nil {
+						log.Printf("Failed to resolve target address %s: %v, using original", targetAddr, err)
+						resolvedAddrs = []string{targetAddr}
+					}
+
+					// 使用轮询从解析的地址中选择一个
+					resolvedAddr := resolveTargetAddressForAuthWithRoundRobin(resolvedAddrs, targetAddr, ipPriority)
+
+					if upstreamResolveIPs && resolvedAddr != targetAddr {
+						log.Printf("Using resolved address %s instead of original %s", resolvedAddr, targetAddr)
+						targetAddr = resolvedAddr
+					}
+				}
+
+				return dnscache.Proxy_net_DialContextCached(ctx, network, targetAddr, proxyoptions, dnsCache, upstreamResolveIPs, Proxy, tranportConfigurations...)
+			},
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+				//				// 解析出原地址中的端口
+				hostname, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				// 如果启用了上游IP解析，先解析目标地址，出站配置仍按原始主机名选择
+				targetAddr := addr
+				ctx = options.WithOutboundHost(ctx, hostname)
+				if upstreamResolveIPs {
+					log.Printf("upstream-resolve-ips enabled, resolving TLS target address %s before connection", targetAddr)
+
+					resolvedAddrs, err := resolveTargetAddressForAuth(targetAddr, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+					if err != nil {
+						log.Printf("Failed to resolve TLS target address %s: %v, using original", targetAddr, err)
+						resolvedAddrs = []string{targetAddr}
+					}
+
+					// 使用轮询从解析的地址中选择一个
+					resolvedAddr := resolveTargetAddressForAuthWithRoundRobin(resolvedAddrs, targetAddr, ipPriority)
+
+					if upstreamResolveIPs && resolvedAddr != targetAddr {
+						log.Printf("Using resolved TLS address %s instead of original %s", resolvedAddr, targetAddr)
+						targetAddr = resolvedAddr
+
+						// 如果地址被解析为IP，需要更新hostname用于TLS配置
+						resolvedHostname, _, err := net.SplitHostPort(resolvedAddr)
+						if err == nil {
+							// 只有当解析后的hostname不是IP时才更新ServerName
+							// 如果是IP地址，保持原来的hostname作为SNI
+							if net.ParseIP(resolvedHostname) == nil {
+								hostname = resolvedHostname
+							}
+						}
+					}
+				}
+
+				//				// 用指定的 IP 地址和原端口创建新地址
+				//				newAddr := net.JoinHostPort(serverIP, port)
+				//				// 创建 net.Dialer 实例
+				//				dialer := &net.Dialer{}
+				//				// 发起连接
+				conn, err := dnscache.Proxy_net_DialContextCached(ctx, network, targetAddr, proxyoptions, dnsCache, upstreamResolveIPs, Proxy, tranportConfigurations...) //dialer.DialContext(ctx, network, newAddr)
+				if err != nil {
+					return nil, err
+				}
+				//			var address = addr
+				tlsConfig := &tls.Config{
+					ServerName: hostname,
+					NextProtos: nextProtos,
+				}
+				// 创建 TLS 连接
+				tlsConn := tls.Client(conn, tlsConfig)
+				// 进行 TLS 握手
+				err = tlsConn.HandshakeContext(ctx)
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+		}
+		for _, f := range tranportConfigurations {
+			transport = f(transport)
+		}
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	applyTransportOptions(transport)
+	if proxyUrl != nil {
+		transport.Proxy = nil
+		log.Println("已经修改了代理为websocket", proxyUrl.String())
+		var DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var host, _, err = net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if utils.IsLoopbackIP(host) {
+				dialer := options.NewDialer(network, addr)
+				return dialer.DialContext(ctx, network, addr)
+			}
+			log.Println("使用代理：" + proxyUrl.String())
+
+			log.Println("network,addr", network, addr)
+			return websocketDialContext(ctx, network, addr, proxyUrl, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority)
+		}
+		transport.DialContext = DialContext
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+			//				// 解析出原地址中的端口
+			hostname, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			//				// 用指定的 IP 地址和原端口创建新地址
+			//				newAddr := net.JoinHostPort(serverIP, port)
+			//				// 创建 net.Dialer 实例
+			//				dialer := &net.Dialer{}
+			//				// 发起连接
+			conn, err := DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			//			var address = addr
+			tlsConfig := &tls.Config{
+				ServerName: hostname,
+				NextProtos: nextProtos,
+			}
+			// 创建 TLS 连接
+			tlsConn := tls.Client(conn, tlsConfig)
+			// 进行 TLS 握手
+			err = tlsConn.HandshakeContext(ctx)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+	return transport
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+func TestProxyHandlerReusesConnections(t *testing.T) {
+	defer InvalidateTransports()
+
+	var conns atomic.Int32
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	origin.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	origin.Start()
+	defer origin.Close()
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, origin.URL+"/", nil)
+		w := httptest.NewRecorder()
+		if err := proxyHandler(w, r, "127.0.0.1:0", true, nil, nil, "", "", false, options.ParseIPPriority("random"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("Unexpected response %d %q", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Via") == "" {
+			t.Error("Expected Via header on the response")
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Expected one pooled connection to the origin, got %d", n)
+	}
+}
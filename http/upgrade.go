@@ -0,0 +1,72 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+// handleUpgradeResponse 处理上游的 101 Switching Protocols：把响应写回客户端后接管客户端连接，
+// 在客户端和上游之间双向转发原始数据，直到任一方关闭连接
+func handleUpgradeResponse(w http.ResponseWriter, upgrade string, resp *http.Response) error {
+	respUpgrade := options.UpgradeType(resp.Header)
+	if upgrade == "" || !strings.EqualFold(upgrade, respUpgrade) {
+		err := fmt.Errorf("backend tried to switch protocol %q when %q was requested", respUpgrade, upgrade)
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return nil
+	}
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		err := fmt.Errorf("switching protocols response with non-writable body %T", resp.Body)
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return nil
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		err := fmt.Errorf("can't switch protocols using non-Hijacker ResponseWriter %T", w)
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		log.Println("Hijack failed:", err)
+		return nil
+	}
+	defer conn.Close()
+
+	// 101 响应没有响应体，写出状态行和响应头后开始转发
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		log.Println("Error writing response to client:", err)
+		return nil
+	}
+	if err := brw.Flush(); err != nil {
+		log.Println("Error writing response to client:", err)
+		return nil
+	}
+	log.Println("协议升级:", upgrade)
+
+	// 使用双向goroutine并等待，避免goroutine泄漏；brw.Reader 中已缓冲的客户端数据会先发出
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backConn, brw.Reader)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, backConn)
+		errCh <- err
+	}()
+	// 等待任意一个方向完成后关闭两端，另一个方向随之返回
+	<-errCh
+	backConn.Close()
+	conn.Close()
+	<-errCh
+	return nil
+}
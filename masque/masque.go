@@ -0,0 +1,49 @@
+package masque
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/masx200/http-proxy-go-server/auth"
+	"github.com/masx200/http-proxy-go-server/dnscache"
+	http_server "github.com/masx200/http-proxy-go-server/http"
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/masx200/http-proxy-go-server/simple"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Masque 在 UDP 端口上启动 HTTP/3 代理：CONNECT 建立 TCP 隧道，connect-udp（RFC 9298）转发 UDP。
+// QUIC 连接支持连接迁移，客户端切换网络后隧道不断开。用户名和密码为空时不做身份验证
+func Masque(server_cert string, server_key, hostname string, port int, username, password string, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) {
+
+	cert, err := tls.LoadX509KeyPair(server_cert, server_key)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{http3.NextProtoH3}}
+
+	xh := http_server.GenerateRandomLoopbackIP()
+	x1 := http_server.GenerateRandomIntPort()
+	var upstreamAddress string = xh + ":" + fmt.Sprint(rune(x1))
+	go http_server.Http(xh, x1, proxyoptions, dnsCache, username, password, upstreamResolveIPs, ipPriority, Proxy, tranportConfigurations...)
+
+	var authenticate simple.Authenticator
+	if len(username) > 0 && len(password) > 0 {
+		authenticate = auth.NewAuthenticator(username, password)
+	}
+	addr := hostname + ":" + fmt.Sprint(port)
+	server := &http3.Server{
+		Addr:            addr,
+		TLSConfig:       config,
+		EnableDatagrams: true,
+		Handler:         simple.NewMASQUEHandler(addr, authenticate, upstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...),
+	}
+	log.Printf("MASQUE (HTTP/3) proxy server started on udp port %s", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Println(err)
+	}
+}
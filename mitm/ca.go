@@ -0,0 +1,184 @@
+package mitm
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCertCacheSize 叶子证书缓存默认保留的主机数
+const DefaultCertCacheSize = 1000
+
+// leafValidity 签发的叶子证书有效期，不超过 CA 证书本身的有效期
+const leafValidity = 365 * 24 * time.Hour
+
+// CA 用于 TLS 拦截的本地 CA：为被拦截的主机即时签发叶子证书，按主机缓存最近使用的证书（LRU）。
+// 所有叶子证书共用一个在加载 CA 时生成的 ECDSA P-256 私钥，签发只需一次签名
+type CA struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	leafKey *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	cacheSize int
+	lru       *list.List // 元素为 *cacheEntry，最近使用的在前
+	entries   map[string]*list.Element
+}
+
+type cacheEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+// LoadCA 从 PEM 文件加载 CA 证书和私钥，cacheSize <= 0 时使用 DefaultCertCacheSize
+func LoadCA(certFile, keyFile string, cacheSize int) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MITM CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MITM CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("MITM CA certificate %s is not a CA", cert.Subject)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported MITM CA private key type %T", pair.PrivateKey)
+	}
+	return NewCA(cert, key, cacheSize)
+}
+
+// NewCA 使用已解析的 CA 证书和私钥创建 CA，cacheSize <= 0 时使用 DefaultCertCacheSize
+func NewCA(cert *x509.Certificate, key crypto.Signer, cacheSize int) (*CA, error) {
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if cacheSize <= 0 {
+		cacheSize = DefaultCertCacheSize
+	}
+	return &CA{
+		cert:      cert,
+		key:       key,
+		leafKey:   leafKey,
+		cacheSize: cacheSize,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}, nil
+}
+
+// Certificate 返回 host（域名或 IP）的叶子证书，链中包含 CA 证书
+func (ca *CA) Certificate(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	if e, ok := ca.entries[host]; ok {
+		ca.lru.MoveToFront(e)
+		cert := e.Value.(*cacheEntry).cert
+		ca.mu.Unlock()
+		return cert, nil
+	}
+	ca.mu.Unlock()
+
+	cert, err := ca.issue(host)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	// 并发签发同一主机时使用先放入缓存的证书
+	if e, ok := ca.entries[host]; ok {
+		ca.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).cert, nil
+	}
+	ca.entries[host] = ca.lru.PushFront(&cacheEntry{host: host, cert: cert})
+	for ca.lru.Len() > ca.cacheSize {
+		oldest := ca.lru.Back()
+		ca.lru.Remove(oldest)
+		delete(ca.entries, oldest.Value.(*cacheEntry).host)
+	}
+	return cert, nil
+}
+
+// issue 签发 host 的叶子证书
+func (ca *CA) issue(host string) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		// 允许客户端与代理之间存在少量时钟偏差
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &ca.leafKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", host, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  ca.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+var (
+	currentCA         atomic.Pointer[CA]
+	interceptSelector atomic.Pointer[func(host string) bool]
+)
+
+// SetCA 设置用于 TLS 拦截的 CA，为 nil 时关闭拦截
+func SetCA(ca *CA) {
+	currentCA.Store(ca)
+}
+
+// SetInterceptSelector 设置按目标主机判断是否拦截 CONNECT 隧道的函数（路由规则中的 intercept）
+func SetInterceptSelector(fn func(host string) bool) {
+	interceptSelector.Store(&fn)
+}
+
+// ShouldIntercept 返回 CONNECT 到 addr（host:port）的隧道是否应被拦截，以及签发证书使用的 CA；
+// 没有配置 CA 或路由规则没有选中该主机时不拦截
+func ShouldIntercept(addr string) (*CA, bool) {
+	ca := currentCA.Load()
+	fn := interceptSelector.Load()
+	if ca == nil || fn == nil {
+		return nil, false
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	return ca, (*fn)(host)
+}
@@ -0,0 +1,78 @@
+package mitm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T, cacheSize int) *CA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test MITM CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewCA(cert, key, cacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func TestCACertificate(t *testing.T) {
+	ca := newTestCA(t, 1)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	for _, host := range []string{"api.example.com", "192.0.2.1", "2001:db8::1"} {
+		cert, err := ca.Certificate(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+		// 有效期不超过 CA 证书
+		if cert.Leaf.NotAfter.After(ca.cert.NotAfter) {
+			t.Errorf("%s: leaf expires after CA", host)
+		}
+	}
+}
+
+func TestCACertificateCache(t *testing.T) {
+	ca := newTestCA(t, 2)
+	a, _ := ca.Certificate("a.example")
+	b, _ := ca.Certificate("b.example")
+	if again, _ := ca.Certificate("a.example"); again != a {
+		t.Error("expected cached certificate for a.example")
+	}
+	// a.example 最近使用过，缓存满时淘汰 b.example
+	ca.Certificate("c.example")
+	if again, _ := ca.Certificate("a.example"); again != a {
+		t.Error("expected a.example to stay cached")
+	}
+	if again, _ := ca.Certificate("b.example"); again == b {
+		t.Error("expected b.example to be evicted")
+	}
+}
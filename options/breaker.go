@@ -0,0 +1,388 @@
+package options
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultCircuitBreakerFailures 连续失败多少次后断开，0 表示关闭熔断
+	DefaultCircuitBreakerFailures = 5
+	// DefaultCircuitBreakerOpenTimeout 断开后多久允许一次探测
+	DefaultCircuitBreakerOpenTimeout = 30 * time.Second
+
+	// circuitBreakerExpiry 最后一次失败之后多久没有新的失败时丢弃熔断器，失败过一次就不再访问的目标不会一直留在表中
+	circuitBreakerExpiry = 10 * time.Minute
+	// circuitBreakerSweepInterval 清理过期熔断器的最小间隔
+	circuitBreakerSweepInterval = time.Minute
+
+	// proxyStatusName Proxy-Status 响应头中代理自身的标识
+	proxyStatusName = "http-proxy-go-server"
+)
+
+// CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenError 目标的熔断器处于断开状态，请求被直接拒绝
+type CircuitOpenError struct {
+	Key        string
+	RetryAfter time.Duration
+	LastError  string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %s, retry in %v (last error: %s)", e.Key, e.RetryAfter.Round(time.Second), e.LastError)
+}
+
+// circuitBreaker 单个目标的熔断器，只记录失败过的目标，成功后从表中移除
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	probeAt   time.Time
+	lastError string
+	lastFail  time.Time
+}
+
+// CircuitBreakerStatus 熔断器状态快照，用于日志和管理接口
+type CircuitBreakerStatus struct {
+	Key       string `json:"key"`
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	OpenedAt  string `json:"opened_at,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+var (
+	circuitFailures = func() *atomic.Int64 {
+		v := new(atomic.Int64)
+		v.Store(DefaultCircuitBreakerFailures)
+		return v
+	}()
+	circuitOpenTimeout = func() *atomic.Int64 {
+		v := new(atomic.Int64)
+		v.Store(int64(DefaultCircuitBreakerOpenTimeout))
+		return v
+	}()
+	// circuitBreakers 键（host:port、upstream:名称、dns:服务器）-> *circuitBreaker
+	circuitBreakers sync.Map
+	// upstreamNames 上游代理地址（host:port）-> 上游名称
+	upstreamNames sync.Map
+	// lastCircuitSweep 上次清理过期熔断器的时间（UnixNano）
+	lastCircuitSweep atomic.Int64
+)
+
+// SetCircuitBreaker 设置连续失败阈值和断开时间，failures <= 0 时关闭熔断
+func SetCircuitBreaker(failures int, openTimeout time.Duration) {
+	circuitFailures.Store(int64(failures))
+	if openTimeout > 0 {
+		circuitOpenTimeout.Store(int64(openTimeout))
+	}
+	if failures <= 0 {
+		circuitBreakers.Range(func(key, _ any) bool {
+			circuitBreakers.Delete(key)
+			return true
+		})
+	}
+}
+
+// CircuitBreakerAllow 检查是否允许连接 key，断开时返回 *CircuitOpenError；
+// 断开超过设定时间后进入半开状态，只放行一个探测请求
+func CircuitBreakerAllow(key string) error {
+	if circuitFailures.Load() <= 0 {
+		return nil
+	}
+	v, ok := circuitBreakers.Load(key)
+	if !ok {
+		return nil
+	}
+	b := v.(*circuitBreaker)
+	openTimeout := time.Duration(circuitOpenTimeout.Load())
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if wait := b.openedAt.Add(openTimeout).Sub(now); wait > 0 {
+			return &CircuitOpenError{Key: key, RetryAfter: wait, LastError: b.lastError}
+		}
+		b.state = CircuitHalfOpen
+		log.Printf("熔断器半开，允许探测: %s", key)
+	case CircuitHalfOpen:
+		// 探测请求未返回结果（例如调用方没有报告）超过断开时间时允许再次探测
+		if b.probing && now.Sub(b.probeAt) < openTimeout {
+			return &CircuitOpenError{Key: key, RetryAfter: openTimeout - now.Sub(b.probeAt), LastError: b.lastError}
+		}
+	default:
+		return nil
+	}
+	b.probing = true
+	b.probeAt = now
+	return nil
+}
+
+// CircuitBreakerReport 报告连接 key 的结果，err 为 nil 表示成功；
+// 调用方主动取消的请求不计入失败
+func CircuitBreakerReport(key string, err error) {
+	threshold := circuitFailures.Load()
+	if threshold <= 0 {
+		return
+	}
+	if err == nil {
+		if v, ok := circuitBreakers.LoadAndDelete(key); ok {
+			b := v.(*circuitBreaker)
+			b.mu.Lock()
+			if b.state != CircuitClosed {
+				log.Printf("熔断器恢复: %s", key)
+			}
+			b.mu.Unlock()
+		}
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		return
+	}
+
+	now := time.Now()
+	sweepCircuitBreakers(now)
+	v, _ := circuitBreakers.LoadOrStore(key, &circuitBreaker{})
+	b := v.(*circuitBreaker)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	b.lastFail = now
+	switch {
+	case b.state == CircuitHalfOpen:
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+		b.probing = false
+		log.Printf("熔断器探测失败，重新断开: %s: %v", key, err)
+	case b.state == CircuitClosed && int64(b.failures) >= threshold:
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+		log.Printf("熔断器断开: %s 连续失败 %d 次: %v", key, b.failures, err)
+	}
+}
+
+// sweepCircuitBreakers 丢弃超过 circuitBreakerExpiry 没有新失败、且不在断开时间内的熔断器，
+// 每 circuitBreakerSweepInterval 最多执行一次
+func sweepCircuitBreakers(now time.Time) {
+	last := lastCircuitSweep.Load()
+	if now.UnixNano()-last < int64(circuitBreakerSweepInterval) || !lastCircuitSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	openTimeout := time.Duration(circuitOpenTimeout.Load())
+	circuitBreakers.Range(func(key, value any) bool {
+		b := value.(*circuitBreaker)
+		b.mu.Lock()
+		expired := now.Sub(b.lastFail) > circuitBreakerExpiry && (b.state == CircuitClosed || now.Sub(b.openedAt) > openTimeout)
+		b.mu.Unlock()
+		if expired {
+			circuitBreakers.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// circuitBreakerExempt 不经过熔断器的拨号：UDP 没有握手，拨号成功与否说明不了目标是否可用；
+// 回环地址上是本进程的内部代理，它不可用时熔断也无济于事
+func circuitBreakerExempt(network, addr string) bool {
+	if strings.HasPrefix(network, "udp") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return strings.EqualFold(host, "localhost")
+}
+
+// CircuitBreakerDial 在熔断器保护下执行 dial，key 为目标地址；UDP 和回环地址的拨号不经过熔断器
+func CircuitBreakerDial(network, key string, dial func() (net.Conn, error)) (net.Conn, error) {
+	if circuitBreakerExempt(network, key) {
+		return dial()
+	}
+	if err := CircuitBreakerAllow(key); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	conn, err := dial()
+	CircuitBreakerReport(key, err)
+	return conn, err
+}
+
+// CircuitBreakerStates 返回所有记录了失败的目标的熔断器状态
+func CircuitBreakerStates() []CircuitBreakerStatus {
+	var states []CircuitBreakerStatus
+	circuitBreakers.Range(func(key, value any) bool {
+		b := value.(*circuitBreaker)
+		b.mu.Lock()
+		status := CircuitBreakerStatus{
+			Key:       key.(string),
+			State:     b.state.String(),
+			Failures:  b.failures,
+			LastError: b.lastError,
+		}
+		if !b.openedAt.IsZero() {
+			status.OpenedAt = b.openedAt.Format(time.RFC3339)
+		}
+		b.mu.Unlock()
+		states = append(states, status)
+		return true
+	})
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states
+}
+
+// CircuitBreakerHandler 以 JSON 返回熔断器状态（GET /breakers）
+func CircuitBreakerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		states := CircuitBreakerStates()
+		if states == nil {
+			states = []CircuitBreakerStatus{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	})
+}
+
+// RegisterUpstreamName 记录上游代理地址对应的名称，使上游的熔断器按名称区分
+func RegisterUpstreamName(name string, proxyURL string) {
+	if !strings.Contains(proxyURL, "://") {
+		proxyURL = "http://" + proxyURL
+	}
+	if u, err := url.Parse(proxyURL); err == nil && u.Host != "" {
+		upstreamNames.Store(u.Host, name)
+	}
+}
+
+// UpstreamCircuitKey 返回上游代理的熔断器键
+func UpstreamCircuitKey(proxyURL *url.URL) string {
+	if name, ok := upstreamNames.Load(proxyURL.Host); ok {
+		return "upstream:" + name.(string)
+	}
+	return "upstream:" + proxyURL.Host
+}
+
+// ProxyErrorStatus 按连接错误选择返回给客户端的状态码和 Proxy-Status（RFC 9209）响应头
+func ProxyErrorStatus(err error) (int, string) {
+	var open *CircuitOpenError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	code, errorType := http.StatusBadGateway, "destination_unavailable"
+	switch {
+	case errors.As(err, &open):
+		errorType = "destination_unavailable"
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsTimeout {
+			code, errorType = http.StatusGatewayTimeout, "dns_timeout"
+		} else {
+			errorType = "dns_error"
+		}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		code, errorType = http.StatusGatewayTimeout, "connection_timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		errorType = "connection_refused"
+	}
+	return code, fmt.Sprintf("%s; error=%s; details=%q", proxyStatusName, errorType, err.Error())
+}
+
+// SetProxyErrorHeaders 设置 Proxy-Status 和熔断时的 Retry-After 响应头，返回状态码
+func SetProxyErrorHeaders(h http.Header, err error) int {
+	code, status := ProxyErrorStatus(err)
+	h.Set("Proxy-Status", status)
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		h.Set("Retry-After", fmt.Sprint(int(open.RetryAfter.Round(time.Second)/time.Second)+1))
+	}
+	return code
+}
+
+// WriteProxyError 向原始客户端连接写出连接失败的响应（502 或 504）
+func WriteProxyError(w io.Writer, err error) {
+	h := http.Header{}
+	code := SetProxyErrorHeaders(h, err)
+	h.Set("Content-Length", "0")
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	h.Write(w)
+	fmt.Fprint(w, "\r\n")
+}
+
+// isUnavailableError 是否为网络错误或超时；DNS 服务器正常返回的应答错误（如 NXDOMAIN）不计入熔断
+func isUnavailableError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ReportUpstreamResult 报告一次通过上游代理的连接结果，只有网络错误和超时计入失败，
+// 上游返回的错误应答（例如目标不可达）说明上游本身可用
+func ReportUpstreamResult(key string, err error) {
+	if err != nil && !isUnavailableError(err) {
+		err = nil
+	}
+	CircuitBreakerReport(key, err)
+}
+
+// DNSServerCircuitKey 返回DNS服务器的熔断器键
+func DNSServerCircuitKey(opt ProxyOptionDNS) string {
+	return "dns:" + opt.ServerURL()
+}
+
+// ReportDNSServerResult 报告一次向 opt 的查询结果，只有网络错误和超时计入失败
+func ReportDNSServerResult(opt ProxyOptionDNS, resolved bool, errs []error) {
+	key := DNSServerCircuitKey(opt)
+	if !resolved {
+		for _, err := range errs {
+			if isUnavailableError(err) {
+				CircuitBreakerReport(key, err)
+				return
+			}
+		}
+	}
+	CircuitBreakerReport(key, nil)
+}
@@ -0,0 +1,113 @@
+package options
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	SetCircuitBreaker(3, 50*time.Millisecond)
+	defer SetCircuitBreaker(DefaultCircuitBreakerFailures, DefaultCircuitBreakerOpenTimeout)
+
+	key := "down.example:443"
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for i := 0; i < 3; i++ {
+		if err := CircuitBreakerAllow(key); err != nil {
+			t.Fatalf("Expected attempt %d to be allowed: %v", i, err)
+		}
+		CircuitBreakerReport(key, dialErr)
+	}
+	// 主动取消的请求不计入失败
+	CircuitBreakerReport("other.example:443", context.Canceled)
+	if states := CircuitBreakerStates(); len(states) != 1 || states[0].State != "open" {
+		t.Fatalf("Expected one open breaker, got %+v", states)
+	}
+
+	err := CircuitBreakerAllow(key)
+	var open *CircuitOpenError
+	if !errors.As(err, &open) {
+		t.Fatalf("Expected circuit open error, got %v", err)
+	}
+	h := http.Header{}
+	if code := SetProxyErrorHeaders(h, err); code != http.StatusBadGateway {
+		t.Errorf("Expected 502 for open circuit, got %d", code)
+	}
+	if !strings.Contains(h.Get("Proxy-Status"), "error=destination_unavailable") || h.Get("Retry-After") == "" {
+		t.Errorf("Unexpected headers %v", h)
+	}
+
+	// 断开时间过后只放行一个探测连接
+	time.Sleep(60 * time.Millisecond)
+	if err := CircuitBreakerAllow(key); err != nil {
+		t.Fatalf("Expected probe to be allowed: %v", err)
+	}
+	if err := CircuitBreakerAllow(key); err == nil {
+		t.Fatal("Expected concurrent requests to fail fast while probing")
+	}
+	CircuitBreakerReport(key, nil)
+	if err := CircuitBreakerAllow(key); err != nil {
+		t.Errorf("Expected breaker to close after a successful probe: %v", err)
+	}
+	if states := CircuitBreakerStates(); len(states) != 0 {
+		t.Errorf("Expected recovered breaker to be removed, got %+v", states)
+	}
+}
+
+func TestReportUpstreamResultIgnoresProxyReplies(t *testing.T) {
+	SetCircuitBreaker(1, time.Minute)
+	defer SetCircuitBreaker(DefaultCircuitBreakerFailures, DefaultCircuitBreakerOpenTimeout)
+
+	// 上游返回错误状态码说明上游可用
+	ReportUpstreamResult("upstream:test", errors.New("proxy returned status code 403"))
+	if err := CircuitBreakerAllow("upstream:test"); err != nil {
+		t.Errorf("Expected proxy reply not to open the breaker: %v", err)
+	}
+	ReportUpstreamResult("upstream:test", context.DeadlineExceeded)
+	if err := CircuitBreakerAllow("upstream:test"); err == nil {
+		t.Error("Expected timeout to open the breaker")
+	}
+	if code, _ := ProxyErrorStatus(context.DeadlineExceeded); code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 for timeout, got %d", code)
+	}
+}
+
+func TestCircuitBreakerExemptAndExpiry(t *testing.T) {
+	SetCircuitBreaker(0, 0) // 清空其它测试留下的熔断器
+	SetCircuitBreaker(1, time.Minute)
+	defer SetCircuitBreaker(DefaultCircuitBreakerFailures, DefaultCircuitBreakerOpenTimeout)
+
+	// UDP 和回环地址的拨号不记录熔断器
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, c := range []struct{ network, addr string }{
+		{"udp", "dns.example:53"},
+		{"tcp", "127.0.0.5:8080"},
+		{"tcp", "[::1]:8080"},
+		{"tcp", "localhost:8080"},
+	} {
+		CircuitBreakerDial(c.network, c.addr, func() (net.Conn, error) { return nil, dialErr })
+	}
+	if states := CircuitBreakerStates(); len(states) != 0 {
+		t.Fatalf("Expected no breakers for exempt dials, got %+v", states)
+	}
+
+	// 很久没有新失败、断开时间也已过去的熔断器在下次清理时丢弃
+	CircuitBreakerDial("tcp", "old.example:443", func() (net.Conn, error) { return nil, dialErr })
+	v, _ := circuitBreakers.Load("old.example:443")
+	b := v.(*circuitBreaker)
+	b.mu.Lock()
+	b.lastFail = time.Now().Add(-2 * circuitBreakerExpiry)
+	b.openedAt = b.lastFail
+	b.mu.Unlock()
+	lastCircuitSweep.Store(0)
+	CircuitBreakerReport("new.example:443", dialErr)
+	states := CircuitBreakerStates()
+	if len(states) != 1 || states[0].Key != "new.example:443" {
+		t.Errorf("Expected only the recent breaker to remain, got %+v", states)
+	}
+	circuitBreakers.Delete("new.example:443")
+}
@@ -0,0 +1,108 @@
+package options
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// MatchDomainPattern 检查域名是否匹配分流规则中的模式
+// 支持 "*"（匹配所有）、"*.example.com"（匹配子域名及 example.com 本身）和精确域名
+// 返回是否匹配以及匹配的精确程度，越具体的模式得分越高
+func MatchDomainPattern(pattern string, domain string) (bool, int) {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if pattern == "*" {
+		return true, 0
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[2:]
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true, len(suffix)
+		}
+		return false, 0
+	}
+	if pattern == domain {
+		// 精确匹配优先于任何通配符
+		return true, 1000 + len(pattern)
+	}
+	return false, 0
+}
+
+// SelectDNSOptions 根据域名选择应当使用的DNS服务器（分流DNS）
+// 带有 Domains 的配置只用于匹配的域名，多个规则都匹配时选择最具体的规则；
+// 没有 Domains 的配置视为 "*"。返回的是新切片，调用方可以放心打乱顺序
+func SelectDNSOptions(proxyoptions ProxyOptionsDNSSLICE, domain string) ProxyOptionsDNSSLICE {
+	best := -1
+	var selected ProxyOptionsDNSSLICE
+	for _, opt := range proxyoptions {
+		score := -1
+		if len(opt.Domains) == 0 {
+			score = 0
+		} else {
+			for _, pattern := range opt.Domains {
+				if ok, s := MatchDomainPattern(pattern, domain); ok && s > score {
+					score = s
+				}
+			}
+		}
+		if score < 0 {
+			continue
+		}
+		if score > best {
+			best = score
+			selected = ProxyOptionsDNSSLICE{}
+		}
+		if score == best {
+			selected = append(selected, opt)
+		}
+	}
+	return selected
+}
+
+// ParseDNSServer 将分流规则中的服务器地址解析为 ProxyOptionDNS
+// 支持 https://（DoH）、h3://（DoH3）、tls://（DoT）、quic://（DoQ）、
+// udp://、tcp://、sdns://（DNS stamp）以及不带协议的 IP[:port]（视为 udp）
+func ParseDNSServer(server string, domains []string) (ProxyOptionDNS, error) {
+	if IsDNSStamp(server) {
+		return ParseDNSStamp(server, domains)
+	}
+	if !strings.Contains(server, "://") {
+		server = "udp://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return ProxyOptionDNS{}, fmt.Errorf("invalid dns server %s: %v", server, err)
+	}
+	if u.Hostname() == "" {
+		return ProxyOptionDNS{}, fmt.Errorf("invalid dns server %s: missing host", server)
+	}
+	opt := ProxyOptionDNS{Domains: domains}
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		opt.Protocol = "doh"
+		opt.Dohurl = server
+	case "h3":
+		u.Scheme = "https"
+		opt.Protocol = "doh3"
+		opt.Dohalpn = "h3"
+		opt.Dohurl = u.String()
+	case "tls":
+		opt.Protocol = "dot"
+		opt.Doturl = server
+	case "quic":
+		opt.Protocol = "doq"
+		opt.Doqurl = server
+	case "udp", "tcp":
+		port := u.Port()
+		if port == "" {
+			port = "53"
+		}
+		opt.Protocol = strings.ToLower(u.Scheme)
+		opt.Dnsaddr = net.JoinHostPort(u.Hostname(), port)
+	default:
+		return ProxyOptionDNS{}, fmt.Errorf("unsupported dns server scheme: %s", u.Scheme)
+	}
+	return opt, nil
+}
@@ -0,0 +1,105 @@
+package options
+
+import "testing"
+
+func TestMatchDomainPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, domain string
+		match           bool
+	}{
+		{"*", "anything.example", true},
+		{"*.corp.example", "corp.example", true},
+		{"*.corp.example", "a.b.corp.example.", true},
+		{"*.corp.example", "notcorp.example", false},
+		{"Host.Corp.Example", "host.corp.example", true},
+		{"host.corp.example", "www.host.corp.example", false},
+	} {
+		if ok, _ := MatchDomainPattern(tc.pattern, tc.domain); ok != tc.match {
+			t.Errorf("MatchDomainPattern(%q, %q) = %v, want %v", tc.pattern, tc.domain, ok, tc.match)
+		}
+	}
+
+	// 精确域名比通配符更具体，较长的后缀比较短的后缀更具体
+	_, exact := MatchDomainPattern("host.corp.example", "host.corp.example")
+	_, long := MatchDomainPattern("*.corp.example", "host.corp.example")
+	_, short := MatchDomainPattern("*.example", "host.corp.example")
+	if !(exact > long && long > short) {
+		t.Errorf("Unexpected scores exact=%d long=%d short=%d", exact, long, short)
+	}
+}
+
+func TestSelectDNSOptions(t *testing.T) {
+	fallback := ProxyOptionDNS{Protocol: "udp", Dnsaddr: "192.0.2.1:53"}
+	corp := ProxyOptionDNS{Protocol: "udp", Dnsaddr: "192.0.2.2:53", Domains: []string{"*.corp.example"}}
+	corp2 := ProxyOptionDNS{Protocol: "tcp", Dnsaddr: "192.0.2.3:53", Domains: []string{"*.corp.example"}}
+	host := ProxyOptionDNS{Protocol: "udp", Dnsaddr: "192.0.2.4:53", Domains: []string{"vpn.corp.example"}}
+	opts := ProxyOptionsDNSSLICE{fallback, corp, corp2, host}
+
+	addrs := func(selected ProxyOptionsDNSSLICE) []string {
+		var out []string
+		for _, o := range selected {
+			out = append(out, o.Dnsaddr)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		domain string
+		want   []string
+	}{
+		{"www.example.org", []string{"192.0.2.1:53"}},
+		{"git.corp.example", []string{"192.0.2.2:53", "192.0.2.3:53"}},
+		{"vpn.corp.example.", []string{"192.0.2.4:53"}},
+	} {
+		got := addrs(SelectDNSOptions(opts, tc.domain))
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.domain, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: expected %v, got %v", tc.domain, tc.want, got)
+				break
+			}
+		}
+	}
+
+	// 没有兜底服务器时，不匹配任何规则的域名得不到服务器
+	if got := SelectDNSOptions(ProxyOptionsDNSSLICE{corp}, "www.example.org"); len(got) != 0 {
+		t.Errorf("Expected no servers, got %v", got)
+	}
+}
+
+func TestParseDNSServer(t *testing.T) {
+	for _, tc := range []struct {
+		server string
+		want   ProxyOptionDNS
+	}{
+		{"https://dns.example/dns-query", ProxyOptionDNS{Protocol: "doh", Dohurl: "https://dns.example/dns-query"}},
+		{"h3://dns.example/dns-query", ProxyOptionDNS{Protocol: "doh3", Dohalpn: "h3", Dohurl: "https://dns.example/dns-query"}},
+		{"tls://dns.example", ProxyOptionDNS{Protocol: "dot", Doturl: "tls://dns.example"}},
+		{"quic://dns.example:8853", ProxyOptionDNS{Protocol: "doq", Doqurl: "quic://dns.example:8853"}},
+		{"tcp://192.0.2.1", ProxyOptionDNS{Protocol: "tcp", Dnsaddr: "192.0.2.1:53"}},
+		{"192.0.2.1:5353", ProxyOptionDNS{Protocol: "udp", Dnsaddr: "192.0.2.1:5353"}},
+		{"[2001:db8::1]", ProxyOptionDNS{Protocol: "udp", Dnsaddr: "[2001:db8::1]:53"}},
+	} {
+		got, err := ParseDNSServer(tc.server, []string{"*.corp.example"})
+		if err != nil {
+			t.Errorf("%s: %v", tc.server, err)
+			continue
+		}
+		if len(got.Domains) != 1 || got.Domains[0] != "*.corp.example" {
+			t.Errorf("%s: domains not kept, got %v", tc.server, got.Domains)
+		}
+		got.Domains = nil
+		if got.Protocol != tc.want.Protocol || got.Dohurl != tc.want.Dohurl || got.Dohalpn != tc.want.Dohalpn ||
+			got.Doturl != tc.want.Doturl || got.Doqurl != tc.want.Doqurl || got.Dnsaddr != tc.want.Dnsaddr {
+			t.Errorf("%s: expected %+v, got %+v", tc.server, tc.want, got)
+		}
+	}
+
+	for _, server := range []string{"ftp://dns.example", "udp://", "https://"} {
+		if _, err := ParseDNSServer(server, nil); err == nil {
+			t.Errorf("%s: expected error", server)
+		}
+	}
+}
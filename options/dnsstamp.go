@@ -0,0 +1,67 @@
+package options
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ameshkov/dnsstamps"
+)
+
+// IsDNSStamp 判断地址是否为 sdns:// 格式的 DNS stamp
+func IsDNSStamp(server string) bool {
+	return strings.HasPrefix(strings.ToLower(server), "sdns://")
+}
+
+// stampHost 去掉 stamp 中服务器地址的端口，只保留IP
+func stampHost(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// ParseDNSStamp 将 sdns:// 格式的 DNS stamp 解析为 ProxyOptionDNS
+// 根据 stamp 协议类型分别转换为 DNSCrypt、DoH、DoT、DoQ 或明文 UDP 配置，
+// stamp 中的服务器地址作为对应协议的 IP（dohip/dotip/doqip）使用
+func ParseDNSStamp(stamp string, domains []string) (ProxyOptionDNS, error) {
+	s, err := dnsstamps.NewServerStampFromString(stamp)
+	if err != nil {
+		return ProxyOptionDNS{}, fmt.Errorf("invalid dns stamp %s: %v", stamp, err)
+	}
+	opt := ProxyOptionDNS{Domains: domains}
+	switch s.Proto {
+	case dnsstamps.StampProtoTypeDNSCrypt:
+		opt.Protocol = "dnscrypt"
+		opt.Stamp = stamp
+	case dnsstamps.StampProtoTypeDoH:
+		path := s.Path
+		if path == "" {
+			path = "/dns-query"
+		}
+		opt.Protocol = "doh"
+		opt.Dohurl = "https://" + s.ProviderName + path
+		opt.Dohip = stampHost(s.ServerAddrStr)
+	case dnsstamps.StampProtoTypeTLS:
+		opt.Protocol = "dot"
+		opt.Doturl = "tls://" + s.ProviderName
+		opt.Dotip = stampHost(s.ServerAddrStr)
+	case dnsstamps.StampProtoTypeDoQ:
+		opt.Protocol = "doq"
+		opt.Doqurl = "quic://" + s.ProviderName
+		opt.Doqip = stampHost(s.ServerAddrStr)
+	case dnsstamps.StampProtoTypePlain:
+		addr := s.ServerAddrStr
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(stampHost(addr), "53")
+		}
+		opt.Protocol = "udp"
+		opt.Dnsaddr = addr
+	default:
+		return ProxyOptionDNS{}, fmt.Errorf("unsupported dns stamp protocol: %s", s.Proto.String())
+	}
+	return opt, nil
+}
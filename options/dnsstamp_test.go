@@ -0,0 +1,65 @@
+package options
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/ameshkov/dnsstamps"
+)
+
+func TestParseDNSStamp(t *testing.T) {
+	dnscrypt := (&dnsstamps.ServerStamp{
+		Proto:         dnsstamps.StampProtoTypeDNSCrypt,
+		ServerAddrStr: "192.0.2.1:5443",
+		ServerPk:      bytes.Repeat([]byte{1}, 32),
+		ProviderName:  "2.dnscrypt-cert.example.test",
+	}).String()
+
+	for _, tc := range []struct {
+		name  string
+		stamp dnsstamps.ServerStamp
+		want  ProxyOptionDNS
+	}{
+		{"doh", dnsstamps.ServerStamp{Proto: dnsstamps.StampProtoTypeDoH, ServerAddrStr: "192.0.2.2", ProviderName: "dns.example", Path: "/q"},
+			ProxyOptionDNS{Protocol: "doh", Dohurl: "https://dns.example/q", Dohip: "192.0.2.2"}},
+		{"doh default path", dnsstamps.ServerStamp{Proto: dnsstamps.StampProtoTypeDoH, ProviderName: "dns.example"},
+			ProxyOptionDNS{Protocol: "doh", Dohurl: "https://dns.example/dns-query"}},
+		{"dot", dnsstamps.ServerStamp{Proto: dnsstamps.StampProtoTypeTLS, ServerAddrStr: "192.0.2.3:853", ProviderName: "dns.example"},
+			ProxyOptionDNS{Protocol: "dot", Doturl: "tls://dns.example", Dotip: "192.0.2.3"}},
+		{"doq", dnsstamps.ServerStamp{Proto: dnsstamps.StampProtoTypeDoQ, ServerAddrStr: "[2001:db8::1]", ProviderName: "dns.example"},
+			ProxyOptionDNS{Protocol: "doq", Doqurl: "quic://dns.example", Doqip: "2001:db8::1"}},
+		{"plain", dnsstamps.ServerStamp{Proto: dnsstamps.StampProtoTypePlain, ServerAddrStr: "192.0.2.4"},
+			ProxyOptionDNS{Protocol: "udp", Dnsaddr: "192.0.2.4:53"}},
+	} {
+		stamp := tc.stamp.String()
+		if !IsDNSStamp(stamp) {
+			t.Errorf("%s: %s not recognized as a stamp", tc.name, stamp)
+		}
+		got, err := ParseDNSServer(stamp, []string{"*.corp.example"})
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(got.Domains) != 1 {
+			t.Errorf("%s: domains not kept, got %v", tc.name, got.Domains)
+		}
+		got.Domains = nil
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.want, got)
+		}
+	}
+
+	// DNSCrypt 保留原始 stamp，由 DNSCrypt 客户端自行解析
+	got, err := ParseDNSStamp(dnscrypt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Protocol != "dnscrypt" || got.Stamp != dnscrypt {
+		t.Errorf("Unexpected DNSCrypt option %+v", got)
+	}
+
+	if _, err := ParseDNSStamp("sdns://not-a-stamp", nil); err == nil {
+		t.Error("Expected error for invalid stamp")
+	}
+}
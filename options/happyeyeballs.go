@@ -0,0 +1,206 @@
+package options
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHappyEyeballsDelay RFC 8305 推荐的连接尝试间隔
+	DefaultHappyEyeballsDelay = 250 * time.Millisecond
+	// familyCacheTTL 记住某个目标上次成功的地址族的时间
+	familyCacheTTL = 10 * time.Minute
+	// familySweepInterval 清理过期地址族记录的最小间隔
+	familySweepInterval = time.Minute
+)
+
+var (
+	happyEyeballsDelay = func() *atomic.Int64 {
+		v := new(atomic.Int64)
+		v.Store(int64(DefaultHappyEyeballsDelay))
+		return v
+	}()
+	// preferIPv4 未记录目标的成功地址族时是否先尝试 IPv4，RFC 8305 默认先尝试 IPv6
+	preferIPv4 atomic.Bool
+	// familyCache 目标主机 -> familyResult，记录上次先连接成功的地址族，过期记录由 rememberFamily 定期清理
+	familyCache sync.Map
+	// lastFamilySweep 上次清理 familyCache 的时间（UnixNano）
+	lastFamilySweep atomic.Int64
+
+	// happyEyeballsDial 实际发起单个连接尝试的函数，测试中替换
+	happyEyeballsDial = DialContext
+)
+
+type familyResult struct {
+	ipv6    bool
+	expires time.Time
+}
+
+// SetHappyEyeballsDelay 设置相邻两次连接尝试之间的间隔，<= 0 时退化为逐个顺序尝试
+func SetHappyEyeballsDelay(d time.Duration) {
+	happyEyeballsDelay.Store(int64(d))
+}
+
+// SetHappyEyeballsPreferIPv4 设置没有历史记录时先尝试 IPv4（对应 -ip-priority ipv4）
+func SetHappyEyeballsPreferIPv4(prefer bool) {
+	preferIPv4.Store(prefer)
+}
+
+// preferredIPv6 返回连接 host 时是否先尝试 IPv6
+func preferredIPv6(host string) bool {
+	if v, ok := familyCache.Load(host); ok {
+		if r := v.(familyResult); time.Now().Before(r.expires) {
+			return r.ipv6
+		}
+		familyCache.Delete(host)
+	}
+	return !preferIPv4.Load()
+}
+
+// rememberFamily 记住 host 这次先连接成功的地址族，并每隔 familySweepInterval 清理一次过期记录，
+// 只访问过一次的目标不会一直留在表中
+func rememberFamily(host string, ip net.IP) {
+	now := time.Now()
+	familyCache.Store(host, familyResult{ipv6: ip.To4() == nil, expires: now.Add(familyCacheTTL)})
+	last := lastFamilySweep.Load()
+	if now.UnixNano()-last < int64(familySweepInterval) || !lastFamilySweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	familyCache.Range(func(key, value any) bool {
+		if now.After(value.(familyResult).expires) {
+			familyCache.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// InterleaveIPs 按 RFC 8305 第 4 节交替排列两个地址族，首选地址族在前，同一地址族内保持原有顺序
+func InterleaveIPs(ips []net.IP, preferIPv6 bool) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	first, second := v4, v6
+	if preferIPv6 {
+		first, second = v6, v4
+	}
+	result := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			result = append(result, first[i])
+		}
+		if i < len(second) {
+			result = append(result, second[i])
+		}
+	}
+	return result
+}
+
+// filterIPsByNetwork 按 tcp4/tcp6 等网络类型过滤地址
+func filterIPsByNetwork(network string, ips []net.IP) []net.IP {
+	switch network {
+	case "tcp4", "udp4", "ip4":
+	case "tcp6", "udp6", "ip6":
+	default:
+		return ips
+	}
+	want4 := network[len(network)-1] == '4'
+	var filtered []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == want4 {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
+// DialHappyEyeballs 按 RFC 8305 (Happy Eyeballs v2) 连接 host 解析出的地址：
+// 两个地址族交替排列，每隔一个间隔（默认 250ms）或上一个尝试失败时发起下一个连接尝试，
+// 第一个成功的连接胜出，其余尝试被取消；TCP 连接胜出的地址族会被记住，之后连接同一目标时优先尝试。
+// A 和 AAAA 记录由解析器并发查询，这里只负责连接阶段。
+func DialHappyEyeballs(ctx context.Context, network, host, port string, ips []net.IP) (net.Conn, error) {
+	ips = filterIPsByNetwork(network, ips)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no %s addresses to dial for %s", network, host)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// 出站配置按原始主机名选择，而不是解析出的 IP
+	ctx = WithOutboundHost(ctx, host)
+	ordered := InterleaveIPs(ips, preferredIPv6(host))
+	delay := time.Duration(happyEyeballsDelay.Load())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		ip   net.IP
+		err  error
+	}
+	results := make(chan attempt, len(ordered))
+	next, pending := 0, 0
+	startNext := func() {
+		ip := ordered[next]
+		next++
+		pending++
+		go func() {
+			conn, err := happyEyeballsDial(ctx, network, net.JoinHostPort(ip.String(), port))
+			results <- attempt{conn, ip, err}
+		}()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	startNext()
+
+	var errs []error
+	for pending > 0 {
+		var timerC <-chan time.Time
+		if delay > 0 && next < len(ordered) {
+			timerC = timer.C
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				// 关闭取消前已经建立的其它连接
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				// UDP 的“连接”不经过握手，成功与否说明不了地址族是否可达，只记录 TCP 的结果
+				if strings.HasPrefix(network, "tcp") {
+					rememberFamily(host, r.ip)
+				}
+				log.Printf("Happy Eyeballs connected to %s via %s", host, r.ip)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			// 上一个尝试失败时立即开始下一个，不必等待间隔
+			if next < len(ordered) {
+				startNext()
+				timer.Reset(delay)
+			}
+		case <-timerC:
+			startNext()
+			timer.Reset(delay)
+		}
+	}
+	return nil, ErrorArray(errs)
+}
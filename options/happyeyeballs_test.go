@@ -0,0 +1,129 @@
+package options
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterleaveIPs(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3"),
+		net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"),
+	}
+	want := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"}
+	got := InterleaveIPs(ips, true)
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+// fakeConn 只用于区分胜出的连接
+type fakeConn struct {
+	net.Conn
+	addr   string
+	closed chan struct{}
+}
+
+func (c *fakeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestDialHappyEyeballsBrokenIPv6(t *testing.T) {
+	SetHappyEyeballsDelay(50 * time.Millisecond)
+	defer SetHappyEyeballsDelay(DefaultHappyEyeballsDelay)
+
+	defer func(orig func(context.Context, string, string) (net.Conn, error)) { happyEyeballsDial = orig }(happyEyeballsDial)
+	var mu sync.Mutex
+	var started []string
+	happyEyeballsDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		started = append(started, addr)
+		mu.Unlock()
+		if host, _, _ := net.SplitHostPort(addr); net.ParseIP(host).To4() == nil {
+			// IPv6 路由不通，直到被取消才返回
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &fakeConn{addr: addr, closed: make(chan struct{})}, nil
+	}
+
+	ips := []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}
+	start := time.Now()
+	conn, err := DialHappyEyeballs(context.Background(), "tcp", "broken-v6.example", "443", ips)
+	if err != nil {
+		t.Fatalf("Expected IPv4 fallback to succeed: %v", err)
+	}
+	if addr := conn.(*fakeConn).addr; addr != "192.0.2.1:443" {
+		t.Errorf("Expected IPv4 to win, got %s", addr)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected fallback after the attempt delay, took %v", elapsed)
+	}
+
+	// 胜出的地址族被记住，下次直接先尝试 IPv4
+	mu.Lock()
+	started = nil
+	mu.Unlock()
+	if _, err := DialHappyEyeballs(context.Background(), "tcp", "broken-v6.example", "443", ips); err != nil {
+		t.Fatalf("Second dial failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(started) != 1 || started[0] != "192.0.2.1:443" {
+		t.Errorf("Expected only the remembered IPv4 address to be tried, got %v", started)
+	}
+}
+
+func TestDialHappyEyeballsAllFail(t *testing.T) {
+	defer func(orig func(context.Context, string, string) (net.Conn, error)) { happyEyeballsDial = orig }(happyEyeballsDial)
+	happyEyeballsDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("refused " + addr)
+	}
+
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}
+	_, err := DialHappyEyeballs(context.Background(), "tcp", "down.example", "80", ips)
+	var errs ErrorArray
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("Expected both attempts to be reported, got %v", err)
+	}
+	if _, err := DialHappyEyeballs(context.Background(), "tcp4", "down.example", "80", ips[1:]); err == nil {
+		t.Error("Expected tcp4 dial with only IPv6 addresses to fail")
+	}
+}
+
+func TestRememberFamilyTCPOnlyAndSweep(t *testing.T) {
+	defer func(orig func(context.Context, string, string) (net.Conn, error)) { happyEyeballsDial = orig }(happyEyeballsDial)
+	happyEyeballsDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return &fakeConn{addr: addr, closed: make(chan struct{})}, nil
+	}
+
+	// UDP 拨号不记录地址族
+	ips := []net.IP{net.ParseIP("192.0.2.1")}
+	if _, err := DialHappyEyeballs(context.Background(), "udp", "udp-only.example", "53", ips); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := familyCache.Load("udp-only.example"); ok {
+		t.Error("Expected UDP dial not to record an address family")
+	}
+
+	// 过期记录在下次记录时清理
+	familyCache.Store("stale.example", familyResult{ipv6: true, expires: time.Now().Add(-time.Second)})
+	lastFamilySweep.Store(0)
+	if _, err := DialHappyEyeballs(context.Background(), "tcp", "fresh.example", "443", ips); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := familyCache.Load("stale.example"); ok {
+		t.Error("Expected expired family record to be swept")
+	}
+	if _, ok := familyCache.Load("fresh.example"); !ok {
+		t.Error("Expected TCP dial to record an address family")
+	}
+	familyCache.Delete("fresh.example")
+}
@@ -0,0 +1,177 @@
+package options
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultViaPseudonym Via 头中代理的默认名称
+const DefaultViaPseudonym = proxyStatusName
+
+// ClientAddrHeader 前端把请求转发给内部 HTTP 代理时携带客户端地址（host:port）的头，
+// 内部代理据此按真实客户端生成 EDNS Client Subnet。它是逐跳头，客户端发来的会在前端被删除，不会转发到目标
+const ClientAddrHeader = "X-Proxy-Client-Addr"
+
+// hopByHopHeaders 只对单跳连接有意义、代理不能转发的头（RFC 9110 7.6.1），
+// 另外 Connection 头中列出的字段也是逐跳的
+var hopByHopHeaders = []string{
+	ClientAddrHeader,
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// identifyingHeaders 会暴露客户端或代理的头，匿名模式下全部删除
+var identifyingHeaders = []string{
+	"Forwarded",
+	"Via",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// ForwardHeadersConfig 转发请求时附加的标识头
+type ForwardHeadersConfig struct {
+	// Via Via 头中代理的名称，为空时不添加 Via
+	Via string
+	// XForwardedFor 是否附加 X-Forwarded-For 和 X-Real-IP
+	XForwardedFor bool
+	// Anonymous 删除所有标识头（Forwarded、Via、X-Forwarded-For 等），也不添加新的
+	Anonymous bool
+}
+
+var forwardHeaders atomic.Pointer[ForwardHeadersConfig]
+
+// SetForwardHeaders 设置转发请求和响应时附加的标识头
+func SetForwardHeaders(c ForwardHeadersConfig) {
+	forwardHeaders.Store(&c)
+}
+
+// ForwardHeaders 返回当前的标识头设置，未设置时只添加默认名称的 Via
+func ForwardHeaders() ForwardHeadersConfig {
+	if c := forwardHeaders.Load(); c != nil {
+		return *c
+	}
+	return ForwardHeadersConfig{Via: DefaultViaPseudonym}
+}
+
+// RemoveHopByHopHeaders 删除逐跳头以及 Connection 中列出的字段
+func RemoveHopByHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, f := range strings.Split(v, ",") {
+			if f = textproto.TrimString(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopByHopHeaders {
+		h.Del(k)
+	}
+}
+
+// UpgradeType 返回请求或响应要升级到的协议，没有 Connection: upgrade 时返回空
+func UpgradeType(h http.Header) string {
+	for _, v := range h.Values("Connection") {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(textproto.TrimString(f), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// PrepareForwardRequestHeaders 删除请求的逐跳头并按设置附加标识头，
+// protoMajor/protoMinor 是客户端请求的协议版本，clientIP 是客户端地址，forwarded 是本跳的 Forwarded 元素；
+// 协议升级请求保留 Connection: Upgrade 和 Upgrade。返回是否应检查 Forwarded 中的代理环路
+func PrepareForwardRequestHeaders(h http.Header, protoMajor, protoMinor int, clientIP, forwarded string) bool {
+	removeHopByHopKeepUpgrade(h, UpgradeType(h))
+	c := ForwardHeaders()
+	if c.Anonymous {
+		for _, k := range identifyingHeaders {
+			h.Del(k)
+		}
+		return false
+	}
+	h.Add("Forwarded", forwarded)
+	addVia(h, c, protoMajor, protoMinor)
+	if c.XForwardedFor && clientIP != "" {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			h.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+		} else {
+			h.Set("X-Forwarded-For", clientIP)
+		}
+		// X-Real-IP 只由第一个代理设置
+		if h.Get("X-Real-Ip") == "" {
+			h.Set("X-Real-Ip", clientIP)
+		}
+	}
+	return true
+}
+
+// PrepareForwardResponseHeaders 删除响应的逐跳头并附加 Via，101 响应保留 Connection: Upgrade 和 Upgrade
+func PrepareForwardResponseHeaders(h http.Header, statusCode, protoMajor, protoMinor int) {
+	removeHopByHopKeepUpgrade(h, responseUpgradeType(h, statusCode))
+	c := ForwardHeaders()
+	if c.Anonymous {
+		h.Del("Via")
+		return
+	}
+	addVia(h, c, protoMajor, protoMinor)
+}
+
+// PrepareInternalRequestHeaders 用于前端之后的内部 HTTP 代理：标识头已由接受客户端连接的前端附加，
+// 这里只删除逐跳头，Via 和 X-Forwarded-For 不会重复或带上回环地址。返回是否应检查 Forwarded 中的代理环路
+func PrepareInternalRequestHeaders(h http.Header) bool {
+	removeHopByHopKeepUpgrade(h, UpgradeType(h))
+	return !ForwardHeaders().Anonymous
+}
+
+// PrepareInternalResponseHeaders 与 PrepareInternalRequestHeaders 对应，只删除响应的逐跳头，Via 由前端附加
+func PrepareInternalResponseHeaders(h http.Header, statusCode int) {
+	removeHopByHopKeepUpgrade(h, responseUpgradeType(h, statusCode))
+}
+
+// responseUpgradeType 返回 101 响应升级到的协议，其它响应返回空
+func responseUpgradeType(h http.Header, statusCode int) string {
+	if statusCode != http.StatusSwitchingProtocols {
+		return ""
+	}
+	return UpgradeType(h)
+}
+
+// removeHopByHopKeepUpgrade 删除逐跳头，upgrade 不为空时保留 Connection: Upgrade 和 Upgrade
+func removeHopByHopKeepUpgrade(h http.Header, upgrade string) {
+	RemoveHopByHopHeaders(h)
+	if upgrade != "" {
+		h.Set("Connection", "Upgrade")
+		h.Set("Upgrade", upgrade)
+	}
+}
+
+// addVia 在 Via 头后追加本代理，例如 "1.1 http-proxy-go-server"
+func addVia(h http.Header, c ForwardHeadersConfig, protoMajor, protoMinor int) {
+	if c.Via == "" {
+		return
+	}
+	version := "1.1"
+	if protoMajor == 1 && protoMinor == 0 {
+		version = "1.0"
+	} else if protoMajor >= 2 {
+		version = strconv.Itoa(protoMajor)
+	}
+	entry := version + " " + c.Via
+	if prior := h.Values("Via"); len(prior) > 0 {
+		entry = strings.Join(prior, ", ") + ", " + entry
+	}
+	h.Set("Via", entry)
+}
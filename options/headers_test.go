@@ -0,0 +1,54 @@
+package options
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPrepareForwardRequestHeaders(t *testing.T) {
+	defer forwardHeaders.Store(nil)
+
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Secret")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Proxy-Connection", "keep-alive")
+	h.Set("Te", "trailers")
+	h.Set("X-Secret", "1")
+	h.Set("X-Forwarded-For", "192.0.2.1")
+	h.Set("Via", "1.0 first")
+	SetForwardHeaders(ForwardHeadersConfig{Via: "me", XForwardedFor: true})
+	if !PrepareForwardRequestHeaders(h, 1, 1, "198.51.100.7", "for=198.51.100.7") {
+		t.Error("Expected loop detection outside anonymous mode")
+	}
+	for _, k := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "X-Secret"} {
+		if h.Get(k) != "" {
+			t.Errorf("Expected hop-by-hop header %s to be removed", k)
+		}
+	}
+	if got := h.Get("Via"); got != "1.0 first, 1.1 me" {
+		t.Errorf("Unexpected Via %q", got)
+	}
+	if got := h.Get("X-Forwarded-For"); got != "192.0.2.1, 198.51.100.7" {
+		t.Errorf("Unexpected X-Forwarded-For %q", got)
+	}
+	if h.Get("X-Real-Ip") != "198.51.100.7" || h.Get("Forwarded") == "" {
+		t.Errorf("Expected X-Real-IP and Forwarded, got %v", h)
+	}
+
+	// 协议升级保留 Upgrade，匿名模式删除所有标识头
+	SetForwardHeaders(ForwardHeadersConfig{Via: "me", Anonymous: true})
+	h = http.Header{}
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "websocket")
+	h.Set("Forwarded", "for=192.0.2.1")
+	h.Set("X-Real-Ip", "192.0.2.1")
+	if PrepareForwardRequestHeaders(h, 1, 1, "198.51.100.7", "for=198.51.100.7") {
+		t.Error("Expected no loop detection in anonymous mode")
+	}
+	if h.Get("Upgrade") != "websocket" || h.Get("Connection") != "Upgrade" {
+		t.Errorf("Expected upgrade headers to be kept, got %v", h)
+	}
+	if h.Get("Forwarded") != "" || h.Get("X-Real-Ip") != "" || h.Get("Via") != "" {
+		t.Errorf("Expected identifying headers to be removed, got %v", h)
+	}
+}
@@ -25,6 +25,11 @@ func (e ErrorArray) Error() string {
 	return strings.Join(errorMessages, "; ")
 }
 
+// Unwrap 让 errors.Is/errors.As 可以检查其中的每个错误
+func (e ErrorArray) Unwrap() []error {
+	return e
+}
+
 type ProxyOptionDNS struct {
 	Dohurl  string
 	Dohip   string
@@ -34,7 +39,49 @@ type ProxyOptionDNS struct {
 	Doqurl  string
 	Doqip   string
 	// 新增DNS协议类型字段，用于标识使用哪种DNS协议
-	Protocol string // "doh", "dot", "doq", "doh3"
+	Protocol string // "doh", "dot", "doq", "doh3", "udp", "tcp", "dnscrypt"
+	// 明文DNS服务器地址 host:port，仅 Protocol 为 "udp"/"tcp" 时使用
+	Dnsaddr string
+	// DNSCrypt 服务器的 sdns:// 地址，仅 Protocol 为 "dnscrypt" 时使用
+	Stamp string
+	// 分流DNS：该服务器负责的域名模式，为空表示适用于所有域名
+	Domains []string
+	// 本次查询携带的 EDNS Client Subnet（CIDR），由解析器按客户端设置，为空时使用全局策略
+	ClientSubnet string
+}
+
+// GetProtocol 返回该DNS配置实际使用的协议类型
+// 未显式设置 Protocol 时根据已填写的URL字段推断
+func (o ProxyOptionDNS) GetProtocol() string {
+	if o.Protocol != "" {
+		return o.Protocol
+	}
+	switch {
+	case o.Doturl != "":
+		return "dot"
+	case o.Doqurl != "":
+		return "doq"
+	case o.Dohalpn == "h3":
+		return "doh3"
+	default:
+		return "doh"
+	}
+}
+
+// ServerURL 返回该DNS配置对应协议的服务器URL（用于日志）
+func (o ProxyOptionDNS) ServerURL() string {
+	switch o.GetProtocol() {
+	case "dot":
+		return o.Doturl
+	case "doq":
+		return o.Doqurl
+	case "udp", "tcp":
+		return o.Protocol + "://" + o.Dnsaddr
+	case "dnscrypt":
+		return o.Stamp
+	default:
+		return o.Dohurl
+	}
 }
 
 type ProxyOptionsDNSSLICE = []ProxyOptionDNS
@@ -119,14 +166,14 @@ func Proxy_net_Dial(network string, addr string, proxyoptions ProxyOptionsDNSSLI
 		return nil, err
 	}
 	if IsIP(hostname) {
-		dialer := &net.Dialer{}
+		dialer := NewDialer(network, addr)
 		return dialer.DialContext(ctx, network, addr)
 	}
 	// 使用基本hosts解析
 	var ips []net.IP
 	ips, err = hosts.ResolveDomainToIPsWithHosts(hostname)
 	if err != nil {
-		connection, err1 := net.Dial(network, addr)
+		connection, err1 := DialContext(ctx, network, addr)
 		if err1 != nil {
 			log.Println("failure connect to " + addr + " by " + network + ": " + err1.Error())
 			return nil, err1
@@ -137,29 +184,14 @@ func Proxy_net_Dial(network string, addr string, proxyoptions ProxyOptionsDNSSLI
 
 	if len(ips) > 0 {
 		Shuffle(ips)
-		lengthip := len(ips)
-		var errorsArray = make([]error, 0)
-		for i := 0; i < lengthip; i++ {
-
-			var serverIP = ips[i].String()
-			newAddr := net.JoinHostPort(serverIP, port)
-			// 创建 net.Dialer 实例
-			//				dialer := &net.Dialer{}
-			dialer := &net.Dialer{}
-			connection, err1 := dialer.DialContext(ctx, network, newAddr)
-
-			if err1 != nil {
-				errorsArray = append(errorsArray, err1)
-				continue
-			} else {
-
-				log.Println("success connect to addr=" + addr + " by network=" + network + " by serverIP=" + serverIP)
-				return connection, err1
-			}
+		connection, err1 := DialHappyEyeballs(ctx, network, hostname, port, ips)
+		if err1 != nil {
+			return nil, err1
 		}
-		return nil, ErrorArray(errorsArray)
+		log.Println("success connect to addr=" + addr + " by network=" + network + " by serverIP=" + connection.RemoteAddr().String())
+		return connection, nil
 	} else {
-		connection, err1 := net.Dial(network, addr)
+		connection, err1 := DialContext(ctx, network, addr)
 
 		if err1 != nil {
 			log.Println("failure connect to " + addr + " by " + network + "" + err1.Error())
@@ -193,7 +225,7 @@ func Proxy_net_DialContext(ctx context.Context, network string, address string,
 	}
 
 	if IsIP(hostname) {
-		dialer := &net.Dialer{}
+		dialer := NewDialerContext(ctx, network, address)
 		//				// 发起连接
 		return dialer.DialContext(ctx, network, address)
 	}
@@ -208,27 +240,12 @@ func Proxy_net_DialContext(ctx context.Context, network string, address string,
 
 	if len(ips) > 0 {
 		Shuffle(ips)
-		lengthip := len(ips)
-		var errorsArray = make([]error, 0)
-		for i := 0; i < lengthip; i++ {
-
-			var serverIP = ips[i].String()
-			newAddr := net.JoinHostPort(serverIP, port)
-			// 创建 net.Dialer 实例
-			//				dialer := &net.Dialer{}
-			dialer := &net.Dialer{}
-			connection, err1 := dialer.DialContext(ctx, network, newAddr)
-
-			if err1 != nil {
-				errorsArray = append(errorsArray, err1)
-				continue
-			} else {
-
-				log.Println("success connect to addr=" + address + " by network=" + network + " by serverIP=" + serverIP)
-				return connection, err1
-			}
+		connection, err1 := DialHappyEyeballs(ctx, network, hostname, port, ips)
+		if err1 != nil {
+			return nil, err1
 		}
-		return nil, ErrorArray(errorsArray)
+		log.Println("success connect to addr=" + address + " by network=" + network + " by serverIP=" + connection.RemoteAddr().String())
+		return connection, nil
 	}
 	if len(ips) == 0 && err != nil {
 		log.Println(err)
@@ -238,7 +255,7 @@ func Proxy_net_DialContext(ctx context.Context, network string, address string,
 	if len(proxyoptions) > 0 {
 		// DNS缓存功能现在通过interface{}调用，避免循环导入
 		// 回退到基础连接
-		connection, err1 := net.Dial(network, address)
+		connection, err1 := DialContext(ctx, network, address)
 		if err1 != nil {
 			log.Println("failure connect to " + address + " by " + network + "" + err1.Error())
 			return nil, err1
@@ -246,7 +263,7 @@ func Proxy_net_DialContext(ctx context.Context, network string, address string,
 		log.Println("success connect to " + address + " by " + network + "")
 		return connection, err1
 	} else {
-		connection, err1 := net.Dial(network, address)
+		connection, err1 := DialContext(ctx, network, address)
 
 		if err1 != nil {
 			log.Println("failure connect to " + address + " by " + network + "" + err1.Error())
@@ -0,0 +1,241 @@
+package options
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// OutboundConfig 出站连接的源地址、网卡、防火墙标记和 TCP keepalive 配置
+// 同时作用于直连、连接上游代理以及 DoH/DoT/DoQ 等DNS查询。
+// WebSocket/SOCKS5 上游代理库自行建立的连接不经过 NewDialer，不受这些设置影响
+type OutboundConfig struct {
+	// BindAddress 源地址，可用逗号分隔一个 IPv4 和一个 IPv6 地址，按目标的地址族选择
+	BindAddress string `json:"bind_address,omitempty"`
+	// BindInterface 绑定的网卡（Linux SO_BINDTODEVICE）
+	BindInterface string `json:"bind_interface,omitempty"`
+	// FwMark 防火墙标记（Linux SO_MARK），用于策略路由
+	FwMark int `json:"fwmark,omitempty"`
+	// KeepAlive TCP keepalive 间隔（如 "30s"），为空使用系统默认，"-1s" 关闭
+	KeepAlive string `json:"keepalive,omitempty"`
+}
+
+// IsZero 是否没有任何设置
+func (c OutboundConfig) IsZero() bool {
+	return c == OutboundConfig{}
+}
+
+// Merge 用 over 中已设置的字段覆盖 c
+func (c OutboundConfig) Merge(over OutboundConfig) OutboundConfig {
+	if over.BindAddress != "" {
+		c.BindAddress = over.BindAddress
+	}
+	if over.BindInterface != "" {
+		c.BindInterface = over.BindInterface
+	}
+	if over.FwMark != 0 {
+		c.FwMark = over.FwMark
+	}
+	if over.KeepAlive != "" {
+		c.KeepAlive = over.KeepAlive
+	}
+	return c
+}
+
+// Validate 检查源地址和 keepalive 格式，以及当前平台是否支持网卡绑定和防火墙标记
+func (c OutboundConfig) Validate() error {
+	if _, err := c.bindIPs(); err != nil {
+		return err
+	}
+	if _, err := c.keepAlive(); err != nil {
+		return err
+	}
+	if c.FwMark < 0 {
+		return fmt.Errorf("invalid fwmark %d", c.FwMark)
+	}
+	if (c.BindInterface != "" || c.FwMark != 0) && !socketOptionsSupported {
+		return fmt.Errorf("bind_interface and fwmark are only supported on Linux")
+	}
+	return nil
+}
+
+func (c OutboundConfig) bindIPs() ([]net.IP, error) {
+	var ips []net.IP
+	for _, s := range strings.Split(c.BindAddress, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid bind address %q", s)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func (c OutboundConfig) keepAlive() (time.Duration, error) {
+	if c.KeepAlive == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.KeepAlive)
+	if err != nil {
+		return 0, fmt.Errorf("invalid keepalive %q: %w", c.KeepAlive, err)
+	}
+	return d, nil
+}
+
+// localIP 按目标地址族选择源地址，目标不是 IP（交给系统解析）时使用第一个
+func (c OutboundConfig) localIP(remote net.IP) net.IP {
+	ips, _ := c.bindIPs()
+	if len(ips) == 0 {
+		return nil
+	}
+	if remote == nil {
+		return ips[0]
+	}
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (remote.To4() != nil) {
+			return ip
+		}
+	}
+	// 没有同一地址族的源地址，仍然绑定以免流量从默认地址发出，连接会失败
+	return ips[0]
+}
+
+func (c OutboundConfig) control() func(network, address string, rc syscall.RawConn) error {
+	if c.BindInterface == "" && c.FwMark == 0 {
+		return nil
+	}
+	return func(network, address string, rc syscall.RawConn) error {
+		var sockErr error
+		if err := rc.Control(func(fd uintptr) {
+			sockErr = setSocketOptions(fd, c.BindInterface, c.FwMark)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+var (
+	defaultOutbound  atomic.Pointer[OutboundConfig]
+	outboundSelector atomic.Pointer[func(host string) (OutboundConfig, bool)]
+)
+
+// SetDefaultOutbound 设置全局出站配置
+func SetDefaultOutbound(c OutboundConfig) {
+	defaultOutbound.Store(&c)
+}
+
+// SetOutboundSelector 设置按目标主机选择出站配置的函数（上游代理、路由规则），
+// 返回的配置覆盖全局配置中对应的字段
+func SetOutboundSelector(fn func(host string) (OutboundConfig, bool)) {
+	outboundSelector.Store(&fn)
+}
+
+type outboundHostKey struct{}
+
+// WithOutboundHost 在 ctx 中记录连接的原始目标主机名，之后拨号解析出的 IP 时
+// 仍按这个主机名选择出站配置，路由规则中的域名模式才能匹配
+func WithOutboundHost(ctx context.Context, host string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, outboundHostKey{}, host)
+}
+
+// OutboundFor 返回连接 addr（host:port 或 host）时使用的出站配置
+func OutboundFor(addr string) OutboundConfig {
+	return OutboundForContext(context.Background(), addr)
+}
+
+// OutboundForContext 返回连接 addr 时使用的出站配置，ctx 中记录了原始主机名时按它选择。
+// 回环地址（内部 HTTP 代理等本机服务）不使用任何出站设置，绑定网卡或源地址会使连接失败
+func OutboundForContext(ctx context.Context, addr string) OutboundConfig {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); (ip != nil && ip.IsLoopback()) || strings.EqualFold(host, "localhost") {
+		return OutboundConfig{}
+	}
+	hosts := []string{host}
+	if ctx != nil {
+		if h, ok := ctx.Value(outboundHostKey{}).(string); ok {
+			// 先按原始主机名选择，没有选中时再按实际连接的 IP 选择（CIDR 规则）
+			hosts = []string{h, host}
+		}
+	}
+	var c OutboundConfig
+	if def := defaultOutbound.Load(); def != nil {
+		c = *def
+	}
+	if fn := outboundSelector.Load(); fn != nil {
+		for _, h := range hosts {
+			if over, ok := (*fn)(h); ok {
+				return c.Merge(over)
+			}
+		}
+	}
+	return c
+}
+
+// NewDialer 按出站配置创建连接 addr 使用的 net.Dialer，network 为 tcp 或 udp 系列
+func NewDialer(network, addr string) *net.Dialer {
+	return NewDialerContext(context.Background(), network, addr)
+}
+
+// NewDialerContext 与 NewDialer 相同，ctx 中记录的原始主机名用于选择出站配置
+func NewDialerContext(ctx context.Context, network, addr string) *net.Dialer {
+	c := OutboundForContext(ctx, addr)
+	dialer := &net.Dialer{Control: c.control()}
+	dialer.KeepAlive, _ = c.keepAlive()
+
+	var remote net.IP
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		remote = net.ParseIP(host)
+	}
+	if ip := c.localIP(remote); ip != nil {
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	return dialer
+}
+
+// DialContext 按出站配置连接 addr
+func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return NewDialerContext(ctx, network, addr).DialContext(ctx, network, addr)
+}
+
+// ListenPacket 按出站配置创建发往 raddr 的 UDP socket（用于 QUIC 等需要自行管理 socket 的协议）
+func ListenPacket(ctx context.Context, network, raddr string) (net.PacketConn, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c := OutboundForContext(ctx, raddr)
+	var remote net.IP
+	if host, _, err := net.SplitHostPort(raddr); err == nil {
+		remote = net.ParseIP(host)
+	}
+	laddr := ":0"
+	if ip := c.localIP(remote); ip != nil {
+		laddr = net.JoinHostPort(ip.String(), "0")
+	}
+	lc := &net.ListenConfig{Control: c.control()}
+	return lc.ListenPacket(ctx, network, laddr)
+}
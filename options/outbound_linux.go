@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package options
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const socketOptionsSupported = true
+
+// setSocketOptions 设置 SO_BINDTODEVICE 和 SO_MARK，需要 CAP_NET_RAW / CAP_NET_ADMIN 权限
+func setSocketOptions(fd uintptr, iface string, mark int) error {
+	if iface != "" {
+		if err := syscall.BindToDevice(int(fd), iface); err != nil {
+			return fmt.Errorf("bind to interface %s: %w", iface, err)
+		}
+	}
+	if mark != 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+			return fmt.Errorf("set fwmark %d: %w", mark, err)
+		}
+	}
+	return nil
+}
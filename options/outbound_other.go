@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package options
+
+import "fmt"
+
+const socketOptionsSupported = false
+
+// setSocketOptions 非 Linux 平台不支持网卡绑定和防火墙标记
+func setSocketOptions(fd uintptr, iface string, mark int) error {
+	if iface != "" || mark != 0 {
+		return fmt.Errorf("bind_interface and fwmark are only supported on Linux")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package options
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOutboundConfigValidate(t *testing.T) {
+	if err := (OutboundConfig{BindAddress: "192.0.2.1, 2001:db8::1", KeepAlive: "30s"}).Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	for _, c := range []OutboundConfig{
+		{BindAddress: "not-an-ip"},
+		{KeepAlive: "30"},
+		{FwMark: -1},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
+
+func TestNewDialerSelectsOutbound(t *testing.T) {
+	defer defaultOutbound.Store(nil)
+	defer outboundSelector.Store(nil)
+
+	SetDefaultOutbound(OutboundConfig{BindAddress: "192.0.2.1,2001:db8::1", KeepAlive: "15s"})
+	SetOutboundSelector(func(host string) (OutboundConfig, bool) {
+		if host == "proxy.example" {
+			return OutboundConfig{BindAddress: "198.51.100.1"}, true
+		}
+		return OutboundConfig{}, false
+	})
+
+	dialer := NewDialer("tcp", "[2001:db8::53]:443")
+	if addr, ok := dialer.LocalAddr.(*net.TCPAddr); !ok || addr.IP.String() != "2001:db8::1" {
+		t.Errorf("Expected IPv6 source address for IPv6 target, got %v", dialer.LocalAddr)
+	}
+	if dialer.KeepAlive != 15*time.Second {
+		t.Errorf("Expected keepalive 15s, got %v", dialer.KeepAlive)
+	}
+
+	dialer = NewDialer("udp", "192.0.2.53:53")
+	if addr, ok := dialer.LocalAddr.(*net.UDPAddr); !ok || addr.IP.String() != "192.0.2.1" {
+		t.Errorf("Expected IPv4 UDP source address, got %v", dialer.LocalAddr)
+	}
+
+	// 选择器的配置只覆盖设置了的字段
+	c := OutboundFor("proxy.example:8080")
+	if c.BindAddress != "198.51.100.1" || c.KeepAlive != "15s" {
+		t.Errorf("Expected selector override merged with default, got %+v", c)
+	}
+}
+
+func TestOutboundForLoopbackAndOriginalHost(t *testing.T) {
+	defer defaultOutbound.Store(nil)
+	defer outboundSelector.Store(nil)
+
+	SetDefaultOutbound(OutboundConfig{BindAddress: "192.0.2.1", BindInterface: "eth1"})
+	SetOutboundSelector(func(host string) (OutboundConfig, bool) {
+		if host == "www.example.com" {
+			return OutboundConfig{FwMark: 7}, true
+		}
+		if host == "198.51.100.9" {
+			return OutboundConfig{FwMark: 9}, true
+		}
+		return OutboundConfig{}, false
+	})
+
+	// 内部 HTTP 代理等回环地址不使用出站设置
+	for _, addr := range []string{"127.0.0.1:8080", "127.45.6.7:1234", "[::1]:80", "localhost:80"} {
+		if c := OutboundFor(addr); !c.IsZero() {
+			t.Errorf("Expected no outbound settings for %s, got %+v", addr, c)
+		}
+		if dialer := NewDialer("tcp", addr); dialer.LocalAddr != nil || dialer.Control != nil {
+			t.Errorf("Expected plain dialer for %s", addr)
+		}
+	}
+
+	// 拨号解析出的 IP 时按 ctx 中的原始主机名选择
+	if c := OutboundFor("203.0.113.5:443"); c.FwMark != 0 {
+		t.Errorf("Expected no rule override without original host, got %+v", c)
+	}
+	ctx := WithOutboundHost(context.Background(), "www.example.com")
+	if c := OutboundForContext(ctx, "203.0.113.5:443"); c.FwMark != 7 || c.BindInterface != "eth1" {
+		t.Errorf("Expected rule override selected by original host, got %+v", c)
+	}
+	ctx = WithOutboundHost(context.Background(), "other.example")
+	if c := OutboundForContext(ctx, "198.51.100.9:443"); c.FwMark != 9 {
+		t.Errorf("Expected CIDR rule to match the dialed IP, got %+v", c)
+	}
+}
@@ -36,26 +36,20 @@ func (h *HostsAndDohResolver) LookupIP(ctx context.Context, network string, host
 	}
 
 	// 如果 hosts 解析失败，尝试使用 DoH 解析
-	if len(h.proxyoptions) > 0 {
+	// 按域名选择分流DNS服务器
+	selected := options.SelectDNSOptions(h.proxyoptions, host)
+	if len(selected) > 0 {
 		// 随机打乱 proxyoptions 顺序
-		options.Shuffle(h.proxyoptions)
+		options.Shuffle(selected)
 
 		var allErrors []error
-		for _, opt := range h.proxyoptions {
+		for _, opt := range selected {
 			var ips []net.IP
 			var errors []error
 
-			if opt.Dohalpn == "h3" {
-				// 使用 DOH3
-				if opt.Dohip == "" {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl)
-				} else {
-					ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl, opt.Dohip)
-				}
-			} else {
-				// 使用 DOH
-				ips, errors = doh.ResolveDomainToIPsWithDoh(host, opt.Dohurl, opt.Dohip, h.Proxy, h.transportConfigurations...)
-			}
+			// 按协议类型选择 DoH/DoH3/DoT/DoQ
+			opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+			ips, errors = doh.ResolveDomainToIPsWithOption(host, opt, h.Proxy, h.transportConfigurations...)
 
 			if len(ips) > 0 {
 				return ips, nil
@@ -122,12 +116,13 @@ func (d *DOHResolver) LookupIP(ctx context.Context, network string, host string)
 
 	var allErrors []error
 	for _, opt := range d.proxyoptions {
-		// 跳过 h3 配置，因为这是 DOHResolver
-		if opt.Dohalpn == "h3" {
+		// 只处理普通 DoH 配置，因为这是 DOHResolver
+		if opt.GetProtocol() != "doh" {
 			continue
 		}
 
-		ips, errors := doh.ResolveDomainToIPsWithDoh(host, opt.Dohurl, opt.Dohip, d.Proxy, d.transportConfigurations...)
+		opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+		ips, errors := doh.ResolveDomainToIPsWithOption(host, opt, d.Proxy, d.transportConfigurations...)
 		if len(ips) > 0 {
 			return ips, nil
 		}
@@ -177,17 +172,12 @@ func (d *DOH3Resolver) LookupIP(ctx context.Context, network string, host string
 	var allErrors []error
 	for _, opt := range d.proxyoptions {
 		// 只处理 h3 配置
-		if opt.Dohalpn != "h3" {
+		if opt.GetProtocol() != "doh3" {
 			continue
 		}
 
-		var ips []net.IP
-		var errors []error
-		if opt.Dohip == "" {
-			ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl)
-		} else {
-			ips, errors = doh.ResolveDomainToIPsWithDoh3(host, opt.Dohurl, opt.Dohip)
-		}
+		opt.ClientSubnet = doh.ECSScopeFromContext(ctx)
+		ips, errors := doh.ResolveDomainToIPsWithOption(host, opt, nil)
 
 		if len(ips) > 0 {
 			return ips, nil
@@ -0,0 +1,355 @@
+package simple
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/masx200/http-proxy-go-server/dnscache"
+	http_server "github.com/masx200/http-proxy-go-server/http"
+	"github.com/masx200/http-proxy-go-server/mitm"
+	"github.com/masx200/http-proxy-go-server/options"
+	"golang.org/x/net/http2"
+)
+
+// h2cPriorKnowledge 是否在明文端口上接受 HTTP/2 prior knowledge（h2c，RFC 9113 3.3）连接
+var h2cPriorKnowledge atomic.Bool
+
+// SetH2CPriorKnowledge 设置明文端口是否接受以 HTTP/2 连接前言开头的 h2c 连接
+func SetH2CPriorKnowledge(enabled bool) {
+	h2cPriorKnowledge.Store(enabled)
+}
+
+// ExtendedConnectEnabled 返回 HTTP/2 服务器是否声明扩展 CONNECT（RFC 8441）。
+// golang.org/x/net/http2 只在包初始化时从环境变量读取一次 GODEBUG=http2xconnect=1，
+// 所以必须在启动进程时设置，运行中修改环境变量或使用 //go:debug 指令都不起作用
+func ExtendedConnectEnabled() bool {
+	return strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1")
+}
+
+// http2Preface HTTP/2 连接前言中被 http.ReadRequest 解析为 "PRI * HTTP/2.0" 请求的部分
+const http2Preface = "PRI * HTTP/2.0\r\n\r\n"
+
+// isHTTP2Preface 请求是否为 HTTP/2 连接前言
+func isHTTP2Preface(req *http.Request) bool {
+	return req.Method == "PRI" && req.RequestURI == "*" && req.ProtoMajor == 2
+}
+
+// prefaceConn 把已被读出的连接前言放回去，reader 中缓冲的数据随后读出
+type prefaceConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *prefaceConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// clientAddrKey 请求上下文中客户端地址的键，转发普通请求时由 Transport 拨号使用
+type clientAddrKey struct{}
+
+// streamHandler 处理 HTTP/2 和 HTTP/3 连接上的代理请求，每个请求是一个流
+type streamHandler struct {
+	localAddr              string
+	authenticate           Authenticator
+	httpUpstreamAddress    string
+	Proxy                  func(*http.Request) (*url.URL, error)
+	proxyoptions           options.ProxyOptionsDNSSLICE
+	dnsCache               *dnscache.DNSCache
+	upstreamResolveIPs     bool
+	ipPriority             options.IPPriority
+	tranportConfigurations []func(*http.Transport) *http.Transport
+	// transport 转发普通 HTTP 请求，连接由 dialServer 按路由建立，在同一客户端连接的请求之间复用
+	transport *http.Transport
+}
+
+// ServeHTTP2 在一个 HTTP/2 客户端连接（TLS 上协商出 h2，或 h2c prior knowledge）上处理代理请求：
+// 每个 CONNECT 流（RFC 9113 8.5）建立一条隧道，多条隧道复用同一个客户端连接；
+// 带 :protocol 的扩展 CONNECT（RFC 8441）转换为 HTTP/1.1 协议升级转发；其它请求按 :authority 转发到目标。
+// authenticate 为 nil 时不做身份验证。
+func ServeHTTP2(client net.Conn, authenticate Authenticator, httpUpstreamAddress string, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) {
+	defer client.Close()
+	log.Printf("HTTP/2 client connected: %v\n", client.RemoteAddr())
+
+	h := newStreamHandler(client.LocalAddr().String(), authenticate, httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+	defer h.transport.CloseIdleConnections()
+
+	server := &http2.Server{}
+	server.ServeConn(client, &http2.ServeConnOpts{Handler: h})
+}
+
+// newStreamHandler 创建处理代理请求流的 http.Handler，localAddr 用于 Forwarded 头中代理的标识
+func newStreamHandler(localAddr string, authenticate Authenticator, httpUpstreamAddress string, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) *streamHandler {
+	h := &streamHandler{
+		localAddr:              localAddr,
+		authenticate:           authenticate,
+		httpUpstreamAddress:    httpUpstreamAddress,
+		Proxy:                  Proxy,
+		proxyoptions:           proxyoptions,
+		dnsCache:               dnsCache,
+		upstreamResolveIPs:     upstreamResolveIPs,
+		ipPriority:             ipPriority,
+		tranportConfigurations: tranportConfigurations,
+	}
+	h.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			remoteAddr, _ := ctx.Value(clientAddrKey{}).(string)
+			// fake-IP 模式下将目标地址还原为域名，交给路由规则和上游代理解析
+			return dialServer(remoteAddr, http.MethodGet, dnsCache.RestoreFakeIPAddress(addr), httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+		},
+		MaxIdleConnsPerHost: 4,
+	}
+	return h
+}
+
+func (h *streamHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Println(req.Method, req.Host, req.URL, req.Proto)
+	// 身份验证会删除 Proxy-Authorization，先保存下来交给内部 HTTP 代理
+	proxyAuthorization := req.Header.Get("Proxy-Authorization")
+	if h.authenticate != nil && !h.authenticate(req) {
+		w.Header().Set("Proxy-Authenticate", "Basic realm=\"Proxy\"")
+		http.Error(w, "407 Proxy Authentication Required", http.StatusProxyAuthRequired)
+		return
+	}
+	// HTTP/2 的扩展 CONNECT 协议在 :protocol 中，HTTP/3 的在 req.Proto 中
+	protocol := req.Header.Get(":protocol")
+	req.Header.Del(":protocol")
+	if req.ProtoMajor == 3 && req.Method == http.MethodConnect && req.Proto != "HTTP/3.0" {
+		protocol = req.Proto
+	}
+	switch {
+	case req.Method == http.MethodConnect && protocol == "":
+		h.serveConnect(w, req)
+	case req.Method == http.MethodConnect && protocol == "connect-udp":
+		h.serveConnectUDP(w, req)
+	case req.Method == http.MethodConnect:
+		h.serveExtendedConnect(w, req, protocol)
+	default:
+		h.serveRequest(w, req, proxyAuthorization)
+	}
+}
+
+// serveConnect 为 CONNECT 流建立到 :authority 的隧道，流上的 DATA 帧与上游连接之间双向转发
+func (h *streamHandler) serveConnect(w http.ResponseWriter, req *http.Request) {
+	if _, _, err := net.SplitHostPort(req.Host); err != nil {
+		http.Error(w, fmt.Sprintf("missing port in CONNECT target %s", req.Host), http.StatusBadRequest)
+		return
+	}
+	address := h.dnsCache.RestoreFakeIPAddress(req.Host)
+	log.Println("address:" + address)
+	if ca, ok := mitm.ShouldIntercept(address); ok {
+		// 路由规则要求拦截：流的一端接到内存管道，另一端由拦截流程终止 TLS，与 HTTP/1.1 的 CONNECT 相同
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+		stream, server := net.Pipe()
+		go http_server.ServeIntercepted(&streamConn{Conn: stream, local: streamAddr(h.localAddr), remote: streamAddr(req.RemoteAddr)}, address, ca, h.proxyoptions, h.dnsCache, h.upstreamResolveIPs, h.ipPriority, h.Proxy, h.tranportConfigurations...)
+		relayStream(w, req, server, server)
+		return
+	}
+	conn, err := dialServer(req.RemoteAddr, http.MethodConnect, address, h.httpUpstreamAddress, h.Proxy, h.proxyoptions, h.dnsCache, h.upstreamResolveIPs, h.ipPriority, h.tranportConfigurations...)
+	if err != nil {
+		w.WriteHeader(options.SetProxyErrorHeaders(w.Header(), err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	http.NewResponseController(w).Flush()
+	relayStream(w, req, conn, conn)
+}
+
+// serveExtendedConnect 把扩展 CONNECT 转换为发往目标的 HTTP/1.1 协议升级请求，
+// 目标返回 101 后回复 200 并在流与目标连接之间双向转发。:authority 端口为 443 时使用 TLS 连接目标
+func (h *streamHandler) serveExtendedConnect(w http.ResponseWriter, req *http.Request, protocol string) {
+	address := req.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), "80")
+	}
+	host, port, _ := net.SplitHostPort(address)
+	address = h.dnsCache.RestoreFakeIPAddress(address)
+	log.Println("address:"+address, "protocol:", protocol)
+	conn, err := dialServer(req.RemoteAddr, http.MethodConnect, address, h.httpUpstreamAddress, h.Proxy, h.proxyoptions, h.dnsCache, h.upstreamResolveIPs, h.ipPriority, h.tranportConfigurations...)
+	if err != nil {
+		w.WriteHeader(options.SetProxyErrorHeaders(w.Header(), err))
+		return
+	}
+	if port == "443" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, NextProtos: []string{"http/1.1"}})
+		if err := tlsConn.HandshakeContext(req.Context()); err != nil {
+			conn.Close()
+			w.WriteHeader(options.SetProxyErrorHeaders(w.Header(), err))
+			return
+		}
+		conn = tlsConn
+	}
+
+	upgradeReq := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery},
+		Host:       req.Host,
+		Header:     req.Header.Clone(),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	clienthost, _, _ := net.SplitHostPort(req.RemoteAddr)
+	options.PrepareForwardRequestHeaders(upgradeReq.Header, req.ProtoMajor, req.ProtoMinor, clienthost, h.forwarded(clienthost, req.Host))
+	upgradeReq.Header.Set("Connection", "Upgrade")
+	upgradeReq.Header.Set("Upgrade", protocol)
+	if strings.EqualFold(protocol, "websocket") {
+		// RFC 8441 的 WebSocket 握手没有 Sec-WebSocket-Key/Accept，HTTP/1.1 握手需要补上
+		b := make([]byte, 16)
+		rand.Read(b)
+		upgradeReq.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(b))
+	}
+	if _, ok := upgradeReq.Header["User-Agent"]; !ok {
+		upgradeReq.Header.Set("User-Agent", "")
+	}
+	if err := upgradeReq.Write(conn); err != nil {
+		conn.Close()
+		w.WriteHeader(options.SetProxyErrorHeaders(w.Header(), err))
+		return
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, upgradeReq)
+	if err != nil {
+		conn.Close()
+		w.WriteHeader(options.SetProxyErrorHeaders(w.Header(), err))
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// 目标拒绝升级时原样返回其响应
+		defer conn.Close()
+		defer resp.Body.Close()
+		options.PrepareForwardResponseHeaders(resp.Header, resp.StatusCode, resp.ProtoMajor, resp.ProtoMinor)
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	options.PrepareForwardResponseHeaders(resp.Header, resp.StatusCode, resp.ProtoMajor, resp.ProtoMinor)
+	for _, k := range []string{"Connection", "Upgrade", "Sec-Websocket-Accept"} {
+		resp.Header.Del(k)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(http.StatusOK)
+	http.NewResponseController(w).Flush()
+	relayStream(w, req, conn, reader)
+}
+
+// serveRequest 转发普通 HTTP 请求。HTTP/2 和 HTTP/3 请求没有绝对 URI，目标取自 :authority，按 http 转发；
+// 经内部 HTTP 代理转发时带上客户端的 proxyAuthorization
+func (h *streamHandler) serveRequest(w http.ResponseWriter, req *http.Request, proxyAuthorization string) {
+	if req.Host == "" {
+		http.Error(w, "missing :authority", http.StatusBadRequest)
+		return
+	}
+	outReq := req.Clone(context.WithValue(req.Context(), clientAddrKey{}, req.RemoteAddr))
+	outReq.RequestURI = ""
+	outReq.URL.Scheme = "http"
+	outReq.URL.Host = req.Host
+	if req.ContentLength == 0 {
+		outReq.Body = nil
+	}
+	clienthost, _, _ := net.SplitHostPort(req.RemoteAddr)
+	options.PrepareForwardRequestHeaders(outReq.Header, req.ProtoMajor, req.ProtoMinor, clienthost, h.forwarded(clienthost, req.Host))
+	if h.httpUpstreamAddress != "" {
+		// 下一跳是内部 HTTP 代理，带上客户端地址和凭据
+		outReq.Header.Set(options.ClientAddrHeader, req.RemoteAddr)
+		if proxyAuthorization != "" {
+			outReq.Header.Set("Proxy-Authorization", proxyAuthorization)
+		}
+	}
+	if _, ok := outReq.Header["User-Agent"]; !ok {
+		outReq.Header.Set("User-Agent", "")
+	}
+	resp, err := h.transport.RoundTrip(outReq)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(options.SetProxyErrorHeaders(w.Header(), err))
+		return
+	}
+	defer resp.Body.Close()
+	options.PrepareForwardResponseHeaders(resp.Header, resp.StatusCode, resp.ProtoMajor, resp.ProtoMinor)
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(flushWriter{w}, resp.Body); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// forwarded 返回本跳的 Forwarded 元素
+func (h *streamHandler) forwarded(clienthost string, host string) string {
+	return fmt.Sprintf(
+		"for=%s;by=%s;host=%s;proto=%s",
+		clienthost,  // 客户端地址
+		h.localAddr, // 代理的标识
+		host,        // 原始请求的目标主机名
+		"http",
+	)
+}
+
+// streamAddr 流两端的地址，net.Pipe 没有网络地址，拦截时用客户端连接的地址代替
+type streamAddr string
+
+func (a streamAddr) Network() string { return "tcp" }
+func (a streamAddr) String() string  { return string(a) }
+
+// streamConn 拦截流上的 TLS 时交给 ServeIntercepted 的连接，地址取自流所在的客户端连接
+type streamConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+// flushWriter 每次写入后立即发出，隧道和流式响应不在缓冲区中等待
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = http.NewResponseController(f.w).Flush()
+	}
+	return n, err
+}
+
+// relayStream 在 HTTP/2 或 HTTP/3 流和上游连接之间双向转发；客户端结束流时对上游半关闭，
+// 流被重置或上游关闭时结束隧道
+func relayStream(w http.ResponseWriter, req *http.Request, server net.Conn, serverReader io.Reader) {
+	defer server.Close()
+	stop := context.AfterFunc(req.Context(), func() {
+		server.Close()
+	})
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(server, req.Body)
+		if cw, ok := server.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	io.Copy(flushWriter{w}, serverReader)
+	server.Close()
+	req.Body.Close()
+	<-done
+}
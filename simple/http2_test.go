@@ -0,0 +1,398 @@
+package simple
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	http_server "github.com/masx200/http-proxy-go-server/http"
+	"github.com/masx200/http-proxy-go-server/mitm"
+	"github.com/masx200/http-proxy-go-server/options"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// TestMain 与代理进程一样在启动时打开 HTTP/2 扩展 CONNECT：环境变量中没有时带上 GODEBUG=http2xconnect=1
+// 重新运行测试进程，TestServeHTTP2ExtendedConnect 检查服务器确实声明了 SETTINGS_ENABLE_CONNECT_PROTOCOL
+func TestMain(m *testing.M) {
+	if !ExtendedConnectEnabled() {
+		godebug := os.Getenv("GODEBUG")
+		if godebug != "" {
+			godebug += ","
+		}
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Env = append(os.Environ(), "GODEBUG="+godebug+"http2xconnect=1")
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestServeHTTP2PriorKnowledge(t *testing.T) {
+	SetH2CPriorKnowledge(true)
+	defer SetH2CPriorKnowledge(false)
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "origin "+r.URL.Path)
+	}))
+	defer origin.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go HandleWithAuthenticator(conn, nil, "", nil, nil, nil, false, options.ParseIPPriority("random"))
+		}
+	}()
+
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial("tcp", l.Addr().String())
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	// 两条 CONNECT 隧道复用同一个客户端连接
+	target := echo.Addr().String()
+	for _, msg := range []string{"first tunnel", "second tunnel"} {
+		pr, pw := io.Pipe()
+		resp, err := transport.RoundTrip(&http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Scheme: "http", Host: target},
+			Host:   target,
+			Header: http.Header{},
+			Body:   pr,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 for CONNECT, got %d", resp.StatusCode)
+		}
+		io.WriteString(pw, msg)
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(resp.Body, buf); err != nil || string(buf) != msg {
+			t.Errorf("Expected %q through the tunnel, got %q %v", msg, buf, err)
+		}
+		pw.Close()
+		resp.Body.Close()
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("Expected both tunnels on one connection, got %d connections", n)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, origin.URL+"/path", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "origin /path" {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Via") == "" || !strings.HasPrefix(resp.Proto, "HTTP/2") {
+		t.Errorf("Expected HTTP/2 response with Via, got %s %v", resp.Proto, resp.Header)
+	}
+}
+
+// 带 :protocol 的扩展 CONNECT（RFC 8441）转换为 HTTP/1.1 WebSocket 握手，握手成功后双向转发。
+// net/http 的客户端不能发送 :protocol，这里直接用帧收发
+func TestServeHTTP2ExtendedConnect(t *testing.T) {
+	SetH2CPriorKnowledge(true)
+	defer SetH2CPriorKnowledge(false)
+
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	go func() {
+		conn, err := origin.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		if req.Header.Get("Upgrade") != "websocket" || req.Header.Get("Sec-WebSocket-Key") == "" || req.URL.Path != "/chat" {
+			io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		io.Copy(conn, reader)
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		HandleWithAuthenticator(conn, nil, "", nil, nil, nil, false, options.ParseIPPriority("random"))
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+
+	io.WriteString(client, http2.ClientPreface)
+	framer := http2.NewFramer(client, client)
+	framer.WriteSettings()
+
+	// 服务器必须声明 SETTINGS_ENABLE_CONNECT_PROTOCOL
+	enabled := false
+	for {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sf, ok := f.(*http2.SettingsFrame); ok && !sf.IsAck() {
+			v, ok := sf.Value(http2.SettingEnableConnectProtocol)
+			enabled = ok && v == 1
+			framer.WriteSettingsAck()
+			break
+		}
+	}
+	if !enabled {
+		t.Fatal("Expected server to advertise SETTINGS_ENABLE_CONNECT_PROTOCOL")
+	}
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, f := range [][2]string{
+		{":method", "CONNECT"},
+		{":protocol", "websocket"},
+		{":scheme", "http"},
+		{":path", "/chat"},
+		{":authority", origin.Addr().String()},
+	} {
+		enc.WriteField(hpack.HeaderField{Name: f[0], Value: f[1]})
+	}
+	framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block.Bytes(), EndHeaders: true})
+
+	dec := hpack.NewDecoder(4096, nil)
+	status := ""
+	for status == "" {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f := f.(type) {
+		case *http2.HeadersFrame:
+			fields, err := dec.DecodeFull(f.HeaderBlockFragment())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range fields {
+				if field.Name == ":status" {
+					status = field.Value
+				}
+			}
+		case *http2.SettingsFrame:
+		case *http2.WindowUpdateFrame:
+		default:
+			t.Fatalf("Unexpected frame %v", f)
+		}
+	}
+	if status != "200" {
+		t.Fatalf("Expected 200 for extended CONNECT, got %s", status)
+	}
+
+	framer.WriteData(1, false, []byte("frame"))
+	for {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if df, ok := f.(*http2.DataFrame); ok {
+			if string(df.Data()) != "frame" {
+				t.Errorf("Expected echoed frame, got %q", df.Data())
+			}
+			return
+		}
+	}
+}
+
+// 路由规则要求拦截时，HTTP/2 的 CONNECT 流也由本地 CA 终止 TLS，隧道内的请求经内部 HTTP 代理流程转发到源站
+func TestServeHTTP2ConnectIntercepted(t *testing.T) {
+	SetH2CPriorKnowledge(true)
+	defer SetH2CPriorKnowledge(false)
+	defer http_server.InvalidateTransports()
+
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "origin "+r.URL.Path)
+	}))
+	defer origin.Close()
+	// 没有 DNS 选项时代理到源站使用 http.DefaultTransport 的副本，让它信任测试源站的证书
+	originPool := x509.NewCertPool()
+	originPool.AddCert(origin.Certificate())
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	oldTLSConfig := defaultTransport.TLSClientConfig
+	defaultTransport.TLSClientConfig = &tls.Config{RootCAs: originPool}
+	defer func() { defaultTransport.TLSClientConfig = oldTLSConfig }()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test MITM CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+	ca, err := mitm.NewCA(caCert, key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm.SetCA(ca)
+	defer mitm.SetCA(nil)
+	mitm.SetInterceptSelector(func(host string) bool { return host == "127.0.0.1" })
+	defer mitm.SetInterceptSelector(nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go HandleWithAuthenticator(conn, nil, "", nil, nil, nil, false, options.ParseIPPriority("random"))
+		}
+	}()
+
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial("tcp", l.Addr().String())
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	target := strings.TrimPrefix(origin.URL, "https://")
+	pr, pw := io.Pipe()
+	resp, err := transport.RoundTrip(&http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Scheme: "http", Host: target},
+		Host:   target,
+		Header: http.Header{},
+		Body:   pr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for CONNECT, got %d", resp.StatusCode)
+	}
+
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(caCert)
+	conn := tls.Client(&pipeConn{Reader: resp.Body, WriteCloser: pw}, &tls.Config{RootCAs: clientPool, ServerName: "127.0.0.1"})
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if issuer := conn.ConnectionState().PeerCertificates[0].Issuer.CommonName; issuer != "test MITM CA" {
+		t.Errorf("Expected certificate issued by the MITM CA, got %q", issuer)
+	}
+	io.WriteString(conn, "GET /intercepted HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	got, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(got.Body)
+	got.Body.Close()
+	if got.StatusCode != http.StatusOK || string(body) != "origin /intercepted" {
+		t.Errorf("Unexpected response %d %q", got.StatusCode, body)
+	}
+}
+
+// pipeConn 把 CONNECT 流的响应体和请求体拼成客户端一侧的连接
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+	net.Conn
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)       { return c.Reader.Read(p) }
+func (c *pipeConn) Write(p []byte) (int, error)      { return c.WriteCloser.Write(p) }
+func (c *pipeConn) Close() error                     { return c.WriteCloser.Close() }
+func (c *pipeConn) SetDeadline(time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,163 @@
+package simple
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/masx200/http-proxy-go-server/connect"
+	"github.com/masx200/http-proxy-go-server/dnscache"
+	"github.com/masx200/http-proxy-go-server/doh"
+	"github.com/masx200/http-proxy-go-server/options"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// masqueUDPPathPrefix RFC 9298 默认 URI 模板 /.well-known/masque/udp/{target_host}/{target_port}/ 的前缀
+const masqueUDPPathPrefix = "/.well-known/masque/udp/"
+
+// maxUDPPayload 转发的 UDP 载荷上限，超过 QUIC 数据报大小的包会被丢弃
+const maxUDPPayload = 65535
+
+// NewMASQUEHandler 创建 HTTP/3 MASQUE 代理的 http.Handler：CONNECT 为每个流建立 TCP 隧道，
+// connect-udp（RFC 9298）通过 HTTP Datagram（RFC 9297）转发 UDP，其它请求与 HTTP/2 入站相同。
+// 身份验证、路由规则和上游代理与 TCP 监听端口共用；http3.Server 需要开启 EnableDatagrams
+func NewMASQUEHandler(localAddr string, authenticate Authenticator, httpUpstreamAddress string, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) http.Handler {
+	return newStreamHandler(localAddr, authenticate, httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+}
+
+// parseMASQUEUDPTarget 从默认 URI 模板的路径中解析 UDP 目标 host:port，IPv6 地址中的冒号编码为 %3A
+func parseMASQUEUDPTarget(u *url.URL) (string, error) {
+	rest, ok := strings.CutPrefix(u.EscapedPath(), masqueUDPPathPrefix)
+	if !ok {
+		return "", fmt.Errorf("unsupported connect-udp path %s", u.Path)
+	}
+	parts := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid connect-udp path %s", u.Path)
+	}
+	host, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return "", err
+	}
+	port, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return "", err
+	}
+	if n, err := strconv.Atoi(port); host == "" || err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid connect-udp target %s:%s", host, port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// serveConnectUDP 处理 connect-udp：连接 UDP 目标后，Context ID 为 0 的 HTTP Datagram 载荷
+// 与目标的 UDP 包双向转发，请求流关闭或会话空闲超时时结束
+func (h *streamHandler) serveConnectUDP(w http.ResponseWriter, req *http.Request) {
+	streamer, ok := w.(http3.HTTPStreamer)
+	if !ok {
+		http.Error(w, "connect-udp requires HTTP/3 datagrams", http.StatusNotImplemented)
+		return
+	}
+	target, err := parseMASQUEUDPTarget(req.URL)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// fake-IP 模式下将目标地址还原为域名后解析
+	target = h.dnsCache.RestoreFakeIPAddress(target)
+	log.Println("connect-udp:", target)
+	conn, err := h.dialUDP(req.Context(), req.RemoteAddr, target)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(options.SetProxyErrorHeaders(w.Header(), err))
+		return
+	}
+
+	w.Header().Set("Capsule-Protocol", "?1")
+	w.WriteHeader(http.StatusOK)
+	http.NewResponseController(w).Flush()
+	str := streamer.HTTPStream()
+	defer str.Close()
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	// 登记会话，两个方向都空闲超过超时时间后结束转发
+	session := newUDPSession(fmt.Sprintf("%s|%d|%s", req.RemoteAddr, str.StreamID(), target), conn, cancel)
+	defer session.close()
+	// 流上只会收到 capsule，未知的 capsule 直接丢弃；流关闭表示客户端结束了会话
+	go func() {
+		io.Copy(io.Discard, str)
+		cancel()
+	}()
+	go func() {
+		defer cancel()
+		// 目标 -> 客户端：载荷前加上 Context ID 0
+		buf := make([]byte, 1+maxUDPPayload)
+		for {
+			n, err := conn.Read(buf[1:])
+			if err != nil {
+				return
+			}
+			session.touch()
+			if err := str.SendDatagram(buf[:1+n]); err != nil {
+				log.Println("connect-udp: dropping datagram:", err)
+			}
+		}
+	}()
+	for {
+		data, err := str.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		contextID, n, err := quicvarint.Parse(data)
+		if err != nil || contextID != 0 {
+			// 未知 Context ID 的数据报按 RFC 9298 丢弃
+			continue
+		}
+		session.touch()
+		if _, err := conn.Write(data[n:]); err != nil {
+			log.Println("connect-udp:", err)
+		}
+	}
+}
+
+// dialUDP 按路由规则连接 UDP 目标：未选中上游代理时直连，选中 SOCKS5 上游时通过 UDP ASSOCIATE 转发；
+// HTTP 和 WebSocket 上游代理无法转发 UDP，返回错误而不是直连，以免绕过代理
+func (h *streamHandler) dialUDP(ctx context.Context, remoteAddr string, target string) (net.Conn, error) {
+	proxyURL, err := CheckShouldUseProxy(target, h.Proxy, h.tranportConfigurations...)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dnscache.Proxy_net_DialContextCached(doh.WithClientAddr(ctx, remoteAddr), "udp", target, h.proxyoptions, h.dnsCache, h.upstreamResolveIPs, h.Proxy, h.tranportConfigurations...)
+	}
+	if proxyURL.Scheme != "socks5" {
+		return nil, fmt.Errorf("connect-udp to %s cannot be forwarded through upstream proxy %s", target, proxyURL.Redacted())
+	}
+
+	upstreamKey := options.UpstreamCircuitKey(proxyURL)
+	if err := options.CircuitBreakerAllow(upstreamKey); err != nil {
+		return nil, err
+	}
+	// 如果启用了DNS解析，先解析目标地址，否则由 SOCKS5 代理解析域名
+	resolvedAddrs, err := resolveTargetAddressForSimple(target, h.Proxy, h.proxyoptions, h.dnsCache, h.upstreamResolveIPs, h.ipPriority, h.tranportConfigurations...)
+	if err != nil {
+		log.Printf("Failed to resolve UDP target address %s: %v, using original", target, err)
+		resolvedAddrs = []string{target}
+	}
+	resolvedAddr := resolveTargetAddressForSimpleWithRoundRobin(resolvedAddrs, target, h.ipPriority)
+	log.Printf("connect-udp to %s via SOCKS5 proxy %s", resolvedAddr, proxyURL.Redacted())
+	conn, err := connect.DialUDPViaSOCKS5(ctx, proxyURL, resolvedAddr)
+	options.ReportUpstreamResult(upstreamKey, err)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,39 @@
+package simple
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseMASQUEUDPTarget(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "/.well-known/masque/udp/example.com/443/", want: "example.com:443"},
+		{path: "/.well-known/masque/udp/192.0.2.1/53/", want: "192.0.2.1:53"},
+		{path: "/.well-known/masque/udp/2001%3Adb8%3A%3A1/53/", want: "[2001:db8::1]:53"},
+		{path: "/.well-known/masque/udp/example.com/443", want: "example.com:443"},
+		{path: "/.well-known/masque/udp/example.com/0/", wantErr: true},
+		{path: "/.well-known/masque/udp/example.com/", wantErr: true},
+		{path: "/.well-known/masque/udp//53/", wantErr: true},
+		{path: "/masque/example.com/443/", wantErr: true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse("https://proxy.example" + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseMASQUEUDPTarget(u)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package simple
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -18,12 +20,15 @@ import (
 
 	"github.com/masx200/http-proxy-go-server/connect"
 	"github.com/masx200/http-proxy-go-server/dnscache"
+	"github.com/masx200/http-proxy-go-server/doh"
 	http_server "github.com/masx200/http-proxy-go-server/http"
+	"github.com/masx200/http-proxy-go-server/mitm"
 	"github.com/masx200/http-proxy-go-server/options"
 	"github.com/masx200/http-proxy-go-server/utils"
 	"github.com/masx200/socks5-websocket-proxy-golang/pkg/interfaces"
 	"github.com/masx200/socks5-websocket-proxy-golang/pkg/socks5"
 	socks5_websocket_proxy_golang_websocket "github.com/masx200/socks5-websocket-proxy-golang/pkg/websocket"
+	"golang.org/x/net/http2"
 )
 
 func Simple(hostname string, port int, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) {
@@ -86,6 +91,56 @@ func CheckShouldUseProxy(upstreamAddress string, Proxy func(*http.Request) (*url
 }
 
 func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) {
+	HandleWithAuthenticator(client, nil, httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+}
+
+// Authenticator 检查请求的代理身份验证，失败时返回 false，由调用方按客户端的协议回复 407
+type Authenticator func(req *http.Request) bool
+
+// writeProxyAuthRequired 向 HTTP/1.x 客户端写出 407 响应
+func writeProxyAuthRequired(client net.Conn, req *http.Request) {
+	const body = "407 Proxy Authentication Required"
+	resp := &http.Response{
+		StatusCode: http.StatusProxyAuthRequired,
+		Status:     body,
+		Header: http.Header{
+			"Content-Length":     []string{strconv.Itoa(len(body))},
+			"Proxy-Authenticate": []string{"Basic realm=\"Proxy\""},
+		},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Close:         req.Close,
+	}
+	resp.Write(client)
+}
+
+// upstreamConn 转发 HTTP 请求使用的上游连接，目标地址不变时在同一客户端连接的多个请求之间复用
+type upstreamConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	address string
+}
+
+// reusable 检查空闲的上游连接是否仍然可用（对端没有关闭，也没有多余的数据）
+func (c *upstreamConn) reusable() bool {
+	if c.reader.Buffered() > 0 {
+		return false
+	}
+	c.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := c.reader.Peek(1)
+	c.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// HandleWithAuthenticator 处理一个客户端连接：用 http.ReadRequest 逐个读取请求，
+// CONNECT 请求建立隧道后转发原始数据；其它请求逐个改写为 origin-form 并附加 Forwarded 头后转发，
+// 每个请求按自己的目标地址选择路由，目标不变时复用上游连接。
+// 请求按顺序处理、响应按顺序返回，因此同一连接上的 keep-alive 和 pipelining 请求都能正确转发。
+// authenticate 为 nil 时不做身份验证。
+func HandleWithAuthenticator(client net.Conn, authenticate Authenticator, httpUpstreamAddress string, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) {
 	if client == nil {
 		return
 	}
@@ -93,67 +148,217 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 
 	log.Printf("remote addr: %v\n", client.RemoteAddr())
 
-	// 用来存放客户端数据的缓冲区
-	var b [10240]byte
-	//从客户端获取数据
-	n, err := client.Read(b[:])
-	if err != nil {
-		log.Println(err)
-		fmt.Fprint(client, "HTTP/1.1 400 Bad Request\r\n\r\n")
-		return
-	}
-
-	var method, URL, address string
-	// 从客户端数据读入 method，url
-	newVar := bytes.IndexByte(b[:], '\n')
-
-	if newVar == -1 {
-		fmt.Fprint(client, "HTTP/1.1 400 Bad Request\r\n\r\n")
-		log.Println("400 Bad Request,not http request")
-		return
+	// TLS 上协商出 h2 的连接按 HTTP/2 处理
+	if tlsConn, ok := client.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			log.Println("TLS handshake failed:", err)
+			return
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+			ServeHTTP2(client, authenticate, httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+			return
+		}
 	}
-	fmt.Sscanf(string(b[:newVar]), "%s%s", &method, &URL)
-	log.Println(string(b[:bytes.IndexByte(b[:], '\n')]))
-	// hostPortURL, err := url.Parse(URL)
-	// if err != nil {
-	// 	log.Println(err)
-	// 	return
-	// }
 
-	// 如果方法是 CONNECT，则为 https 协议
-	if method == "CONNECT" {
-		// hostPortURL, err := url.Parse(URL)
-		// if err != nil {
-		// 	log.Println(err)
-		// 	return
-		// }
-		var line = string(b[:bytes.IndexByte(b[:], '\n')])
-		address = ExtractAddressFromConnectRequestLine(line)
-
-		//hostPortURL.Scheme + ":" + hostPortURL.Opaque
-	} else { //否则为 http 协议
-		var line = string(b[:bytes.IndexByte(b[:], '\n')])
-
-		// hostPortURL, err := url.Parse(line[7+1 : len(line)-9-1])
-		// if err != nil {
-		// 	log.Println(err)
-		// 	return
-		// }
-		// address = hostPortURL.Host
-		// // 如果 host 不带端口，则默认为 80
-		// if !strings.Contains(hostPortURL.Host, ":") { //host 不带端口， 默认 80
-		// 	address = hostPortURL.Host + ":80"
-		// }
-		address, err = ExtractAddressFromOtherRequestLine(line)
+	reader := bufio.NewReader(client)
+	var server *upstreamConn
+	defer func() {
+		if server != nil {
+			server.Close()
+		}
+	}()
+	for {
+		req, err := http.ReadRequest(reader)
 		if err != nil {
+			// 客户端关闭了空闲的 keep-alive 连接
+			if err == io.EOF || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return
+			}
+			log.Println("Error parsing request:", err)
 			fmt.Fprint(client, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		if isHTTP2Preface(req) && h2cPriorKnowledge.Load() {
+			// h2c prior knowledge：把读出的连接前言放回去后按 HTTP/2 处理
+			ServeHTTP2(&prefaceConn{Conn: client, reader: io.MultiReader(strings.NewReader(http2Preface), reader)}, authenticate, httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+			return
+		}
+		log.Println(req.Method, req.RequestURI, req.Proto)
+
+		// 身份验证会删除 Proxy-Authorization，先保存下来交给内部 HTTP 代理
+		proxyAuthorization := req.Header.Get("Proxy-Authorization")
+		if authenticate != nil && !authenticate(req) {
+			log.Println("身份验证失败")
+			writeProxyAuthRequired(client, req)
+			// 丢弃请求体，客户端可以在同一连接上带凭据重试
+			io.Copy(io.Discard, req.Body)
+			if req.Close {
+				return
+			}
+			continue
+		}
+
+		address, err := RequestAddress(req)
+		if err != nil {
 			log.Println(err)
+			fmt.Fprint(client, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		// fake-IP 模式下将目标地址还原为域名，交给路由规则和上游代理解析
+		address = dnsCache.RestoreFakeIPAddress(address)
+		log.Println("address:" + address)
+
+		if req.Method == http.MethodConnect {
+			if ca, ok := mitm.ShouldIntercept(address); ok {
+				// 路由规则要求拦截：由本代理终止 TLS，解密后的请求交给内部 HTTP 代理的处理流程
+				fmt.Fprint(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+				http_server.ServeIntercepted(&prefaceConn{Conn: client, reader: reader}, address, ca, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, Proxy, tranportConfigurations...)
+				return
+			}
+			conn, err := dialServer(client.RemoteAddr().String(), req.Method, address, httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+			if err != nil {
+				options.WriteProxyError(client, err)
+				return
+			}
+			defer conn.Close()
+			fmt.Fprint(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+			// 客户端紧跟 CONNECT 请求发送、已被读入缓冲区的数据先转发出去
+			relay(client, reader, conn, conn)
+			return
+		}
+
+		if server != nil && (server.address != address || !server.reusable()) {
+			server.Close()
+			server = nil
+		}
+		if server == nil {
+			conn, err := dialServer(client.RemoteAddr().String(), req.Method, address, httpUpstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority, tranportConfigurations...)
+			if err != nil {
+				options.WriteProxyError(client, err)
+				return
+			}
+			server = &upstreamConn{Conn: conn, reader: bufio.NewReader(conn), address: address}
+		}
+		if !forwardRequest(client, reader, req, server, address, httpUpstreamAddress != "", proxyAuthorization) {
 			return
 		}
 	}
-	log.Println("address:" + address)
-	//获得了请求的 host 和 port，向服务端发起 tcp 连接
+}
+
+// RequestAddress 返回请求的目标 host:port：CONNECT 使用请求目标，其它请求使用绝对 URI 中的主机，
+// 没有时使用 Host 头，未指定端口时按协议使用默认端口
+func RequestAddress(req *http.Request) (string, error) {
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+	if host == "" {
+		return "", fmt.Errorf("missing target host in request %s %s", req.Method, req.RequestURI)
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host, nil
+	}
+	if req.Method == http.MethodConnect {
+		return "", fmt.Errorf("missing port in CONNECT target %s", host)
+	}
+	port := "80"
+	if req.URL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+}
+
+// forwardRequest 把一个请求转发给上游并把响应写回客户端，返回是否可以继续在连接上处理下一个请求。
+// internal 为 true 时下一跳是内部 HTTP 代理，附带客户端地址和不为空的 proxyAuthorization 凭据
+func forwardRequest(client net.Conn, clientReader *bufio.Reader, req *http.Request, server *upstreamConn, address string, internal bool, proxyAuthorization string) bool {
+	clienthost, _, err := net.SplitHostPort(client.RemoteAddr().String())
+	if err != nil {
+		clienthost = client.RemoteAddr().String()
+	}
+	forwarded := fmt.Sprintf(
+		"for=%s;by=%s;host=%s;proto=%s",
+		clienthost,                  // 客户端地址
+		client.LocalAddr().String(), // 代理的标识
+		address,                     // 原始请求的目标主机名
+		"http",
+	)
+	// 删除逐跳头，按设置附加 Forwarded、Via、X-Forwarded-For，匿名模式下删除所有标识头
+	options.PrepareForwardRequestHeaders(req.Header, req.ProtoMajor, req.ProtoMinor, clienthost, forwarded)
+	if internal {
+		req.Header.Set(options.ClientAddrHeader, client.RemoteAddr().String())
+		if proxyAuthorization != "" {
+			req.Header.Set("Proxy-Authorization", proxyAuthorization)
+		}
+	}
+	/* 有的服务器不支持这种 "GET http://speedtest.cn/ HTTP/1.1"，req.Write 使用 origin-form */
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// 避免 req.Write 补上默认的 Go User-Agent
+		req.Header.Set("User-Agent", "")
+	}
+
+	// 请求体和响应并发转发，Expect: 100-continue 时上游的 100 响应可以先返回给客户端
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- req.Write(server)
+	}()
+	for {
+		resp, err := http.ReadResponse(server.reader, req)
+		if err != nil {
+			log.Println("Error reading response from server:", err)
+			options.WriteProxyError(client, err)
+			return false
+		}
+		options.PrepareForwardResponseHeaders(resp.Header, resp.StatusCode, resp.ProtoMajor, resp.ProtoMinor)
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			// 协议升级（如 WebSocket）之后转发原始数据
+			if err := resp.Write(client); err != nil {
+				return false
+			}
+			relay(client, clientReader, server.Conn, server.reader)
+			return false
+		}
+		err = resp.Write(client)
+		resp.Body.Close()
+		if err != nil {
+			log.Println("Error writing response to client:", err)
+			return false
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 {
+			// 1xx 中间响应之后还有最终响应
+			continue
+		}
+		if err := <-writeErr; err != nil {
+			log.Println("Error writing request to server:", err)
+			return false
+		}
+		return !req.Close && !resp.Close
+	}
+}
+
+// relay 双向转发客户端和上游之间的原始数据，clientReader/serverReader 中已缓冲的数据会先发出
+func relay(client net.Conn, clientReader io.Reader, server net.Conn, serverReader io.Reader) {
+	// 使用双向goroutine并等待，避免goroutine泄漏
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(server, clientReader)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(client, serverReader)
+		errCh <- err
+	}()
+	// 等待任意一个方向完成（通常是一方关闭连接）
+	<-errCh
+	// 关闭连接以触发另一个方向也快速返回
+	server.Close()
+	// 等待另一个方向也完成
+	<-errCh
+	client.Close()
+}
 
+// dialServer 按目标地址和路由规则选择直连、HTTP/SOCKS5/WebSocket 上游代理或内部 HTTP 代理服务器并建立连接，
+// remoteAddr 是客户端地址；失败时由调用方按客户端的协议写出错误响应
+func dialServer(remoteAddr string, method string, address string, httpUpstreamAddress string, Proxy func(*http.Request) (*url.URL, error), proxyoptions options.ProxyOptionsDNSSLICE, dnsCache *dnscache.DNSCache, upstreamResolveIPs bool, ipPriority options.IPPriority, tranportConfigurations ...func(*http.Transport) *http.Transport) (net.Conn, error) {
 	var upstreamAddress string
 	if method == "CONNECT" {
 		upstreamAddress = address
@@ -174,7 +379,17 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 
 	if err != nil {
 		log.Println(err)
-		return
+		return nil, err
+	}
+
+	// 上游代理连续连接失败时由熔断器直接拒绝，不再等待每个请求超时
+	var upstreamKey string
+	if proxyURL != nil && (proxyURL.Scheme == "ws" || proxyURL.Scheme == "wss" || method == "CONNECT" || httpUpstreamAddress == "") {
+		upstreamKey = options.UpstreamCircuitKey(proxyURL)
+		if err := options.CircuitBreakerAllow(upstreamKey); err != nil {
+			log.Println(err)
+			return nil, err
+		}
 	}
 
 	// 检查是否需要使用WebSocket代理
@@ -193,8 +408,7 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 			host, port, err = net.SplitHostPort(upstreamAddress)
 			if err != nil {
 				log.Println("failed to parse address:", err)
-				fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-				return
+				return nil, err
 			}
 		}
 
@@ -202,8 +416,7 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 		portNum, err := strconv.Atoi(port)
 		if err != nil {
 			log.Println("failed to parse port:", err)
-			fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-			return
+			return nil, err
 		}
 
 		// 创建WebSocket客户端配置
@@ -233,8 +446,8 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 		err = websocketClient.Connect(host, portNum)
 		if err != nil {
 			log.Println("failed to connect via WebSocket proxy:", err)
-			fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-			return
+			options.ReportUpstreamResult(upstreamKey, err)
+			return nil, err
 		}
 
 		// 创建一个管道连接来处理WebSocket数据转发
@@ -253,7 +466,6 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 		}()
 
 		server = clientConn
-		defer server.Close() // 确保WebSocket连接也被关闭，避免资源泄漏
 		log.Println("WebSocket代理连接成功：" + upstreamAddress)
 	} else if proxyURL != nil && (method == "CONNECT" || (method != "CONNECT" && httpUpstreamAddress == "")) {
 		// 检查是否是SOCKS5代理 (适用于CONNECT请求和SOCKS5直接模式的HTTP请求)
@@ -267,16 +479,14 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 			host, port, err := net.SplitHostPort(upstreamAddress)
 			if err != nil {
 				log.Println("failed to parse address:", err)
-				fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-				return
+				return nil, err
 			}
 
 			// 转换端口号为整数
 			portNum, err := strconv.Atoi(port)
 			if err != nil {
 				log.Println("failed to parse port:", err)
-				fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-				return
+				return nil, err
 			}
 
 			// 从代理URL中提取主机和端口
@@ -332,15 +542,13 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 				resolvedHost, resolvedPort, err := net.SplitHostPort(resolvedAddr)
 				if err != nil {
 					log.Println("failed to parse resolved address:", err)
-					fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-					return
+					return nil, err
 				}
 				host = resolvedHost
 				portNum, err = strconv.Atoi(resolvedPort)
 				if err != nil {
 					log.Println("failed to parse resolved port:", err)
-					fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-					return
+					return nil, err
 				}
 			}
 
@@ -354,25 +562,23 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 					originalHost, originalPort, err := net.SplitHostPort(targetAddr)
 					if err != nil {
 						log.Println("failed to parse original address:", err)
-						fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-						return
+						return nil, err
 					}
 					originalPortNum, err := strconv.Atoi(originalPort)
 					if err != nil {
 						log.Println("failed to parse original port:", err)
-						fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-						return
+						return nil, err
 					}
 					err = socks5Client.Connect(originalHost, originalPortNum)
 					if err != nil {
 						log.Println("failed to connect via SOCKS5 proxy with original address:", err)
-						fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-						return
+						options.ReportUpstreamResult(upstreamKey, err)
+						return nil, err
 					}
 					log.Printf("SOCKS5 connection succeeded with original address %s", targetAddr)
 				} else {
-					fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-					return
+					options.ReportUpstreamResult(upstreamKey, err)
+					return nil, err
 				}
 			}
 
@@ -392,110 +598,32 @@ func Handle(client net.Conn, httpUpstreamAddress string, Proxy func(*http.Reques
 			}()
 
 			server = clientConn
-			defer server.Close() // 确保SOCKS5连接也被关闭，避免资源泄漏
 			log.Printf("SOCKS5代理连接成功 (%s请求): %s", requestType, upstreamAddress)
 		} else {
 			// 使用HTTP代理处理CONNECT请求
 			server, err = connect.ConnectViaHttpProxy(proxyURL, upstreamAddress, Proxy, proxyoptions, dnsCache, upstreamResolveIPs, ipPriority)
 			if err != nil {
 				log.Println(err)
-				fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-				return
+				options.ReportUpstreamResult(upstreamKey, err)
+				return nil, err
 			}
-			defer server.Close() // 确保连接被关闭，避免资源泄漏
 			log.Println("连接成功：" + upstreamAddress)
 		}
 	} else {
 		// log.Println("upstreamAddress:" + httpUpstreamAddress)
-		server, err = dnscache.Proxy_net_DialCached("tcp", upstreamAddress, proxyoptions, upstreamResolveIPs, dnsCache, Proxy, tranportConfigurations...) //net.Dial("tcp", upstreamAddress)
+		// 记录客户端地址，用于按客户端生成 EDNS Client Subnet
+		clientCtx := doh.WithClientAddr(context.Background(), remoteAddr)
+		server, err = dnscache.Proxy_net_DialContextCached(clientCtx, "tcp", upstreamAddress, proxyoptions, dnsCache, upstreamResolveIPs, Proxy, tranportConfigurations...) //net.Dial("tcp", upstreamAddress)
 		if err != nil {
 			log.Println(err)
-			fmt.Fprint(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
-			return
+			return nil, err
 		}
-		defer server.Close() // 确保直接连接也被关闭，避免资源泄漏
 		log.Println("连接成功：" + upstreamAddress)
 	}
-	//如果使用 https 协议，需先向客户端表示连接建立完毕
-	if method == "CONNECT" {
-		fmt.Fprint(client, "HTTP/1.1 200 Connection established\r\n\r\n")
-	} else {
-		var requestLine = string(b[:bytes.IndexByte(b[:], '\n')+1])
-		//如果使用 http 协议，需将从客户端得到的 http 请求转发给服务端
-		clienthost, port, err := net.SplitHostPort(client.RemoteAddr().String())
-		if err != nil {
-			fmt.Fprint(client, "HTTP/1.1 400 Bad Request\r\n\r\n")
-			log.Println(err)
-			return
-		}
-		log.Println("clienthost:", clienthost)
-		log.Println("clientport:", port)
-		forwarded := fmt.Sprintf(
-			"for=%s;by=%s;host=%s;proto=%s",
-			clienthost,                  // 代理自己的标识或IP地址
-			client.LocalAddr().String(), // 代理的标识
-			address,                     // 原始请求的目标主机名
-			"http",                      // 或者 "https" 根据实际协议
-		)
-		var headers map[string]string = map[string]string{"Forwarded": forwarded}
-		shouldReturn := WriteRequestLineAndHeadersWithRequestURI(requestLine, server, n, b, headers)
-		if shouldReturn {
-			fmt.Fprint(client, "HTTP/1.1 500 Internal Server Error\r\n\r\n")
-			return
-		}
-	}
-
-	//将客户端的请求转发至服务端，将服务端的响应转发给客户端。io.Copy 为阻塞函数，文件描述符不关闭就不停止
-	// 使用双向goroutine并等待，避免goroutine泄漏
-	errCh := make(chan error, 2)
-	go func() {
-		_, err := io.Copy(server, client)
-		errCh <- err
-	}()
-	go func() {
-		_, err := io.Copy(client, server)
-		errCh <- err
-	}()
-	// 等待任意一个方向完成（通常是一方关闭连接）
-	<-errCh
-	// 关闭连接以触发另一个方向也快速返回
-	if server != nil {
-		server.Close()
-	}
-	// 等待另一个方向也完成
-	<-errCh
-	// 确保客户端连接也被关闭，避免资源泄漏
-	if client != nil {
-		client.Close()
+	if upstreamKey != "" {
+		options.ReportUpstreamResult(upstreamKey, nil)
 	}
-}
-
-// WriteRequestLineAndHeadersWithRequestURI 将请求行和头部信息写入服务器连接
-func WriteRequestLineAndHeadersWithRequestURI(requestLine string, server net.Conn, n int, b [10240]byte, headers map[string]string) bool {
-	/*有的服务器不支持这种 "GET http://speedtest.cn/ HTTP/1.1" */
-	output, err := RemoveURLPartsLeaveMethodRequestURIVersion(requestLine)
-	if err != nil {
-		log.Println(err)
-		return true
-	}
-	log.Println("simple Handle", "header:")
-	for k, v := range headers {
-		// log.Println("key:", k)
-		log.Println("simple Handle", k, ":", v)
-	}
-	log.Println(output)
-	server.Write([]byte(output))
-
-	for k, v := range headers {
-		server.Write([]byte(k + ": " + v + "\r\n"))
-		log.Println(string([]byte(k + ": " + v + "\r\n")))
-	}
-	// server.Write()
-	// server.Write([]byte("\r\n"))
-	server.Write(b[len(requestLine):n])
-	// server.Write(append([]byte(output), b[len(requestLine):n]...))
-	log.Println(string(b[len(requestLine):n]))
-	return false
+	return server, nil
 }
 
 func ExtractAddressFromOtherRequestLine(line string) (string, error) {
@@ -0,0 +1,173 @@
+package simple
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	http_server "github.com/masx200/http-proxy-go-server/http"
+	"github.com/masx200/http-proxy-go-server/options"
+)
+
+func TestHandleKeepAlivePipelining(t *testing.T) {
+	newOrigin := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s forwarded=%t big=%d", name, r.RequestURI, r.Header.Get("Forwarded") != "", len(r.Header.Get("X-Big")))
+		}))
+	}
+	a, b := newOrigin("a"), newOrigin("b")
+	defer a.Close()
+	defer b.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		Handle(conn, "", nil, nil, nil, false, options.ParseIPPriority("random"))
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hostA := strings.TrimPrefix(a.URL, "http://")
+	hostB := strings.TrimPrefix(b.URL, "http://")
+	// 三个请求一次发出：超过 10KB 的请求头、切换目标、再切换回来
+	big := strings.Repeat("x", 20*1024)
+	pipelined := "GET http://" + hostA + "/one HTTP/1.1\r\nHost: " + hostA + "\r\nX-Big: " + big + "\r\n\r\n" +
+		"GET http://" + hostB + "/two?q=1 HTTP/1.1\r\nHost: " + hostB + "\r\n\r\n" +
+		"GET http://" + hostA + "/three HTTP/1.1\r\nHost: " + hostA + "\r\n\r\n"
+	if _, err := io.WriteString(conn, pipelined); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{
+		"a /one forwarded=true big=20480",
+		"b /two?q=1 forwarded=true big=0",
+		"a /three forwarded=true big=0",
+	} {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response for %q: %v", want, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("Expected %q, got %q", want, body)
+		}
+	}
+}
+
+// 经内部 HTTP 代理转发时标识头只由前端附加一次，X-Forwarded-For 中没有内部代理的回环地址
+func TestHandleIdentifyingHeadersOnce(t *testing.T) {
+	options.SetForwardHeaders(options.ForwardHeadersConfig{Via: "me", XForwardedFor: true})
+	defer options.SetForwardHeaders(options.ForwardHeadersConfig{Via: options.DefaultViaPseudonym})
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "via=%s xff=%s forwarded=%d client=%s", r.Header.Get("Via"), r.Header.Get("X-Forwarded-For"), len(r.Header.Values("Forwarded")), r.Header.Get(options.ClientAddrHeader))
+	}))
+	defer origin.Close()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	go http_server.Http("127.0.0.2", port, nil, nil, "", "", false, options.ParseIPPriority("random"), nil)
+	upstreamAddress := net.JoinHostPort("127.0.0.2", strconv.Itoa(port))
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", upstreamAddress)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		Handle(conn, upstreamAddress, nil, nil, nil, false, options.ParseIPPriority("random"))
+	}()
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: l.Addr().String()})}}
+	req, _ := http.NewRequest(http.MethodGet, origin.URL, nil)
+	req.Header.Set(options.ClientAddrHeader, "192.0.2.1:1234") // 客户端伪造的客户端地址不会被转发
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := "via=1.1 me xff=127.0.0.1 forwarded=1 client="; string(body) != want {
+		t.Errorf("Expected %q, got %q", want, body)
+	}
+	if via := resp.Header.Get("Via"); via != "1.1 me" {
+		t.Errorf("Expected Via %q on the response, got %q", "1.1 me", via)
+	}
+}
+
+// 前端把请求转发给内部 HTTP 代理时带上真实的客户端地址，客户端自己发来的同名头被替换
+func TestHandleSendsClientAddrToInternalProxy(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Join(r.Header.Values(options.ClientAddrHeader), ","))
+	}))
+	defer internal.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		Handle(conn, strings.TrimPrefix(internal.URL, "http://"), nil, nil, nil, false, options.ParseIPPriority("random"))
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n"+options.ClientAddrHeader+": 192.0.2.1:1234\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := conn.LocalAddr().String(); string(body) != want {
+		t.Errorf("Expected client address %q at the internal proxy, got %q", want, body)
+	}
+}
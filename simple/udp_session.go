@@ -0,0 +1,94 @@
+package simple
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUDPSessionIdleTimeout UDP 会话默认的空闲超时，两个方向都没有数据报超过这个时间后关闭会话
+const DefaultUDPSessionIdleTimeout = 2 * time.Minute
+
+var udpSessionIdleTimeout atomic.Int64
+
+// SetUDPSessionIdleTimeout 设置 UDP 会话的空闲超时，d <= 0 时恢复默认值
+func SetUDPSessionIdleTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultUDPSessionIdleTimeout
+	}
+	udpSessionIdleTimeout.Store(int64(d))
+}
+
+// UDPSessionIdleTimeout 返回当前的 UDP 会话空闲超时
+func UDPSessionIdleTimeout() time.Duration {
+	if d := udpSessionIdleTimeout.Load(); d > 0 {
+		return time.Duration(d)
+	}
+	return DefaultUDPSessionIdleTimeout
+}
+
+// udpSessions 活动的 UDP 会话，类似 NAT 映射表：键为 客户端地址|流|目标，值为 *udpSession
+var udpSessions sync.Map
+
+// UDPSessionCount 返回当前活动的 UDP 会话数
+func UDPSessionCount() int {
+	n := 0
+	udpSessions.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// udpSession 一个客户端 UDP 流到目标（直连或经上游 SOCKS5 UDP 关联）的映射，
+// 空闲超时后调用 onIdle 结束转发
+type udpSession struct {
+	key        string
+	conn       net.Conn
+	onIdle     func()
+	lastActive atomic.Int64
+	// mu 保护 timer，计时器回调可能在 newUDPSession 返回前触发
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// newUDPSession 登记会话并开始空闲计时
+func newUDPSession(key string, conn net.Conn, onIdle func()) *udpSession {
+	s := &udpSession{key: key, conn: conn, onIdle: onIdle}
+	s.touch()
+	udpSessions.Store(key, s)
+	s.mu.Lock()
+	s.timer = time.AfterFunc(UDPSessionIdleTimeout(), s.checkIdle)
+	s.mu.Unlock()
+	return s
+}
+
+// touch 记录会话上的一次活动
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// checkIdle 空闲时间达到超时则结束会话，否则在剩余时间后再检查
+func (s *udpSession) checkIdle() {
+	timeout := UDPSessionIdleTimeout()
+	idle := time.Since(time.Unix(0, s.lastActive.Load()))
+	if idle >= timeout {
+		log.Printf("UDP session %s idle for %v, closing", s.key, idle.Round(time.Second))
+		s.onIdle()
+		return
+	}
+	s.mu.Lock()
+	s.timer.Reset(timeout - idle)
+	s.mu.Unlock()
+}
+
+// close 注销会话并关闭到目标的连接
+func (s *udpSession) close() {
+	s.mu.Lock()
+	s.timer.Stop()
+	s.mu.Unlock()
+	udpSessions.Delete(s.key)
+	s.conn.Close()
+}
@@ -20,7 +20,8 @@ func Tls(server_cert string, server_key, hostname string, port int, Proxy func(*
 		log.Println(err)
 		return
 	}
-	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	// 通过 ALPN 提供 h2，支持 HTTP/2 的客户端可以在一个连接上复用多个 CONNECT 隧道
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}}
 	ln, err := tls.Listen("tcp", hostname+":"+fmt.Sprint(port), config)
 	// tcp 连接，监听 8080 端口
 	// l, err := net.Listen("tcp", ":8080")